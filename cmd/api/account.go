@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/biboyqg/social/internal/mailer"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var errReloginRequired = errors.New("sign in again with your identity provider to confirm this change")
+
+// confirmPassword checks the current password of the user before a sensitive
+// change. Users created through an OIDC provider have none, so a recent
+// sign-in takes its place: the session must have started within the relogin
+// window.
+func (app *application) confirmPassword(r *http.Request, user *store.User, password string) error {
+	if user.HasPassword {
+		return user.Password.Compare(password)
+	}
+
+	session, ok := getSessionFromCtx(r)
+	if !ok {
+		return errReloginRequired
+	}
+
+	createdAt, err := time.Parse(time.RFC3339, session.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if time.Since(createdAt) > app.config.auth.oidc.reloginWindow {
+		return errReloginRequired
+	}
+	return nil
+}
+
+// checkPassword is confirmPassword for handlers, writing the error response
+// when it fails.
+func (app *application) checkPassword(w http.ResponseWriter, r *http.Request, user *store.User, password string) bool {
+	if err := app.confirmPassword(r, user, password); err != nil {
+		switch {
+		case errors.Is(err, errReloginRequired):
+			app.reloginRequired(w, r)
+		default:
+			app.unauthorized(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+	NewPassword     string `json:"new_password" validate:"required,min=3,max=72"`
+}
+
+//	@Summary		Change Password
+//	@Description	Change the password of the authenticated user. Every other session is logged out. Users without a password, created through an identity provider, must have signed in within the last few minutes instead.
+//	@Tags			Users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body	ChangePasswordPayload	true	"Current and new password"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/password [put]
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload ChangePasswordPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if !app.checkPassword(w, r, user, payload.CurrentPassword) {
+		return
+	}
+
+	var password store.Password
+	if err := password.Set(payload.NewPassword); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var keepSessionID int64
+	if session, ok := getSessionFromCtx(r); ok {
+		keepSessionID = session.ID
+	}
+
+	if err := app.store.Users.UpdatePassword(r.Context(), user.ID, keepSessionID, &password); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditPasswordChange, "user", user.ID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ChangeEmailPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"max=72"`
+}
+
+//	@Summary		Change Email
+//	@Description	Ask to change the email of the authenticated user. A confirmation link is sent to the new address and a notice to the current one. Users without a password, created through an identity provider, must have signed in within the last few minutes instead.
+//	@Tags			Users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body	ChangeEmailPayload	true	"New email and current password"
+//	@Success		202
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/email [put]
+func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload ChangeEmailPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if !app.checkPassword(w, r, user, payload.Password) {
+		return
+	}
+
+	plainToken := uuid.New().String()
+	hash := sha256.Sum256([]byte(plainToken))
+	hashedToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.Users.RequestEmailChange(r.Context(), user.ID, payload.Email, hashedToken, app.config.mail.emailChangeExp); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateEmail):
+			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	vars := struct {
+		Username   string
+		ConfirmURL string
+		ExpiresIn  string
+	}{
+		Username:   user.Username,
+		ConfirmURL: fmt.Sprintf("%s/confirm-email/%s", app.config.frontendURL, plainToken),
+		ExpiresIn:  app.config.mail.emailChangeExp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.EmailChangeTemplate, user.Username, payload.Email, vars); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	notice := struct {
+		Username string
+		NewEmail string
+	}{
+		Username: user.Username,
+		NewEmail: payload.Email,
+	}
+
+	if err := app.mailer.Send(mailer.EmailChangeNoticeTemplate, user.Username, user.Email, notice); err != nil {
+		app.logger.Errorw("failed to send email change notice", "error", err, "user_id", user.ID)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+//	@Summary		Confirm Email Change
+//	@Description	Switch the account to the new email address using the token sent there
+//	@Tags			Users
+//	@Param			token	path	string	true	"Confirmation token"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/users/email/{token} [put]
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	change, err := app.store.Users.ConfirmEmailChange(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrDuplicateEmail):
+			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &change.UserID, auditEmailChange, "user", change.UserID, store.AuditChanges(
+		map[string]any{"email": change.OldEmail},
+		map[string]any{"email": change.NewEmail},
+	))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type DeleteAccountPayload struct {
+	Password string `json:"password" validate:"max=72"`
+}
+
+//	@Summary		Delete Account
+//	@Description	Delete the authenticated user's account. It disappears right away and is purged after a grace period, until which it can be restored with the link sent by email. Comments on other people's posts are kept without an author. Users without a password, created through an identity provider, must have signed in within the last few minutes instead.
+//	@Tags			Users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		DeleteAccountPayload	true	"Current password"
+//	@Success		202		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [delete]
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload DeleteAccountPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if !app.checkPassword(w, r, user, payload.Password) {
+		return
+	}
+
+	plainToken := uuid.New().String()
+	hash := sha256.Sum256([]byte(plainToken))
+	hashedToken := hex.EncodeToString(hash[:])
+
+	purgeAfter, err := app.store.Users.ScheduleDeletion(r.Context(), user.ID, hashedToken, app.config.account.deletionGrace)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditUserDelete, "user", user.ID, store.AuditChanges(nil, map[string]any{
+		"purge_after": purgeAfter.UTC().Format(time.RFC3339),
+	}))
+
+	vars := struct {
+		Username   string
+		RestoreURL string
+		PurgeAfter string
+	}{
+		Username:   user.Username,
+		RestoreURL: fmt.Sprintf("%s/restore-account/%s", app.config.frontendURL, plainToken),
+		PurgeAfter: purgeAfter.UTC().Format("January 2, 2006"),
+	}
+
+	// The account is already gone; a lost email only costs the user the
+	// chance to change their mind.
+	if err := app.mailer.Send(mailer.AccountDeletionTemplate, user.Username, user.Email, vars); err != nil {
+		app.logger.Errorw("failed to send account deletion email", "error", err, "user_id", user.ID)
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, map[string]string{
+		"purge_after": purgeAfter.UTC().Format(time.RFC3339),
+	}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Restore Account
+//	@Description	Cancel the deletion of an account during its grace period using the token sent by email
+//	@Tags			Users
+//	@Param			token	path	string	true	"Restore token"
+//	@Success		204
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/users/restore/{token} [put]
+func (app *application) restoreAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.store.Users.CancelDeletion(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditUserRestore, "user", user.ID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
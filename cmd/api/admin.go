@@ -0,0 +1,471 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/biboyqg/social/internal/mailer"
+	"github.com/biboyqg/social/internal/policy"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+//	@Summary		List Users
+//	@Description	List users for administration, including inactive ones
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			role	query		string	false	"Role name"
+//	@Param			active	query		bool	false	"Filter by activation"
+//	@Param			banned	query		bool	false	"Filter by active ban"
+//	@Param			search	query		string	false	"Search username or email"
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Success		200		{array}		store.User
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users [get]
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.UserListQuery{
+		Limit:  20,
+		Offset: 0,
+	}
+
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	users, err := app.store.Users.List(r.Context(), q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		List Roles
+//	@Description	List the available roles, lowest level first
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		store.Role
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles [get]
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.store.Roles.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, roles); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type setUserRolePayload struct {
+	Role string `json:"role" validate:"required,max=255"`
+}
+
+//	@Summary		Set User Role
+//	@Description	Change the role of a user. Admins cannot change their own role.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int					true	"User ID"
+//	@Param			payload	body		setUserRolePayload	true	"Role"
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/role [put]
+func (app *application) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload setUserRolePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	target, ok := app.loadAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	role, err := app.store.Roles.GetByName(ctx, payload.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.badRequest(w, r, fmt.Errorf("unknown role %q", payload.Role))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.Users.SetRole(ctx, target.ID, role.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, nil, auditUserRole, "user", target.ID, store.AuditChanges(
+		map[string]any{"role": target.Role.Name},
+		map[string]any{"role": role.Name},
+	))
+
+	target.RoleID = role.ID
+	target.Role = *role
+
+	if err := app.jsonResponse(w, http.StatusOK, target); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Activate User
+//	@Description	Activate a user account without going through the invitation
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path	int	true	"User ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/activate [put]
+func (app *application) adminActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserActive(w, r, true)
+}
+
+//	@Summary		Deactivate User
+//	@Description	Deactivate a user account. Admins cannot deactivate themselves.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path	int	true	"User ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/deactivate [put]
+func (app *application) adminDeactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserActive(w, r, false)
+}
+
+func (app *application) setUserActive(w http.ResponseWriter, r *http.Request, active bool) {
+	target, ok := app.loadAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.store.Users.SetActive(r.Context(), target.ID, active); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, nil, auditUserActive, "user", target.ID, store.AuditChanges(
+		map[string]any{"is_active": target.IsActive},
+		map[string]any{"is_active": active},
+	))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Force Password Reset
+//	@Description	Require a user to pick a new password before signing in again, and email them a reset link
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path	int	true	"User ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/password-reset [post]
+func (app *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	target, ok := app.loadAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	plainToken := uuid.New().String()
+	hash := sha256.Sum256([]byte(plainToken))
+	hashedToken := hex.EncodeToString(hash[:])
+
+	if err := app.store.Users.ForcePasswordReset(ctx, target.ID, hashedToken, app.config.mail.resetExp); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, nil, auditUserForceReset, "user", target.ID, store.AuditChanges(
+		map[string]any{"password_reset_required": target.PasswordResetRequired},
+		map[string]any{"password_reset_required": true},
+	))
+
+	vars := struct {
+		Username  string
+		ResetURL  string
+		ExpiresIn string
+	}{
+		Username:  target.Username,
+		ResetURL:  fmt.Sprintf("%s/reset-password/%s", app.config.frontendURL, plainToken),
+		ExpiresIn: app.config.mail.resetExp.String(),
+	}
+
+	if err := app.mailer.Send(mailer.PasswordResetTemplate, target.Username, target.Email, vars); err != nil {
+		// The account stays locked; the admin can trigger another reset.
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadAdminTarget resolves the user an admin endpoint acts on and refuses to
+// let admins act on their own account, so they cannot lock themselves out.
+func (app *application) loadAdminTarget(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	actor, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+
+	if userID == actor.ID {
+		app.forbidden(w, r, errors.New("admins cannot change their own account"))
+		return nil, false
+	}
+
+	target, err := app.store.Users.GetByIDIncludingInactive(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return target, true
+}
+
+//	@Summary		List Permissions
+//	@Description	List every permission that can be granted to a role
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		store.Permission
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/permissions [get]
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.store.Permissions.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, permissions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Get Role Permissions
+//	@Description	List the permissions granted to a role
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			roleID	path		int	true	"Role ID"
+//	@Success		200		{array}		store.Permission
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles/{roleID}/permissions [get]
+func (app *application) getRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.store.Permissions.GetByRoleID(r.Context(), role.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, permissions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Grant Permission
+//	@Description	Grant a permission to a role
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			roleID		path	int		true	"Role ID"
+//	@Param			permission	path	string	true	"Permission name"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles/{roleID}/permissions/{permission} [put]
+func (app *application) grantPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.store.Permissions.Grant(r.Context(), role.ID, chi.URLParam(r, "permission")); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.policy.Invalidate(role.ID)
+
+	app.audit(r, nil, auditPermissionGrant, "role", role.ID, store.AuditChanges(
+		map[string]any{},
+		map[string]any{"permission": chi.URLParam(r, "permission")},
+	))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Revoke Permission
+//	@Description	Revoke a permission from a role. Admins cannot revoke role management from their own role.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			roleID		path	int		true	"Role ID"
+//	@Param			permission	path	string	true	"Permission name"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles/{roleID}/permissions/{permission} [delete]
+func (app *application) revokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	actor, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	role, ok := app.loadRole(w, r)
+	if !ok {
+		return
+	}
+
+	permission := chi.URLParam(r, "permission")
+
+	if role.ID == actor.RoleID && permission == policy.RoleManage {
+		app.forbidden(w, r, errors.New("admins cannot revoke role management from their own role"))
+		return
+	}
+
+	if err := app.store.Permissions.Revoke(r.Context(), role.ID, permission); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.policy.Invalidate(role.ID)
+
+	app.audit(r, &actor.ID, auditPermissionRevoke, "role", role.ID, store.AuditChanges(
+		map[string]any{"permission": permission},
+		map[string]any{},
+	))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) loadRole(w http.ResponseWriter, r *http.Request) (*store.Role, bool) {
+	roleID, err := strconv.ParseInt(chi.URLParam(r, "roleID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+
+	role, err := app.store.Roles.GetByID(r.Context(), roleID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return role, true
+}
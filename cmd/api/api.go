@@ -1,13 +1,21 @@
 package main
 
 import (
+	"expvar"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"time"
 
 	"github.com/biboyqg/social/docs"
+	"github.com/biboyqg/social/internal/blob"
 	"github.com/biboyqg/social/internal/mailer"
+	"github.com/biboyqg/social/internal/oidc"
+	"github.com/biboyqg/social/internal/policy"
+	"github.com/biboyqg/social/internal/ratelimiter"
+	"github.com/biboyqg/social/internal/secretbox"
 	"github.com/biboyqg/social/internal/store"
+	"github.com/biboyqg/social/internal/unfurl"
 	"github.com/biboyqg/social/internal/auth"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -22,6 +30,12 @@ type application struct {
 	logger        *zap.SugaredLogger
 	mailer        mailer.Mailer
 	authenticator auth.Authenticator
+	policy        *policy.Engine
+	rateLimiters  map[string]*ratelimiter.Limiter
+	secretBox     *secretbox.Box
+	oidcProviders map[string]*oidc.Provider
+	blob          blob.Store
+	unfurler      *unfurl.Fetcher
 }
 
 type config struct {
@@ -32,7 +46,19 @@ type config struct {
 	apiURL      string
 	mail        mailConfig
 	frontendURL string
+	// proxies are the trusted proxies, whose forwarding headers say who the
+	// client is.
+	proxies     []netip.Prefix
 	auth        authConfig
+	scheduler   schedulerConfig
+	policy      policyConfig
+	rateLimit   rateLimitConfig
+	account     accountConfig
+	blob        blobConfig
+	attachments attachmentConfig
+	media       mediaConfig
+	unfurl      unfurlConfig
+	messaging   messagingConfig
 }
 
 type dbConfig struct {
@@ -43,8 +69,10 @@ type dbConfig struct {
 }
 
 type mailConfig struct {
-	gomail gomailConfig
-	exp    time.Duration
+	gomail         gomailConfig
+	exp            time.Duration
+	resetExp       time.Duration
+	emailChangeExp time.Duration
 }
 
 type gomailConfig struct {
@@ -58,6 +86,9 @@ type gomailConfig struct {
 type authConfig struct {
 	basic basicAuthConfig
 	token tokenConfig
+	login loginConfig
+	mfa   mfaConfig
+	oidc  oidcConfig
 }
 
 type basicAuthConfig struct {
@@ -65,6 +96,32 @@ type basicAuthConfig struct {
 	password string
 }
 
+// loginConfig tunes brute-force protection: failures are counted over window,
+// each one doubles the delay before the next check, and maxFailures for an
+// account (or maxIPFailures from one address) locks it for lockout.
+type loginConfig struct {
+	window        time.Duration
+	maxFailures   int
+	maxIPFailures int
+	lockout       time.Duration
+	delay         time.Duration
+	maxDelay      time.Duration
+}
+
+type mfaConfig struct {
+	issuer        string
+	encryptionKey string
+	challengeExp  time.Duration
+}
+
+type oidcConfig struct {
+	stateExp time.Duration
+	// reloginWindow is how recent a sign-in must be to stand in for the
+	// password of a user who has none.
+	reloginWindow time.Duration
+	providers     map[string]oidc.Config
+}
+
 type tokenConfig struct {
 	secret string
 	aud    string
@@ -72,20 +129,103 @@ type tokenConfig struct {
 	exp    time.Duration
 }
 
+type schedulerConfig struct {
+	publishInterval    time.Duration
+	publishBatchSize   int
+	exportInterval     time.Duration
+	mediaInterval      time.Duration
+	unfurlInterval     time.Duration
+	purgeInterval      time.Duration
+	trashRetentionDays int
+}
+
+// accountConfig covers self-service data exports and account deletion.
+type accountConfig struct {
+	deletionGrace time.Duration
+	exportExp     time.Duration
+}
+
+// blobConfig selects where uploaded files are kept: "local" for a directory
+// on disk, or "s3" for a bucket of an S3-compatible service such as MinIO.
+type blobConfig struct {
+	backend string
+	root    string
+	s3      blob.S3Config
+}
+
+// attachmentConfig limits uploads. Types are checked against the sniffed
+// content, not what the client claims.
+type attachmentConfig struct {
+	maxSize      int64
+	maxPerPost   int
+	allowedTypes []string
+	uploadExp    time.Duration
+}
+
+// mediaConfig tunes image processing. Thumbnails are square; the WebP copy
+// is scaled down to fit webpMaxSize.
+type mediaConfig struct {
+	thumbnailSizes []int
+	avatarSizes    []int
+	webpMaxSize    int
+	maxPixels      int
+}
+
+// unfurlConfig bounds the fetching of link previews. Previews are cached
+// for cacheTTL, or the TTL of their domain; failed fetches for failureTTL.
+type unfurlConfig struct {
+	timeout      time.Duration
+	maxBytes     int64
+	maxRedirects int
+	maxLinks     int
+	userAgent    string
+	cacheTTL     time.Duration
+	failureTTL   time.Duration
+	domainTTLs   map[string]time.Duration
+}
+
+// messagingConfig limits direct messages. maxGroupSize counts the creator of
+// a conversation.
+type messagingConfig struct {
+	maxGroupSize int
+}
+
+type policyConfig struct {
+	cacheTTL time.Duration
+}
+
+type rateLimitConfig struct {
+	enabled bool
+	backend string
+	redis   redisConfig
+	routes  map[string]rateLimitRule
+}
+
+type redisConfig struct {
+	addr     string
+	password string
+	db       int
+}
+
+type rateLimitRule struct {
+	policy ratelimiter.Policy
+	keyBy  string
+}
+
 func (app *application) mount() http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(app.RealIPMiddleware)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"Link", "ETag", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
@@ -93,47 +233,301 @@ func (app *application) mount() http.Handler {
 	r.Use(middleware.Timeout(60 * time.Second))
 
 	r.Route("/v1", func(r chi.Router) {
+		r.Use(app.RateLimitMiddleware("global"))
+
 		r.With(app.BasicAuthMiddleware()).Get("/health", app.healthCheckHandler)
+		r.With(app.BasicAuthMiddleware()).Get("/debug/vars", expvar.Handler().ServeHTTP)
 
 		docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config.addr)
 		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
 
 		r.Route("/posts", func(r chi.Router) {
 			r.Use(app.AuthTokenMiddleware)
-			r.Post("/", app.createPostHandler)
+			r.With(app.RequireScope(policy.ScopePostsWrite), app.RateLimitMiddleware("post_create")).Post("/", app.createPostHandler)
 
 			r.Route("/{postID}", func(r chi.Router) {
-				r.Use(app.postsContextMiddleware)
+				r.Group(func(r chi.Router) {
+					r.Use(app.postsContextMiddleware)
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequireScope(policy.ScopePostsRead))
+
+						r.Get("/", app.getPostHandler)
+						r.Get("/revisions", app.getPostRevisionsHandler)
+						r.Get("/revisions/diff", app.getPostRevisionDiffHandler)
+						r.Get("/revisions/{version}", app.getPostRevisionHandler)
+					})
 
-				r.Get("/", app.getPostHandler)
-				r.Patch("/", app.checkPostOwnership("moderator", app.updatePostHandler))
-				r.Delete("/", app.checkPostOwnership("admin", app.deletePostHandler))
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequireScope(policy.ScopePostsWrite))
+
+						r.Patch("/", app.checkPostOwnership(policy.PostUpdateAny, app.requirePostIfMatch(app.updatePostHandler)))
+						r.Delete("/", app.checkPostOwnership(policy.PostDeleteAny, app.requirePostIfMatch(app.deletePostHandler)))
+						r.Put("/repost", app.repostHandler)
+						r.Delete("/repost", app.unrepostHandler)
+						r.Put("/bookmark", app.bookmarkPostHandler)
+						r.Delete("/bookmark", app.unbookmarkPostHandler)
+						r.Put("/poll/vote", app.votePollHandler)
+					})
+				})
+
+				r.With(app.RequireScope(policy.ScopePostsWrite), app.trashedPostsContextMiddleware).Put("/restore", app.checkPostOwnership(policy.PostRestoreAny, app.restorePostHandler))
 			})
 
 		})
 
+		r.Route("/attachments", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.With(app.RequireScope(policy.ScopePostsWrite), app.RateLimitMiddleware("attachment_upload")).Post("/", app.uploadAttachmentHandler)
+			r.With(app.RequireScope(policy.ScopePostsRead)).Get("/{attachmentID}", app.getAttachmentHandler)
+		})
+
 		r.Route("/users", func(r chi.Router) {
 			r.Put("/activate/{token}", app.activateUserHandler)
+			r.Put("/email/{token}", app.confirmEmailChangeHandler)
+			r.Put("/restore/{token}", app.restoreAccountHandler)
+
+			r.Route("/me", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+
+				r.With(app.RequireScope(policy.ScopePostsRead)).Get("/trash", app.getTrashHandler)
+
+				r.Route("/bookmarks", func(r chi.Router) {
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequireScope(policy.ScopePostsRead))
+
+						r.Get("/", app.listBookmarksHandler)
+						r.Get("/collections", app.listBookmarkCollectionsHandler)
+					})
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequireScope(policy.ScopePostsWrite))
+
+						r.Post("/collections", app.createBookmarkCollectionHandler)
+						r.Delete("/collections/{collectionID}", app.deleteBookmarkCollectionHandler)
+					})
+				})
+
+				r.Route("/mfa", func(r chi.Router) {
+					r.Use(app.RequireSession)
+
+					r.Post("/", app.enrollMFAHandler)
+					r.Delete("/", app.disableMFAHandler)
+					r.Post("/confirm", app.confirmMFAHandler)
+					r.Post("/recovery-codes", app.regenerateRecoveryCodesHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireSession)
+
+					r.Delete("/", app.deleteAccountHandler)
+					r.Put("/password", app.changePasswordHandler)
+					r.Put("/email", app.changeEmailHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeUsersWrite))
+
+					r.With(app.RateLimitMiddleware("attachment_upload")).Put("/avatar", app.uploadAvatarHandler)
+					r.Delete("/avatar", app.deleteAvatarHandler)
+					r.Put("/messaging", app.updateMessagingSettingsHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeUsersRead))
+
+					r.Get("/blocks", app.listBlocksHandler)
+					r.Get("/messaging", app.getMessagingSettingsHandler)
+				})
+
+				r.Route("/exports", func(r chi.Router) {
+					r.Use(app.RequireSession)
+
+					r.Get("/", app.listExportsHandler)
+					r.Post("/", app.createExportHandler)
+					r.Get("/{exportID}/download", app.downloadExportHandler)
+				})
+
+				r.Route("/sessions", func(r chi.Router) {
+					r.Use(app.RequireSession)
+
+					r.Get("/", app.listSessionsHandler)
+					r.Delete("/", app.revokeOtherSessionsHandler)
+					r.Delete("/{sessionID}", app.revokeSessionHandler)
+				})
+
+				r.Route("/tokens", func(r chi.Router) {
+					r.Use(app.RequireSession)
+
+					r.Get("/", app.listAccessTokensHandler)
+					r.Post("/", app.createAccessTokenHandler)
+					r.Delete("/{tokenID}", app.revokeAccessTokenHandler)
+				})
+			})
 
 			r.Route("/{userID}", func(r chi.Router) {
 				r.Use(app.AuthTokenMiddleware)
 
-				r.Get("/", app.getUserHandler)
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unfollowUserHandler)
-				r.Get("/followers", app.getFollowersHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeUsersRead))
+
+					r.Get("/", app.getUserHandler)
+					r.Get("/followers", app.getFollowersHandler)
+					r.Get("/avatar", app.getAvatarHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeUsersWrite))
+
+					r.Put("/follow", app.followUserHandler)
+					r.Put("/unfollow", app.unfollowUserHandler)
+					r.Put("/block", app.blockUserHandler)
+					r.Delete("/block", app.unblockUserHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeModeration))
+					r.Use(app.RequirePermission(policy.UserBan))
+
+					r.Put("/ban", app.banUserHandler)
+					r.Delete("/ban", app.liftBanHandler)
+					r.Get("/bans", app.getUserBansHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeAdmin))
+					r.Use(app.RequirePermission(policy.UserDeleteAny))
+
+					r.Delete("/", app.deleteUserHandler)
+					r.Put("/restore", app.restoreUserHandler)
+				})
 			})
 
 			r.Group(func(r chi.Router) {
 				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.RequireScope(policy.ScopePostsRead))
 				r.Get("/feed", app.getUserFeedHandler)
 			})
 		})
 
+		r.Route("/conversations", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+
+			r.With(app.RequireScope(policy.ScopeMessagesRead)).Get("/", app.listConversationsHandler)
+			r.With(app.RequireScope(policy.ScopeMessagesWrite), app.RateLimitMiddleware("message_send")).Post("/", app.startConversationHandler)
+
+			r.Route("/{conversationID}", func(r chi.Router) {
+				r.Use(app.conversationsContextMiddleware)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeMessagesRead))
+
+					r.Get("/", app.getConversationHandler)
+					r.Get("/messages", app.getMessagesHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeMessagesWrite))
+
+					r.Delete("/", app.leaveConversationHandler)
+					r.With(app.RateLimitMiddleware("message_send")).Post("/messages", app.sendMessageHandler)
+					r.Put("/read", app.markConversationReadHandler)
+				})
+			})
+		})
+
+		r.Route("/communities", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+
+			r.With(app.RequireScope(policy.ScopeCommunitiesRead)).Get("/", app.listCommunitiesHandler)
+			r.With(app.RequireScope(policy.ScopeCommunitiesWrite)).Post("/", app.createCommunityHandler)
+
+			r.Route("/{communityID}", func(r chi.Router) {
+				r.Use(app.communitiesContextMiddleware)
+
+				r.With(app.RequireScope(policy.ScopePostsRead)).Get("/feed", app.getCommunityFeedHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeCommunitiesRead))
+
+					r.Get("/", app.getCommunityHandler)
+					r.Get("/members", app.getCommunityMembersHandler)
+				})
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireScope(policy.ScopeCommunitiesWrite))
+
+					r.Put("/membership", app.joinCommunityHandler)
+					r.Delete("/membership", app.leaveCommunityHandler)
+					r.Put("/members/{userID}/role", app.setCommunityRoleHandler)
+					r.Delete("/posts/{postID}", app.removeCommunityPostHandler)
+				})
+			})
+		})
+
+		r.Route("/reports", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.With(app.RequireScope(policy.ScopeReports), app.RateLimitMiddleware("report_create")).Post("/", app.createReportHandler)
+		})
+
+		r.Route("/moderation", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.RequireScope(policy.ScopeModeration))
+			r.Use(app.RequirePermission(policy.ReportModerate))
+
+			r.Get("/queue", app.getModerationQueueHandler)
+
+			r.Route("/reports/{reportID}", func(r chi.Router) {
+				r.Use(app.reportsContextMiddleware)
+
+				r.Get("/", app.getReportHandler)
+				r.Put("/assign", app.assignReportHandler)
+				r.Post("/resolve", app.resolveReportHandler)
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.RequireScope(policy.ScopeAdmin))
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.RequirePermission(policy.UserManage))
+
+				r.Get("/users", app.listUsersHandler)
+				r.Put("/users/{userID}/activate", app.adminActivateUserHandler)
+				r.Put("/users/{userID}/deactivate", app.adminDeactivateUserHandler)
+				r.Post("/users/{userID}/password-reset", app.forcePasswordResetHandler)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.RequirePermission(policy.RoleManage))
+
+				r.Get("/roles", app.listRolesHandler)
+				r.Get("/permissions", app.listPermissionsHandler)
+				r.Get("/roles/{roleID}/permissions", app.getRolePermissionsHandler)
+				r.Put("/roles/{roleID}/permissions/{permission}", app.grantPermissionHandler)
+				r.Delete("/roles/{roleID}/permissions/{permission}", app.revokePermissionHandler)
+				r.Put("/users/{userID}/role", app.setUserRoleHandler)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.RequirePermission(policy.AuditRead))
+
+				r.Get("/audit", app.getAuditEventsHandler)
+				r.Get("/audit/export", app.exportAuditEventsHandler)
+			})
+		})
+
 		// Public routes
 		r.Route("/authentication", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("auth"))
+
 			r.Post("/user", app.registerUserHandler)
 			r.Post("/token", app.createTokenHandler)
+			r.Post("/token/mfa", app.verifyMFAHandler)
+			r.Put("/password-reset/{token}", app.resetPasswordHandler)
+			r.Get("/oidc/{provider}", app.startOIDCHandler)
+			r.Post("/oidc/{provider}/callback", app.oidcCallbackHandler)
 		})
 	})
 
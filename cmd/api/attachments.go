@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biboyqg/social/internal/blob"
+	"github.com/biboyqg/social/internal/media"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// sniffLen is how much of an upload http.DetectContentType looks at.
+const sniffLen = 512
+
+// blobDeletionBatchSize bounds how many blobs one run of the purge job
+// removes.
+const blobDeletionBatchSize = 500
+
+func newBlobStore(cfg blobConfig) (blob.Store, error) {
+	switch cfg.backend {
+	case "local":
+		return blob.NewLocalStore(cfg.root)
+	case "s3":
+		return blob.NewS3Store(cfg.s3)
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.backend)
+	}
+}
+
+//	@Summary		Upload Attachment
+//	@Description	Upload an image or file as a multipart form with a single "file" field. The content type is sniffed from the file itself. Attach the upload by listing its ID in attachment_ids when creating or updating a post; uploads that are never attached are deleted. Images are processed in the background: their status stays pending until metadata is stripped and thumbnails, a WebP copy and a blurhash are ready.
+//	@Tags			Attachments
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			file	formData	file	true	"File to upload"
+//	@Success		201		{object}	store.Attachment
+//	@Failure		400		{object}	map[string]string
+//	@Failure		413		{object}	map[string]string
+//	@Failure		415		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/attachments [post]
+func (app *application) uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	upload, ok := app.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	attachment := store.Attachment{
+		UserID:      user.ID,
+		Filename:    upload.filename,
+		ContentType: upload.contentType,
+		Size:        int64(len(upload.data)),
+		Status:      store.AttachmentReady,
+	}
+	if media.Supported(upload.contentType) {
+		attachment.Status = store.AttachmentPending
+	}
+
+	if err := app.storeUpload(r.Context(), &attachment, upload, app.store.Attachments.Create); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, attachment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// upload is a file read from a multipart request, with its sniffed media
+// type.
+type upload struct {
+	data        []byte
+	filename    string
+	contentType string
+}
+
+// readUpload reads the "file" field of a multipart request, enforcing the
+// size limit and the allowed types. It writes the error response itself.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request) (*upload, bool) {
+	maxSize := app.config.attachments.maxSize
+	tooLarge := fmt.Errorf("file must not be larger than %d bytes", maxSize)
+
+	// Leave room for the multipart framing around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+
+	var part io.Reader
+	var filename string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesErr):
+				app.payloadTooLarge(w, r, tooLarge)
+			case errors.Is(err, io.EOF):
+				app.badRequest(w, r, errors.New("missing file field"))
+			default:
+				app.badRequest(w, r, err)
+			}
+			return nil, false
+		}
+		if p.FormName() == "file" {
+			part = p
+			filename = attachmentFilename(p.FileName())
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(part, maxSize+1))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			app.payloadTooLarge(w, r, tooLarge)
+		default:
+			app.badRequest(w, r, err)
+		}
+		return nil, false
+	}
+	if n == 0 {
+		app.badRequest(w, r, errors.New("file is empty"))
+		return nil, false
+	}
+	if n > maxSize {
+		app.payloadTooLarge(w, r, tooLarge)
+		return nil, false
+	}
+
+	contentType := http.DetectContentType(buf.Bytes()[:min(n, sniffLen)])
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !slices.Contains(app.config.attachments.allowedTypes, mediaType) {
+		app.unsupportedMediaType(w, r, fmt.Errorf("files of type %s are not allowed", contentType))
+		return nil, false
+	}
+
+	return &upload{data: buf.Bytes(), filename: filename, contentType: mediaType}, true
+}
+
+// storeUpload writes an upload to blob storage and records it with create,
+// removing the blob again if that fails.
+func (app *application) storeUpload(ctx context.Context, attachment *store.Attachment, upload *upload, create func(context.Context, *store.Attachment) error) error {
+	key, err := attachmentKey(attachment.UserID)
+	if err != nil {
+		return err
+	}
+	attachment.StorageKey = key
+
+	if err := app.blob.Put(ctx, key, bytes.NewReader(upload.data), int64(len(upload.data)), upload.contentType); err != nil {
+		return err
+	}
+
+	if err := create(ctx, attachment); err != nil {
+		if err := app.blob.Delete(context.WithoutCancel(ctx), key); err != nil {
+			app.logger.Errorw("failed to delete orphaned blob", "error", err, "key", key)
+		}
+		return err
+	}
+	return nil
+}
+
+//	@Summary		Get Attachment
+//	@Description	Download an attachment, or one of its variants. Attachments are visible to whoever can see their post, avatars to everyone; uploads not attached yet, and images still being processed, only to their uploader.
+//	@Tags			Attachments
+//	@Produce		octet-stream
+//	@Param			attachmentID	path		int		true	"Attachment ID"
+//	@Param			variant			query		string	false	"Variant name, such as thumb_320 or webp"
+//	@Success		200				{file}		file
+//	@Failure		400				{object}	map[string]string
+//	@Failure		404				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/attachments/{attachmentID} [get]
+func (app *application) getAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	attachment, err := app.store.Attachments.GetByID(ctx, attachmentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	visible, err := app.canViewAttachment(r, attachment)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !visible {
+		app.notFound(w, r, store.ErrNoRecord)
+		return
+	}
+
+	key, contentType, size := attachment.StorageKey, attachment.ContentType, attachment.Size
+
+	if name := r.URL.Query().Get("variant"); name != "" {
+		variant, err := app.store.Attachments.GetVariant(ctx, attachment.ID, name)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNoRecord):
+				app.notFound(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+		key, contentType, size = variant.StorageKey, variant.ContentType, variant.Size
+	}
+
+	body, err := app.blob.Get(ctx, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	defer body.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		app.logger.Errorw("failed to send attachment", "error", err, "attachment_id", attachment.ID)
+	}
+}
+
+// canViewAttachment applies the visibility of the post an attachment is on.
+// Until an image is processed, it may still carry metadata such as a
+// location, so only its uploader sees it.
+func (app *application) canViewAttachment(r *http.Request, attachment *store.Attachment) (bool, error) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		return false, err
+	}
+
+	if user.ID == attachment.UserID {
+		return true, nil
+	}
+	if attachment.Status != store.AttachmentReady {
+		return false, nil
+	}
+	if attachment.Purpose == store.AttachmentForAvatar {
+		return true, nil
+	}
+	if attachment.PostID == nil {
+		return false, nil
+	}
+
+	post, err := app.store.Posts.GetByID(r.Context(), *attachment.PostID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+	return app.canViewPost(r, post)
+}
+
+// loadAttachments fills in the attachments of the given posts.
+func (app *application) loadAttachments(ctx context.Context, posts ...*store.Post) error {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	attachments, err := app.store.Attachments.GetByPostIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.Attachments = attachments[post.ID]
+	}
+	return nil
+}
+
+// attachmentRefs turns the attachment IDs of a post payload into the form
+// the post store expects.
+func (app *application) attachmentRefs(ids []int64) ([]store.Attachment, error) {
+	if len(ids) > app.config.attachments.maxPerPost {
+		return nil, fmt.Errorf("a post can have at most %d attachments", app.config.attachments.maxPerPost)
+	}
+
+	refs := make([]store.Attachment, len(ids))
+	for i, id := range ids {
+		refs[i] = store.Attachment{ID: id}
+	}
+	return refs, nil
+}
+
+// purgeAttachments deletes uploads that were never attached to a post, then
+// removes the blobs of every attachment that is gone.
+func (app *application) purgeAttachments(ctx context.Context) error {
+	n, err := app.store.Attachments.PurgeUnattached(ctx, time.Now().Add(-app.config.attachments.uploadExp))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Infow("purged unattached uploads", "count", n)
+	}
+
+	keys, err := app.store.Attachments.PendingBlobDeletions(ctx, blobDeletionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := app.blob.Delete(ctx, key); err != nil {
+			return err
+		}
+		if err := app.store.Attachments.ClearBlobDeletion(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	if len(keys) > 0 {
+		app.logger.Infow("deleted attachment blobs", "count", len(keys))
+	}
+	return nil
+}
+
+// attachmentKey picks a fresh, unguessable storage key for an upload.
+func attachmentKey(userID int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("attachments/%d/%s", userID, hex.EncodeToString(b)), nil
+}
+
+// attachmentFilename keeps the base name of an uploaded file, without
+// anything that could break a Content-Disposition header.
+func attachmentFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	return truncate(name, 255)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+// multipartBody builds an upload form with the file under field, declared as
+// contentType whatever its content.
+func multipartBody(t *testing.T, field, contentType string, data []byte) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="upload.png"`)
+	header.Set("Content-Type", contentType)
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+func TestReadUpload(t *testing.T) {
+	app := &application{
+		config: config{
+			attachments: attachmentConfig{
+				maxSize:      1024,
+				allowedTypes: []string{"image/png", "image/jpeg"},
+			},
+		},
+		logger: zap.NewNop().Sugar(),
+	}
+
+	png := append(bytes.Clone(pngHeader), make([]byte, 100)...)
+
+	tests := []struct {
+		name        string
+		field       string
+		contentType string
+		data        []byte
+		status      int
+	}{
+		{"allowed type", "file", "image/png", png, http.StatusOK},
+		{"at the size limit", "file", "image/png", append(bytes.Clone(pngHeader), make([]byte, 1024-len(pngHeader))...), http.StatusOK},
+		{"over the size limit", "file", "image/png", append(bytes.Clone(pngHeader), make([]byte, 1024)...), http.StatusRequestEntityTooLarge},
+		{"far over the size limit", "file", "image/png", append(bytes.Clone(pngHeader), make([]byte, 2<<20)...), http.StatusRequestEntityTooLarge},
+		{"sniffed type not allowed", "file", "application/pdf", []byte("%PDF-1.4\n%âãÏÓ\n"), http.StatusUnsupportedMediaType},
+		{"declared type is ignored", "file", "image/png", []byte("<html><body>not an image</body></html>"), http.StatusUnsupportedMediaType},
+		{"empty file", "file", "image/png", nil, http.StatusBadRequest},
+		{"missing file field", "other", "image/png", png, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := multipartBody(t, tt.field, tt.contentType, tt.data)
+
+			r := httptest.NewRequest(http.MethodPost, "/attachments", body)
+			r.Header.Set("Content-Type", contentType)
+			w := httptest.NewRecorder()
+
+			upload, ok := app.readUpload(w, r)
+
+			if tt.status == http.StatusOK {
+				if !ok {
+					t.Fatalf("readUpload failed with status %d: %s", w.Code, w.Body)
+				}
+				if upload.contentType != "image/png" || !bytes.Equal(upload.data, tt.data) {
+					t.Errorf("upload = %s with %d bytes, want image/png with %d", upload.contentType, len(upload.data), len(tt.data))
+				}
+				return
+			}
+
+			if ok {
+				t.Fatal("readUpload accepted the upload")
+			}
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
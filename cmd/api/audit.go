@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Audited actions.
+const (
+	auditLogin               = "auth.login"
+	auditLoginFailed         = "auth.login_failed"
+	auditReauthFailed        = "auth.reauth_failed"
+	auditPasswordReset       = "auth.password_reset"
+	auditPasswordChange      = "auth.password_change"
+	auditEmailChange         = "auth.email_change"
+	auditMFAEnable           = "auth.mfa_enable"
+	auditMFADisable          = "auth.mfa_disable"
+	auditMFARecoveryCodes    = "auth.mfa_recovery_codes"
+	auditIdentityLink        = "auth.identity_link"
+	auditAccessTokenCreate   = "auth.access_token_create"
+	auditAccessTokenRevoke   = "auth.access_token_revoke"
+	auditSessionRevoke       = "auth.session_revoke"
+	auditSessionRevokeOthers = "auth.session_revoke_others"
+	auditPostUpdate          = "post.update"
+	auditPostDelete          = "post.delete"
+	auditPostRestore         = "post.restore"
+	auditCommunityPostRemove = "community.post_remove"
+	auditReportAssign        = "report.assign"
+	auditReportResolve       = "report.resolve"
+	auditUserBan             = "user.ban"
+	auditUserUnban           = "user.unban"
+	auditUserDelete          = "user.delete"
+	auditUserRestore         = "user.restore"
+	auditUserRole            = "user.role"
+	auditUserActive          = "user.active"
+	auditUserForceReset      = "user.password_reset_forced"
+	auditPermissionGrant     = "role.permission_grant"
+	auditPermissionRevoke    = "role.permission_revoke"
+)
+
+// audit appends an event to the audit log, filling in the request ID, the
+// client IP and, when actorID is nil, the authenticated user. A failure to
+// write is logged but does not fail the request that triggered it.
+func (app *application) audit(r *http.Request, actorID *int64, action, targetType string, targetID int64, changes map[string]store.AuditChange) {
+	app.auditTarget(r, actorID, action, targetType, &targetID, changes)
+}
+
+// auditTarget is audit for events whose target may be unknown, such as a
+// failed login with an email that has no account.
+func (app *application) auditTarget(r *http.Request, actorID *int64, action, targetType string, targetID *int64, changes map[string]store.AuditChange) {
+	if actorID == nil {
+		if user, err := app.getUserFromCtx(r); err == nil {
+			actorID = &user.ID
+		}
+	}
+
+	event := &store.AuditEvent{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  middleware.GetReqID(r.Context()),
+		IP:         clientIP(r),
+		Changes:    changes,
+	}
+
+	if err := app.store.Audit.Create(r.Context(), event); err != nil {
+		app.logger.Errorw("failed to write audit event", "error", err, "action", action, "target_type", targetType, "target_id", targetID)
+	}
+}
+
+// clientIP returns the address RealIP resolved, without the port the
+// connection came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//	@Summary		Query Audit Log
+//	@Description	List audit events, newest first
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			actor_id	query		int		false	"Actor user ID"
+//	@Param			action		query		string	false	"Action"
+//	@Param			target_type	query		string	false	"Target type"
+//	@Param			target_id	query		int		false	"Target ID"
+//	@Param			since		query		string	false	"Only events at or after this RFC 3339 time"
+//	@Param			until		query		string	false	"Only events before this RFC 3339 time"
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Success		200			{array}		store.AuditEvent
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/audit [get]
+func (app *application) getAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.AuditQuery{
+		Limit:  50,
+		Offset: 0,
+	}
+
+	events, ok := app.queryAudit(w, r, q)
+	if !ok {
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, events); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Export Audit Log
+//	@Description	Download audit events matching the filters as CSV or JSON
+//	@Tags			Admin
+//	@Produce		text/csv
+//	@Produce		json
+//	@Param			format		query		string	false	"csv (default) or json"
+//	@Param			actor_id	query		int		false	"Actor user ID"
+//	@Param			action		query		string	false	"Action"
+//	@Param			target_type	query		string	false	"Target type"
+//	@Param			target_id	query		int		false	"Target ID"
+//	@Param			since		query		string	false	"Only events at or after this RFC 3339 time"
+//	@Param			until		query		string	false	"Only events before this RFC 3339 time"
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Success		200			{file}		file
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/admin/audit/export [get]
+func (app *application) exportAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		app.badRequest(w, r, fmt.Errorf("unsupported export format %q", format))
+		return
+	}
+
+	q := store.AuditQuery{
+		Limit:  10000,
+		Offset: 0,
+	}
+
+	events, ok := app.queryAudit(w, r, q)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "json" {
+		if err := writeJSON(w, http.StatusOK, events); err != nil {
+			app.logger.Errorw("failed to export audit log", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeAuditCSV(w, events); err != nil {
+		app.logger.Errorw("failed to export audit log", "error", err)
+	}
+}
+
+func (app *application) queryAudit(w http.ResponseWriter, r *http.Request, q store.AuditQuery) ([]store.AuditEvent, bool) {
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+
+	events, err := app.store.Audit.Query(r.Context(), q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+
+	return events, true
+}
+
+func writeAuditCSV(w http.ResponseWriter, events []store.AuditEvent) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "request_id", "ip", "changes"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		changes := ""
+		if len(e.Changes) > 0 {
+			b, err := json.Marshal(e.Changes)
+			if err != nil {
+				return err
+			}
+			changes = string(b)
+		}
+
+		record := []string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt,
+			formatOptionalID(e.ActorID),
+			e.Action,
+			e.TargetType,
+			formatOptionalID(e.TargetID),
+			e.RequestID,
+			e.IP,
+			changes,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatOptionalID(id *int64) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatInt(*id, 10)
+}
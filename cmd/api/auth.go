@@ -10,6 +10,7 @@ import (
 
 	"github.com/biboyqg/social/internal/mailer"
 	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -45,10 +46,16 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	role, err := app.store.Roles.GetByName(ctx, "user")
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
 	user := &store.User{
 		Username: payload.Username,
 		Email:    payload.Email,
-		RoleID:   1,
+		RoleID:   role.ID,
 	}
 
 	if err := user.Password.Set(payload.Password); err != nil {
@@ -74,15 +81,23 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	activationURL := fmt.Sprintf("%s/confirm/%s", app.config.frontendURL, plainToken)
 
+	vars := struct {
+		Username      string
+		ActivationURL string
+	}{
+		Username:      user.Username,
+		ActivationURL: activationURL,
+	}
+
 	if err := app.mailer.Send(
-		mailer.TemplatePath,
+		mailer.UserInvitationTemplate,
 		user.Username,
 		user.Email,
-		activationURL,
+		vars,
 	); err != nil {
 		app.logger.Errorw("failed to send email", "error", err)
 
-		if err := app.store.Users.Delete(ctx, user.ID); err != nil {
+		if err := app.store.Users.Purge(ctx, user.ID); err != nil {
 			app.logger.Errorw("failed to delete user", "error", err)
 		}
 
@@ -101,7 +116,7 @@ type CreateTokenPayload struct {
 }
 
 //	@Summary		Create Token
-//	@Description	Create a token for a user
+//	@Description	Create a token for a user. Users with two-factor authentication get an mfa_token instead, to exchange at /authentication/token/mfa.
 //	@Tags			Authentication
 //	@Accept			json
 //	@Produce		json
@@ -109,6 +124,8 @@ type CreateTokenPayload struct {
 //	@Success		200		{object}	map[string]string
 //	@Failure		400		{object}	map[string]string
 //	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Router			/authentication/token [post]
 func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -126,20 +143,87 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	login, err := app.startLogin(r, payload.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	defer login.release(r)
+
+	if login.retryAfter > 0 {
+		app.loginLocked(w, r, ceilSeconds(login.retryAfter))
+		return
+	}
+
+	if !sleepContext(ctx, app.loginDelay(login.failures)) {
+		return
+	}
+
+	// Unknown emails and wrong passwords get the same response in the same
+	// time, so that the endpoint cannot be used to find out who has an account.
 	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
 	if err != nil {
-		app.badRequest(w, r, err)
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			store.CompareDummyPassword(payload.Password)
+			app.auditTarget(r, nil, auditLoginFailed, "user", nil, nil)
+			login.failed(r, nil)
+			app.unauthorized(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
 	if err := user.Password.Compare(payload.Password); err != nil {
+		app.audit(r, nil, auditLoginFailed, "user", user.ID, nil)
+		login.failed(r, user)
 		app.unauthorized(w, r, err)
 		return
 	}
 
+	if !app.checkNotBanned(w, r, user.ID) {
+		return
+	}
+
+	if user.PasswordResetRequired {
+		app.passwordResetRequired(w, r, user)
+		return
+	}
+
+	// With 2FA on, the password only earns a challenge token. The attempt
+	// counts as successful once the second factor checks out, so failed codes
+	// keep adding up towards a lockout.
+	if user.MFAEnabled {
+		app.issueMFAChallenge(w, r, user)
+		return
+	}
+
+	login.succeeded(r)
+	app.issueToken(w, r, user)
+}
+
+// issueToken starts a session for a fully authenticated user and responds
+// with an access token for it.
+func (app *application) issueToken(w http.ResponseWriter, r *http.Request, user *store.User) {
+	expiresAt := time.Now().Add(app.config.auth.token.exp)
+
+	session := &store.Session{
+		UserID:    user.ID,
+		Device:    deviceName(r.UserAgent()),
+		UserAgent: truncate(r.UserAgent(), 512),
+		IP:        clientIP(r),
+		ExpiresAt: expiresAt,
+	}
+	if err := app.store.Sessions.Create(r.Context(), session); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
 	claims := jwt.MapClaims{
 		"sub": user.ID,
-		"exp": time.Now().Add(app.config.auth.token.exp).Unix(),
+		"sid": session.ID,
+		"exp": expiresAt.Unix(),
 		"iat": time.Now().Unix(),
 		"nbf": time.Now().Unix(),
 		"iss": app.config.auth.token.iss,
@@ -152,7 +236,60 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, &user.ID, auditLogin, "user", user.ID, nil)
+
 	if err := app.jsonResponse(w, http.StatusOK, map[string]string{"token": token}); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
+
+type ResetPasswordPayload struct {
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+//	@Summary		Reset Password
+//	@Description	Set a new password using the token from a password reset email
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			token	path	string					true	"Reset token"
+//	@Param			payload	body	ResetPasswordPayload	true	"New password"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/authentication/password-reset/{token} [put]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var password store.Password
+	if err := password.Set(payload.Password); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.ResetPassword(r.Context(), chi.URLParam(r, "token"), &password)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditPasswordReset, "user", user.ID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/biboyqg/social/internal/media"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+//	@Summary		Upload Avatar
+//	@Description	Replace the authenticated user's avatar with an image sent as a multipart form with a single "file" field. The avatar is processed in the background and its status stays pending until its thumbnails are ready.
+//	@Tags			Users
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			file	formData	file	true	"Image"
+//	@Success		202		{object}	store.Attachment
+//	@Failure		400		{object}	map[string]string
+//	@Failure		413		{object}	map[string]string
+//	@Failure		415		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/avatar [put]
+func (app *application) uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	upload, ok := app.readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if !media.Supported(upload.contentType) {
+		app.unsupportedMediaType(w, r, fmt.Errorf("avatars must be images, not %s", upload.contentType))
+		return
+	}
+
+	avatar := store.Attachment{
+		UserID:      user.ID,
+		Filename:    upload.filename,
+		ContentType: upload.contentType,
+		Size:        int64(len(upload.data)),
+		Status:      store.AttachmentPending,
+	}
+
+	if err := app.storeUpload(r.Context(), &avatar, upload, app.store.Attachments.ReplaceAvatar); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, avatar); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Delete Avatar
+//	@Description	Remove the authenticated user's avatar
+//	@Tags			Users
+//	@Success		204
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/avatar [delete]
+func (app *application) deleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Attachments.DeleteAvatar(r.Context(), user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Get Avatar
+//	@Description	Get a user's avatar, with its processing status and variants. Download the image itself from the attachments endpoint.
+//	@Tags			Users
+//	@Produce		json
+//	@Param			userID	path		int	true	"User ID"
+//	@Success		200		{object}	store.Attachment
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/avatar [get]
+func (app *application) getAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	avatar, err := app.store.Attachments.GetAvatar(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, avatar); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
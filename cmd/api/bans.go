@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/biboyqg/social/internal/policy"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type banUserPayload struct {
+	Reason    string     `json:"reason" validate:"required,max=1000"`
+	Permanent bool       `json:"permanent"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+//	@Summary		Ban User
+//	@Description	Suspend a user until expires_at, or ban them permanently. The new ban replaces the active one. Moderators can suspend users ranked below them; permanent bans, and replacing them, are reserved to admins.
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int				true	"User ID"
+//	@Param			payload	body		banUserPayload	true	"Ban"
+//	@Success		201		{object}	store.Ban
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/ban [put]
+func (app *application) banUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload banUserPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	expiresAt, err := banExpiry(payload.Permanent, payload.ExpiresAt)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	actor, target, ok := app.loadBanParties(w, r)
+	if !ok {
+		return
+	}
+
+	replacesPermanent, err := app.hasPermanentBan(ctx, target.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	allowed, err := app.checkBanPrecedence(ctx, actor, target, payload.Permanent || replacesPermanent)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !allowed {
+		app.forbidden(w, r, errors.New("user cannot ban this account"))
+		return
+	}
+
+	ban := &store.Ban{
+		UserID:    target.ID,
+		BannedBy:  &actor.ID,
+		Reason:    payload.Reason,
+		Permanent: payload.Permanent,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := app.store.Bans.Create(ctx, ban); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, &actor.ID, auditUserBan, "user", target.ID, store.AuditChanges(
+		map[string]any{},
+		map[string]any{"reason": ban.Reason, "permanent": ban.Permanent, "expires_at": ban.ExpiresAt},
+	))
+
+	if err := app.jsonResponse(w, http.StatusCreated, ban); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Lift Ban
+//	@Description	Lift the active suspension or ban of a user. Permanent bans can only be lifted by admins.
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path	int	true	"User ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/ban [delete]
+func (app *application) liftBanHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	actor, target, ok := app.loadBanParties(w, r)
+	if !ok {
+		return
+	}
+
+	ban, err := app.store.Bans.GetActive(ctx, target.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	allowed, err := app.checkBanPrecedence(ctx, actor, target, ban.Permanent)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !allowed {
+		app.forbidden(w, r, errors.New("user cannot lift this ban"))
+		return
+	}
+
+	if err := app.store.Bans.Lift(ctx, target.ID, actor.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &actor.ID, auditUserUnban, "user", target.ID, store.AuditChanges(
+		map[string]any{"ban_id": ban.ID},
+		map[string]any{},
+	))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Get User Bans
+//	@Description	Get the ban history of a user
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int	true	"User ID"
+//	@Success		200		{array}		store.Ban
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/{userID}/bans [get]
+func (app *application) getUserBansHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	bans, err := app.store.Bans.GetByUserID(r.Context(), userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, bans); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// loadBanParties resolves the acting moderator and the targeted user, writing
+// the error response itself when it fails.
+func (app *application) loadBanParties(w http.ResponseWriter, r *http.Request) (*store.User, *store.User, bool) {
+	actor, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, nil, false
+	}
+
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return nil, nil, false
+	}
+
+	target, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	return actor, target, true
+}
+
+// checkBanPrecedence only lets users ban accounts whose permissions are a
+// strict subset of theirs, and keeps permanent bans for holders of
+// user:ban:permanent.
+func (app *application) checkBanPrecedence(ctx context.Context, actor, target *store.User, permanent bool) (bool, error) {
+	allowed, err := app.outranks(ctx, actor, target)
+	if err != nil || !allowed {
+		return false, err
+	}
+
+	if permanent {
+		return app.can(ctx, actor, policy.UserBanPermanent)
+	}
+
+	return true, nil
+}
+
+// hasPermanentBan reports whether the user has a permanent ban in force. A
+// new ban lifts it, so replacing it takes the same permission as lifting it.
+func (app *application) hasPermanentBan(ctx context.Context, userID int64) (bool, error) {
+	ban, err := app.store.Bans.GetActive(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+	return ban.Permanent, nil
+}
+
+// banExpiry validates the requested ban duration and formats it for storage.
+// Only permanent bans go without an expiry.
+func banExpiry(permanent bool, expiresAt *time.Time) (*string, error) {
+	if permanent {
+		if expiresAt != nil {
+			return nil, errors.New("a permanent ban cannot expire")
+		}
+		return nil, nil
+	}
+
+	if expiresAt == nil {
+		return nil, errors.New("a ban that is not permanent needs an expiry")
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+
+	formatted := expiresAt.UTC().Format(time.RFC3339)
+	return &formatted, nil
+}
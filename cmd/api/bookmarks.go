@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type bookmarkPostPayload struct {
+	CollectionID *int64 `json:"collection_id" validate:"omitempty,min=1"`
+}
+
+type createBookmarkCollectionPayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+//	@Summary		Bookmark Post
+//	@Description	Save the post for later, optionally in one of your collections. Bookmarking a post again moves it to the given collection, or out of any when collection_id is left out. The body is optional.
+//	@Tags			Bookmarks
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path	int					true	"Post ID"
+//	@Param			payload	body	bookmarkPostPayload	false	"Collection"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/bookmark [put]
+func (app *application) bookmarkPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload bookmarkPostPayload
+
+	if err := readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Bookmarks.Save(ctx, user.ID, post.ID, payload.CollectionID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrUnknownCollection):
+			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Remove Bookmark
+//	@Description	Remove the post from your bookmarks
+//	@Tags			Bookmarks
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path	int	true	"Post ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/bookmark [delete]
+func (app *application) unbookmarkPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Bookmarks.Delete(ctx, user.ID, post.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		List Bookmarks
+//	@Description	List your bookmarks, most recent first. Posts that were deleted or hidden since are left out, and come back if the post is restored.
+//	@Tags			Bookmarks
+//	@Accept			json
+//	@Produce		json
+//	@Param			limit			query		int	false	"Limit"
+//	@Param			offset			query		int	false	"Offset"
+//	@Param			collection_id	query		int	false	"Only list the bookmarks of this collection"
+//	@Success		200				{array}		store.Bookmark
+//	@Failure		400				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/bookmarks [get]
+func (app *application) listBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := store.BookmarkQuery{
+		Limit:  20,
+		Offset: 0,
+	}
+
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	bookmarks, err := app.store.Bookmarks.List(ctx, user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	posts := make([]*store.Post, len(bookmarks))
+	for i := range bookmarks {
+		posts[i] = &bookmarks[i].Post
+	}
+	if err := app.loadAttachments(ctx, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadLinkPreviews(ctx, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadQuotedPosts(ctx, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadPolls(ctx, user.ID, posts...); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, bookmarks); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		List Bookmark Collections
+//	@Description	List your bookmark collections by name
+//	@Tags			Bookmarks
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}		store.BookmarkCollection
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/bookmarks/collections [get]
+func (app *application) listBookmarkCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	collections, err := app.store.Bookmarks.ListCollections(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, collections); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Create Bookmark Collection
+//	@Description	Create a named collection to file bookmarks in
+//	@Tags			Bookmarks
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		createBookmarkCollectionPayload	true	"Collection"
+//	@Success		201		{object}	store.BookmarkCollection
+//	@Failure		400		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/bookmarks/collections [post]
+func (app *application) createBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var payload createBookmarkCollectionPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	collection := &store.BookmarkCollection{
+		UserID: user.ID,
+		Name:   payload.Name,
+	}
+
+	if err := app.store.Bookmarks.CreateCollection(r.Context(), collection); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, collection); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Delete Bookmark Collection
+//	@Description	Delete one of your collections. Its bookmarks are kept, outside of any collection.
+//	@Tags			Bookmarks
+//	@Accept			json
+//	@Produce		json
+//	@Param			collectionID	path	int	true	"Collection ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/bookmarks/collections/{collectionID} [delete]
+func (app *application) deleteBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionID, err := strconv.ParseInt(chi.URLParam(r, "collectionID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Bookmarks.DeleteCollection(r.Context(), user.ID, collectionID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadBookmarked flags the given posts the user bookmarked.
+func (app *application) loadBookmarked(ctx context.Context, userID int64, posts ...*store.Post) error {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	bookmarked, err := app.store.Bookmarks.GetBookmarked(ctx, userID, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.Bookmarked = bookmarked[post.ID]
+	}
+	return nil
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type communityCtxKey string
+
+const communityContextKey communityCtxKey = "community"
+
+var errNotCommunityMember = errors.New("you must join the community to post in it")
+
+type createCommunityPayload struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=1000"`
+}
+
+type setCommunityRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=owner moderator member"`
+}
+
+//	@Summary		List Communities
+//	@Description	List the communities whose name matches the search, those with the most members first. role is your role in each, if you are a member.
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Param			search	query		string	false	"Search"
+//	@Success		200		{array}		store.Community
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities [get]
+func (app *application) listCommunitiesHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.CommunityQuery{
+		Limit:  20,
+		Offset: 0,
+	}
+
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	communities, err := app.store.Communities.List(r.Context(), user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, communities); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Create Community
+//	@Description	Create a community. You become its owner.
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		createCommunityPayload	true	"Community"
+//	@Success		201		{object}	store.Community
+//	@Failure		400		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities [post]
+func (app *application) createCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	var payload createCommunityPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	community := &store.Community{
+		Name:        payload.Name,
+		Description: payload.Description,
+		CreatedBy:   &user.ID,
+	}
+
+	if err := app.store.Communities.Create(r.Context(), community); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			app.conflict(w, r, errors.New("a community with that name already exists"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, community); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Get Community
+//	@Description	Get the community by community ID
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			communityID	path		int	true	"Community ID"
+//	@Success		200			{object}	store.Community
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities/{communityID} [get]
+func (app *application) getCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	community, err := app.getCommunityFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, community); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Join Community
+//	@Description	Join the community by community ID, as a member
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			communityID	path	int	true	"Community ID"
+//	@Success		201
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities/{communityID}/membership [put]
+func (app *application) joinCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	community, err := app.getCommunityFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Communities.Join(r.Context(), community.ID, user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			app.conflict(w, r, errors.New("you are already a member of this community"))
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Leave Community
+//	@Description	Leave the community by community ID. Your posts stay in it. The owner has to hand the community over before leaving.
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			communityID	path	int	true	"Community ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities/{communityID}/membership [delete]
+func (app *application) leaveCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	community, err := app.getCommunityFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Communities.Leave(r.Context(), community.ID, user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrCommunityOwner):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		List Community Members
+//	@Description	List the members of the community whose username matches the search, the owner and moderators first
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			communityID	path		int		true	"Community ID"
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Param			search		query		string	false	"Search"
+//	@Success		200			{array}		store.CommunityMember
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities/{communityID}/members [get]
+func (app *application) getCommunityMembersHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.CommunityQuery{
+		Limit:  20,
+		Offset: 0,
+	}
+
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	community, err := app.getCommunityFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	members, err := app.store.Communities.GetMembers(r.Context(), community.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, members); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Set Community Role
+//	@Description	Make a member of the community a moderator or a plain member. Only the owner can. Making someone the owner hands the community over to them, and you become a moderator.
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			communityID	path	int						true	"Community ID"
+//	@Param			userID		path	int						true	"User ID"
+//	@Param			payload		body	setCommunityRolePayload	true	"Role"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities/{communityID}/members/{userID}/role [put]
+func (app *application) setCommunityRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var payload setCommunityRolePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	memberID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	community, err := app.getCommunityFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if community.Role != store.CommunityRoleOwner {
+		app.forbidden(w, r, errors.New("only the owner can change roles in the community"))
+		return
+	}
+
+	if err := app.store.Communities.SetRole(r.Context(), community.ID, memberID, payload.Role); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrCommunityOwner):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Remove Community Post
+//	@Description	Hide a post made in the community. The owner and moderators can remove the posts of members with a lower role. Unlike a deleted post, its author cannot restore it.
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			communityID	path	int	true	"Community ID"
+//	@Param			postID		path	int	true	"Post ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities/{communityID}/posts/{postID} [delete]
+func (app *application) removeCommunityPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	postID, err := strconv.ParseInt(chi.URLParam(r, "postID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	community, err := app.getCommunityFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	post, err := app.store.Posts.GetByID(ctx, postID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if post.CommunityID == nil || *post.CommunityID != community.ID {
+		app.notFound(w, r, errors.New("post is not in the community"))
+		return
+	}
+
+	// Authors who left the community have no role in it.
+	authorRole, err := app.store.Communities.GetRole(ctx, community.ID, post.UserID)
+	if err != nil && !errors.Is(err, store.ErrNoRecord) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !store.CanModerate(community.Role, authorRole) {
+		app.forbidden(w, r, errors.New("only the owner and moderators can remove posts, and only those of members with a lower role"))
+		return
+	}
+
+	if err := app.store.Posts.Hide(ctx, post.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, nil, auditCommunityPostRemove, "post", post.ID, store.AuditChanges(nil, map[string]any{
+		"community_id": community.ID,
+		"role":         community.Role,
+	}))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Get Community Feed
+//	@Description	Get the posts made in the community, most recently published first
+//	@Tags			Communities
+//	@Accept			json
+//	@Produce		json
+//	@Param			communityID	path		int			true	"Community ID"
+//	@Param			limit		query		int			false	"Limit"
+//	@Param			offset		query		int			false	"Offset"
+//	@Param			sort		query		string		false	"Sort"
+//	@Param			tags		query		[]string	false	"Tags"
+//	@Param			search		query		string		false	"Search"
+//	@Success		200			{array}		store.PostWithMetadata
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/communities/{communityID}/feed [get]
+func (app *application) getCommunityFeedHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	p := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+		Tags:   []string{},
+		Search: "",
+	}
+
+	if err := p.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(p); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	community, err := app.getCommunityFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	feed, err := app.store.Posts.GetCommunityFeed(ctx, community.ID, p)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadFeed(ctx, user.ID, feed); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, feed); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// checkCommunityMember returns errNotCommunityMember unless the user belongs
+// to the community.
+func (app *application) checkCommunityMember(ctx context.Context, communityID, userID int64) error {
+	if _, err := app.store.Communities.GetRole(ctx, communityID, userID); err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			return errNotCommunityMember
+		}
+		return err
+	}
+	return nil
+}
+
+func (app *application) communitiesContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		communityID, err := strconv.ParseInt(chi.URLParam(r, "communityID"), 10, 64)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		user, err := app.getUserFromCtx(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		community, err := app.store.Communities.GetByID(ctx, communityID, user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNoRecord):
+				app.notFound(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, communityContextKey, community)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *application) getCommunityFromCtx(r *http.Request) (*store.Community, error) {
+	community, ok := r.Context().Value(communityContextKey).(*store.Community)
+	if !ok {
+		return nil, errors.New("community not found in context")
+	}
+	return community, nil
+}
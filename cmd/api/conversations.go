@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type conversationCtxKey string
+
+const conversationContextKey conversationCtxKey = "conversation"
+
+type startConversationPayload struct {
+	UserIDs []int64 `json:"user_ids" validate:"required,min=1,unique,dive,min=1"`
+}
+
+type sendMessagePayload struct {
+	Content string `json:"content" validate:"required,max=2000"`
+}
+
+type markConversationReadPayload struct {
+	MessageID *int64 `json:"message_id" validate:"omitempty,min=1"`
+}
+
+type messagingSettingsPayload struct {
+	OpenMessages bool `json:"open_messages"`
+}
+
+//	@Summary		List Conversations
+//	@Description	List your conversations, those with the most recent messages first, with how many messages you have not read in each
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			limit	query		int	false	"Limit"
+//	@Param			offset	query		int	false	"Offset"
+//	@Success		200		{array}		store.Conversation
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/conversations [get]
+func (app *application) listConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.ConversationQuery{
+		Limit:  20,
+		Offset: 0,
+	}
+
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	conversations, err := app.store.Conversations.ListForUser(r.Context(), user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, conversations); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Start Conversation
+//	@Description	Start a conversation with one user, or a group conversation with several. You can only message people you follow, unless they accept messages from anyone. Starting a conversation with a single user you already have one with returns it.
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		startConversationPayload	true	"Members"
+//	@Success		200		{object}	store.Conversation
+//	@Success		201		{object}	store.Conversation
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/conversations [post]
+func (app *application) startConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload startConversationPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if slices.Contains(payload.UserIDs, user.ID) {
+		app.badRequest(w, r, errors.New("user_ids must not include yourself"))
+		return
+	}
+
+	if len(payload.UserIDs)+1 > app.config.messaging.maxGroupSize {
+		app.badRequest(w, r, fmt.Errorf("a conversation can have at most %d members", app.config.messaging.maxGroupSize))
+		return
+	}
+
+	conversation, created, err := app.store.Conversations.Start(r.Context(), user.ID, payload.UserIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrMessagingNotAllowed):
+			app.forbidden(w, r, err)
+		case errors.Is(err, store.ErrEditConflict):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	if err := app.jsonResponse(w, status, conversation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Get Conversation
+//	@Description	Get a conversation you are a member of. Each member's last_read_message_id tells how far they have read.
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationID	path		int	true	"Conversation ID"
+//	@Success		200				{object}	store.Conversation
+//	@Failure		400				{object}	map[string]string
+//	@Failure		404				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID} [get]
+func (app *application) getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	conversation, err := app.getConversationFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, conversation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Leave Conversation
+//	@Description	Leave a group conversation. Direct conversations cannot be left; block the other user instead.
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationID	path	int	true	"Conversation ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID} [delete]
+func (app *application) leaveConversationHandler(w http.ResponseWriter, r *http.Request) {
+	conversation, err := app.getConversationFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Conversations.Leave(r.Context(), conversation.ID, user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrDirectConversation):
+			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Get Messages
+//	@Description	Get the messages of a conversation, newest first. To get older messages, pass the ID of the oldest message you have as before.
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationID	path		int	true	"Conversation ID"
+//	@Param			limit			query		int	false	"Limit"
+//	@Param			before			query		int	false	"Only return messages older than this message ID"
+//	@Success		200				{array}		store.Message
+//	@Failure		400				{object}	map[string]string
+//	@Failure		404				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID}/messages [get]
+func (app *application) getMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.MessageQuery{
+		Limit: 50,
+	}
+
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	conversation, err := app.getConversationFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	messages, err := app.store.Conversations.GetMessages(r.Context(), conversation.ID, user.ID, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, messages); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Send Message
+//	@Description	Send a message to a conversation you are a member of
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationID	path		int					true	"Conversation ID"
+//	@Param			payload			body		sendMessagePayload	true	"Message"
+//	@Success		201				{object}	store.Message
+//	@Failure		400				{object}	map[string]string
+//	@Failure		403				{object}	map[string]string
+//	@Failure		404				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID}/messages [post]
+func (app *application) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var payload sendMessagePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	conversation, err := app.getConversationFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	message := &store.Message{
+		ConversationID: conversation.ID,
+		SenderID:       &user.ID,
+		Content:        payload.Content,
+	}
+
+	if err := app.store.Conversations.Send(r.Context(), message); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrMessagingNotAllowed):
+			app.forbidden(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Mark Conversation Read
+//	@Description	Record that you read a conversation up to message_id, or up to its latest message when the body is left out. The other members see it as a read receipt.
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			conversationID	path	int							true	"Conversation ID"
+//	@Param			payload			body	markConversationReadPayload	false	"Last read message"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/conversations/{conversationID}/read [put]
+func (app *application) markConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	var payload markConversationReadPayload
+
+	if err := readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	conversation, err := app.getConversationFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Conversations.MarkRead(r.Context(), conversation.ID, user.ID, payload.MessageID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Get Messaging Settings
+//	@Description	Get whether people who do not follow you can start conversations with you
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	messagingSettingsPayload
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/messaging [get]
+func (app *application) getMessagingSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	open, err := app.store.Conversations.GetOpenMessages(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, messagingSettingsPayload{OpenMessages: open}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Update Messaging Settings
+//	@Description	Set open_messages to let people who do not follow you start conversations with you. Blocked users never can.
+//	@Tags			Messages
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		messagingSettingsPayload	true	"Settings"
+//	@Success		200		{object}	messagingSettingsPayload
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/messaging [put]
+func (app *application) updateMessagingSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload messagingSettingsPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Conversations.SetOpenMessages(r.Context(), user.ID, payload.OpenMessages); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, payload); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// conversationsContextMiddleware loads a conversation the current user is a
+// member of. Conversations of others are reported as not found.
+func (app *application) conversationsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		conversationID, err := strconv.ParseInt(chi.URLParam(r, "conversationID"), 10, 64)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		user, err := app.getUserFromCtx(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		conversation, err := app.store.Conversations.GetForMember(ctx, conversationID, user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNoRecord):
+				app.notFound(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, conversationContextKey, conversation)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *application) getConversationFromCtx(r *http.Request) (*store.Conversation, error) {
+	conversation, ok := r.Context().Value(conversationContextKey).(*store.Conversation)
+	if !ok {
+		return nil, errors.New("conversation not found in context")
+	}
+	return conversation, nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"net/http"
+
+	"github.com/biboyqg/social/internal/store"
 )
 
 func (app *application) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
@@ -39,3 +41,59 @@ func (app *application) forbidden(w http.ResponseWriter, r *http.Request, err er
 	app.logger.Warnw("forbidden error", "error", err.Error(), "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
 	errorJSON(w, http.StatusForbidden, "forbidden")
 }
+
+func (app *application) preconditionFailed(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition failed", "error", err.Error(), "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	errorJSON(w, http.StatusPreconditionFailed, err.Error())
+}
+
+func (app *application) preconditionRequired(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition required", "error", err.Error(), "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	errorJSON(w, http.StatusPreconditionRequired, err.Error())
+}
+
+func (app *application) payloadTooLarge(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("payload too large", "error", err.Error(), "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	errorJSON(w, http.StatusRequestEntityTooLarge, err.Error())
+}
+
+func (app *application) unsupportedMediaType(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("unsupported media type", "error", err.Error(), "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	errorJSON(w, http.StatusUnsupportedMediaType, err.Error())
+}
+
+func (app *application) accountBanned(w http.ResponseWriter, r *http.Request, ban *store.Ban) {
+	app.logger.Warnw("banned account", "user_id", ban.UserID, "ban_id", ban.ID, "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+
+	message := "account is suspended"
+	switch {
+	case ban.Permanent:
+		message = "account is banned"
+	case ban.ExpiresAt != nil:
+		message = "account is suspended until " + *ban.ExpiresAt
+	}
+
+	errorJSON(w, http.StatusForbidden, message+": "+ban.Reason)
+}
+
+func (app *application) passwordResetRequired(w http.ResponseWriter, r *http.Request, user *store.User) {
+	app.logger.Warnw("password reset required", "user_id", user.ID, "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	errorJSON(w, http.StatusForbidden, "password reset required")
+}
+
+func (app *application) reloginRequired(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warnw("relogin required", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	errorJSON(w, http.StatusForbidden, errReloginRequired.Error())
+}
+
+func (app *application) rateLimitExceeded(w http.ResponseWriter, r *http.Request, retryAfter string) {
+	app.logger.Warnw("rate limit exceeded", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	w.Header().Set("Retry-After", retryAfter)
+	errorJSON(w, http.StatusTooManyRequests, "rate limit exceeded, retry after "+retryAfter+"s")
+}
+
+func (app *application) loginLocked(w http.ResponseWriter, r *http.Request, retryAfter string) {
+	app.logger.Warnw("login locked", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	w.Header().Set("Retry-After", retryAfter)
+	errorJSON(w, http.StatusTooManyRequests, "too many failed login attempts, retry after "+retryAfter+"s")
+}
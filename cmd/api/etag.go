@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/biboyqg/social/internal/store"
+)
+
+// postETag derives a strong entity tag for the post as rendered, with
+// everything loaded into it. It starts with the post version, which is bumped
+// on every write and is all If-Match looks at, and ends with a hash of the
+// rendering, which also changes with comments, votes and the rest that
+// If-None-Match must not serve stale.
+func postETag(post *store.Post) (string, error) {
+	body, err := json.Marshal(post)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(body)
+	return `"` + postVersion(post) + "-" + hex.EncodeToString(sum[:8]) + `"`, nil
+}
+
+func postVersion(post *store.Post) string {
+	return fmt.Sprintf("%d-%d", post.ID, post.Version)
+}
+
+// ifMatchesVersion reports whether an If-Match header value lists a tag for
+// the current version of the post, whatever else the tagged response held.
+// Weak tags never match.
+func ifMatchesVersion(header string, post *store.Post) bool {
+	prefix := `"` + postVersion(post) + "-"
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.HasPrefix(candidate, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether etag is listed in an If-None-Match header
+// value. Weak tags only match when weak comparison is allowed.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePostIfMatch rejects writes to a post unless the client proves, via
+// If-Match, that it has seen the current version.
+func (app *application) requirePostIfMatch(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		post, err := app.getPostFromCtx(r)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			app.preconditionRequired(w, r, errors.New("If-Match header is required"))
+			return
+		}
+
+		if !ifMatchesVersion(ifMatch, post) {
+			app.preconditionFailed(w, r, store.ErrEditConflict)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
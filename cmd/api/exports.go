@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// exportStaleAfter is how long an export can stay running before another
+// worker takes it over.
+const exportStaleAfter = 15 * time.Minute
+
+//	@Summary		Request Data Export
+//	@Description	Start building an archive of the authenticated user's profile, posts, comments and follows. Poll the list of exports until it is ready.
+//	@Tags			Users
+//	@Produce		json
+//	@Success		202	{object}	store.DataExport
+//	@Failure		403	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/exports [post]
+func (app *application) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	export := &store.DataExport{UserID: user.ID}
+
+	if err := app.store.Exports.Create(r.Context(), export); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			app.conflict(w, r, errors.New("an export is already in progress"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, export); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		List Data Exports
+//	@Description	List the data exports of the authenticated user
+//	@Tags			Users
+//	@Produce		json
+//	@Success		200	{array}		store.DataExport
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/exports [get]
+func (app *application) listExportsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	exports, err := app.store.Exports.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, exports); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Download Data Export
+//	@Description	Download a ready data export as a ZIP archive of JSON files
+//	@Tags			Users
+//	@Produce		application/zip
+//	@Param			exportID	path		int	true	"Export ID"
+//	@Success		200			{file}		file
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/exports/{exportID}/download [get]
+func (app *application) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	exportID, err := strconv.ParseInt(chi.URLParam(r, "exportID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	archive, err := app.store.Exports.GetArchive(r.Context(), user.ID, exportID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("export-%d.zip", exportID)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(archive); err != nil {
+		app.logger.Errorw("failed to send data export", "error", err, "export_id", exportID)
+	}
+}
+
+// processDataExports builds every queued export, one at a time.
+func (app *application) processDataExports(ctx context.Context) error {
+	for {
+		export, err := app.store.Exports.ClaimNext(ctx, exportStaleAfter)
+		if err != nil {
+			if errors.Is(err, store.ErrNoRecord) {
+				return nil
+			}
+			return err
+		}
+
+		archive, err := app.buildExportArchive(ctx, export.UserID)
+		if err != nil {
+			app.logger.Errorw("failed to build data export", "error", err, "export_id", export.ID)
+
+			if err := app.store.Exports.Fail(ctx, export.ID, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		expiresAt := time.Now().Add(app.config.account.exportExp)
+		if err := app.store.Exports.Complete(ctx, export.ID, archive, expiresAt); err != nil {
+			return err
+		}
+
+		app.logger.Infow("built data export", "export_id", export.ID, "user_id", export.UserID, "size", len(archive))
+	}
+}
+
+// buildExportArchive zips the user's data as one JSON file per kind of
+// record.
+func (app *application) buildExportArchive(ctx context.Context, userID int64) ([]byte, error) {
+	data, err := app.store.Exports.Collect(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", data.Profile},
+		{"posts.json", data.Posts},
+		{"comments.json", data.Comments},
+		{"following.json", data.Following},
+		{"followers.json", data.Followers},
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.v); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/biboyqg/social/internal/store"
 )
 
 //	@Summary		Get User Feed
-//	@Description	Get the feed of a user by user ID
+//	@Description	Get the posts of the current user and of the people they follow, including posts those people reposted. A post reposted several times shows once, with reposted_by listing who reposted it.
 //	@Tags			Feed
 //	@Accept			json
 //	@Produce		json
@@ -53,7 +54,38 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if err := app.loadFeed(ctx, user.ID, feed); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, feed); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
+
+// loadFeed fills in the posts of a feed as userID sees them.
+func (app *application) loadFeed(ctx context.Context, userID int64, feed []store.PostWithMetadata) error {
+	posts := make([]*store.Post, len(feed))
+	for i := range feed {
+		posts[i] = &feed[i].Post
+	}
+
+	if err := app.loadAttachments(ctx, posts...); err != nil {
+		return err
+	}
+
+	if err := app.loadLinkPreviews(ctx, posts...); err != nil {
+		return err
+	}
+
+	if err := app.loadQuotedPosts(ctx, posts...); err != nil {
+		return err
+	}
+
+	if err := app.loadBookmarked(ctx, userID, posts...); err != nil {
+		return err
+	}
+
+	return app.loadPolls(ctx, userID, posts...)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// defaultPublishBatchSize is used when POST_PUBLISH_BATCH_SIZE is unset or
+// not positive.
+const defaultPublishBatchSize = 100
+
+// publishScheduledPosts publishes every post whose publish_at has passed,
+// draining the backlog in batches so a restart after downtime catches up in
+// a single run.
+func (app *application) publishScheduledPosts(ctx context.Context) error {
+	batchSize := app.config.scheduler.publishBatchSize
+
+	for {
+		ids, err := app.store.Posts.PublishDue(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(ids) > 0 {
+			app.logger.Infow("published scheduled posts", "count", len(ids), "ids", ids)
+		}
+
+		if len(ids) < batchSize {
+			return nil
+		}
+	}
+}
+
+// purgeTrash hard-deletes posts and users that have been in the trash longer
+// than the retention period.
+func (app *application) purgeTrash(ctx context.Context) error {
+	retention := time.Duration(app.config.scheduler.trashRetentionDays) * 24 * time.Hour
+	before := time.Now().Add(-retention)
+
+	posts, err := app.store.Posts.PurgeDeleted(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	users, err := app.store.Users.PurgeDeleted(ctx, before)
+	if err != nil {
+		return err
+	}
+
+	if posts > 0 || users > 0 {
+		app.logger.Infow("purged trash", "posts", posts, "users", users)
+	}
+	return nil
+}
+
+// purgeLoginAttempts drops login attempts older than anything the lockout
+// checks still look at.
+func (app *application) purgeLoginAttempts(ctx context.Context) error {
+	horizon := max(app.config.auth.login.window, app.config.auth.login.lockout)
+
+	n, err := app.store.LoginAttempts.PurgeBefore(ctx, time.Now().Add(-2*horizon))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Infow("purged login attempts", "count", n)
+	}
+	return nil
+}
+
+// purgeOIDCStates drops OIDC logins that were started but never completed.
+func (app *application) purgeOIDCStates(ctx context.Context) error {
+	n, err := app.store.Identities.PurgeExpiredStates(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Infow("purged expired oidc states", "count", n)
+	}
+	return nil
+}
+
+// purgeSessions drops sessions that ended more than a day ago.
+func (app *application) purgeSessions(ctx context.Context) error {
+	n, err := app.store.Sessions.PurgeBefore(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Infow("purged sessions", "count", n)
+	}
+	return nil
+}
+
+// purgeDataExports drops data exports whose download window has passed.
+func (app *application) purgeDataExports(ctx context.Context) error {
+	n, err := app.store.Exports.PurgeExpired(ctx)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Infow("purged data exports", "count", n)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/biboyqg/social/internal/mailer"
+	"github.com/biboyqg/social/internal/store"
+)
+
+// pendingLogin is a login attempt whose credentials are being checked. It is
+// stored as a failure from the start, so that concurrent guesses cannot all
+// see the same failure count; finish it with succeeded or failed, and release
+// it in a defer for the paths that never judge the credentials.
+type pendingLogin struct {
+	app     *application
+	attempt *store.LoginAttempt
+	done    bool
+
+	// failures is the number of recent failures for the email, not counting
+	// this attempt.
+	failures int
+	// retryAfter is how long until the email or the IP address is no longer
+	// locked out, or zero if neither is.
+	retryAfter time.Duration
+}
+
+func (app *application) startLogin(r *http.Request, email string) (*pendingLogin, error) {
+	cfg := app.config.auth.login
+	now := time.Now()
+
+	attempt := &store.LoginAttempt{
+		Email: email,
+		IP:    clientIP(r),
+	}
+
+	byEmail, byIP, err := app.store.LoginAttempts.Reserve(r.Context(), attempt, now.Add(-cfg.window))
+	if err != nil {
+		return nil, err
+	}
+
+	login := &pendingLogin{app: app, attempt: attempt, failures: byEmail.Count}
+	if byEmail.Count >= cfg.maxFailures {
+		login.retryAfter = max(login.retryAfter, byEmail.Last.Add(cfg.lockout).Sub(now))
+	}
+	if byIP.Count >= cfg.maxIPFailures {
+		login.retryAfter = max(login.retryAfter, byIP.Last.Add(cfg.lockout).Sub(now))
+	}
+
+	return login, nil
+}
+
+// succeeded records the login as successful.
+func (l *pendingLogin) succeeded(r *http.Request) {
+	l.done = true
+
+	if err := l.app.store.LoginAttempts.Succeed(context.WithoutCancel(r.Context()), l.attempt.ID); err != nil {
+		l.app.logger.Errorw("failed to record login attempt", "error", err)
+	}
+}
+
+// failed keeps the login as a failure and, when it is the one that locks a
+// real account, tells its owner.
+func (l *pendingLogin) failed(r *http.Request, user *store.User) {
+	l.done = true
+
+	if user == nil || l.failures+1 != l.app.config.auth.login.maxFailures {
+		return
+	}
+
+	vars := struct {
+		Username  string
+		IP        string
+		LockedFor string
+	}{
+		Username:  user.Username,
+		IP:        l.attempt.IP,
+		LockedFor: l.app.config.auth.login.lockout.String(),
+	}
+
+	// Sent in the background so that the response to the locking attempt is
+	// not slower for real accounts than for unknown ones.
+	go func() {
+		if err := l.app.mailer.Send(mailer.AccountLockedTemplate, user.Username, user.Email, vars); err != nil {
+			l.app.logger.Errorw("failed to send lockout email", "error", err, "user_id", user.ID)
+		}
+	}()
+}
+
+// release forgets the login unless it succeeded or failed: locked out
+// attempts, cancelled requests and errors do not count.
+func (l *pendingLogin) release(r *http.Request) {
+	if l.done {
+		return
+	}
+	l.done = true
+
+	if err := l.app.store.LoginAttempts.Delete(context.WithoutCancel(r.Context()), l.attempt.ID); err != nil {
+		l.app.logger.Errorw("failed to release login attempt", "error", err)
+	}
+}
+
+// loginDelay doubles with every recent failure, up to the configured maximum.
+func (app *application) loginDelay(failures int) time.Duration {
+	cfg := app.config.auth.login
+	if failures == 0 {
+		return 0
+	}
+
+	delay := cfg.delay
+	for i := 1; i < failures && delay < cfg.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, cfg.maxDelay)
+}
+
+// recordLoginAttempt records a login that needed no credential check, such
+// as one through an identity provider.
+func (app *application) recordLoginAttempt(r *http.Request, email string, succeeded bool) {
+	attempt := &store.LoginAttempt{
+		Email:     email,
+		IP:        clientIP(r),
+		Succeeded: succeeded,
+	}
+
+	if err := app.store.LoginAttempts.Create(r.Context(), attempt); err != nil {
+		app.logger.Errorw("failed to record login attempt", "error", err)
+	}
+}
+
+// sleepContext waits for d, returning false if the request is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"github.com/biboyqg/social/internal/auth"
+	"github.com/biboyqg/social/internal/blob"
 	"github.com/biboyqg/social/internal/db"
 	"github.com/biboyqg/social/internal/env"
 	"github.com/biboyqg/social/internal/mailer"
+	"github.com/biboyqg/social/internal/policy"
+	"github.com/biboyqg/social/internal/ratelimiter"
+	"github.com/biboyqg/social/internal/scheduler"
+	"github.com/biboyqg/social/internal/secretbox"
 	"github.com/biboyqg/social/internal/store"
+	"github.com/biboyqg/social/internal/unfurl"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -42,7 +51,9 @@ func main() {
 		env:     env.GetString("ENV", "dev"),
 		version: env.GetString("VERSION", "0.0.1"),
 		mail: mailConfig{
-			exp: env.GetDuration("MAIL_EXP", 3*24*time.Hour),
+			exp:            env.GetDuration("MAIL_EXP", 3*24*time.Hour),
+			resetExp:       env.GetDuration("PASSWORD_RESET_EXP", 24*time.Hour),
+			emailChangeExp: env.GetDuration("EMAIL_CHANGE_EXP", 24*time.Hour),
 			gomail: gomailConfig{
 				host:     env.GetString("MAIL_HOST", "smtp.gmail.com"),
 				port:     env.GetInt("MAIL_PORT", 587),
@@ -62,12 +73,152 @@ func main() {
 				aud:    env.GetString("JWT_AUD", "social"),
 				iss:    env.GetString("JWT_ISS", "social"),
 			},
+			login: loginConfig{
+				window:        env.GetDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+				maxFailures:   env.GetInt("LOGIN_MAX_FAILURES", 5),
+				maxIPFailures: env.GetInt("LOGIN_MAX_IP_FAILURES", 50),
+				lockout:       env.GetDuration("LOGIN_LOCKOUT", 15*time.Minute),
+				delay:         env.GetDuration("LOGIN_DELAY", 250*time.Millisecond),
+				maxDelay:      env.GetDuration("LOGIN_MAX_DELAY", 5*time.Second),
+			},
+			mfa: mfaConfig{
+				issuer:        env.GetString("MFA_ISSUER", "Social"),
+				encryptionKey: env.GetString("MFA_ENCRYPTION_KEY", "mfa-secret"),
+				challengeExp:  env.GetDuration("MFA_CHALLENGE_EXP", 5*time.Minute),
+			},
+			oidc: oidcConfig{
+				stateExp:      env.GetDuration("OIDC_STATE_EXP", 10*time.Minute),
+				reloginWindow: env.GetDuration("OIDC_RELOGIN_WINDOW", 10*time.Minute),
+				providers:     oidcProvidersFromEnv(env.GetString("FRONTEND_URL", "http://localhost:5173")),
+			},
+		},
+		scheduler: schedulerConfig{
+			publishInterval:    env.GetDuration("POST_PUBLISH_INTERVAL", 30*time.Second),
+			publishBatchSize:   env.GetInt("POST_PUBLISH_BATCH_SIZE", defaultPublishBatchSize),
+			exportInterval:     env.GetDuration("DATA_EXPORT_INTERVAL", 30*time.Second),
+			mediaInterval:      env.GetDuration("MEDIA_PROCESS_INTERVAL", 5*time.Second),
+			unfurlInterval:     env.GetDuration("UNFURL_INTERVAL", 5*time.Second),
+			purgeInterval:      env.GetDuration("TRASH_PURGE_INTERVAL", time.Hour),
+			trashRetentionDays: env.GetInt("TRASH_RETENTION_DAYS", 30),
+		},
+		account: accountConfig{
+			deletionGrace: env.GetDuration("ACCOUNT_DELETION_GRACE", 14*24*time.Hour),
+			exportExp:     env.GetDuration("DATA_EXPORT_EXP", 7*24*time.Hour),
+		},
+		blob: blobConfig{
+			backend: env.GetString("BLOB_BACKEND", "local"),
+			root:    env.GetString("BLOB_LOCAL_ROOT", "./uploads"),
+			s3: blob.S3Config{
+				Endpoint:  env.GetString("BLOB_S3_ENDPOINT", "http://localhost:9000"),
+				Region:    env.GetString("BLOB_S3_REGION", "us-east-1"),
+				Bucket:    env.GetString("BLOB_S3_BUCKET", "social"),
+				AccessKey: env.GetString("BLOB_S3_ACCESS_KEY", "minioadmin"),
+				SecretKey: env.GetString("BLOB_S3_SECRET_KEY", "minioadmin"),
+				PathStyle: env.GetBool("BLOB_S3_PATH_STYLE", true),
+			},
+		},
+		attachments: attachmentConfig{
+			maxSize:      int64(env.GetInt("ATTACHMENT_MAX_SIZE", 10<<20)),
+			maxPerPost:   env.GetInt("ATTACHMENT_MAX_PER_POST", 4),
+			allowedTypes: strings.Split(env.GetString("ATTACHMENT_TYPES", "image/jpeg,image/png,image/gif,image/webp,application/pdf"), ","),
+			uploadExp:    env.GetDuration("ATTACHMENT_UPLOAD_EXP", 24*time.Hour),
+		},
+		media: mediaConfig{
+			thumbnailSizes: env.GetInts("MEDIA_THUMBNAIL_SIZES", []int{320}),
+			avatarSizes:    env.GetInts("MEDIA_AVATAR_SIZES", []int{64, 256}),
+			webpMaxSize:    env.GetInt("MEDIA_WEBP_MAX_SIZE", 2048),
+			maxPixels:      env.GetInt("MEDIA_MAX_PIXELS", 40_000_000),
+		},
+		unfurl: unfurlConfig{
+			timeout:      env.GetDuration("UNFURL_TIMEOUT", 5*time.Second),
+			maxBytes:     int64(env.GetInt("UNFURL_MAX_BYTES", 1<<20)),
+			maxRedirects: env.GetInt("UNFURL_MAX_REDIRECTS", 5),
+			maxLinks:     env.GetInt("UNFURL_MAX_LINKS", 3),
+			userAgent:    env.GetString("UNFURL_USER_AGENT", "SocialBot/1.0 (link preview)"),
+			cacheTTL:     env.GetDuration("UNFURL_CACHE_TTL", 24*time.Hour),
+			failureTTL:   env.GetDuration("UNFURL_FAILURE_TTL", time.Hour),
+		},
+		messaging: messagingConfig{
+			maxGroupSize: env.GetInt("MESSAGING_MAX_GROUP_SIZE", 8),
+		},
+		policy: policyConfig{
+			cacheTTL: env.GetDuration("POLICY_CACHE_TTL", time.Minute),
+		},
+		rateLimit: rateLimitConfig{
+			enabled: env.GetBool("RATE_LIMIT_ENABLED", true),
+			backend: env.GetString("RATE_LIMIT_BACKEND", "memory"),
+			redis: redisConfig{
+				addr:     env.GetString("REDIS_ADDR", "localhost:6379"),
+				password: env.GetString("REDIS_PASSWORD", ""),
+				db:       env.GetInt("REDIS_DB", 0),
+			},
+			routes: map[string]rateLimitRule{
+				"global": {
+					policy: ratelimiter.Policy{
+						Algorithm: ratelimiter.TokenBucket,
+						Limit:     env.GetInt("RATE_LIMIT_GLOBAL_REQUESTS", 100),
+						Window:    env.GetDuration("RATE_LIMIT_GLOBAL_WINDOW", 10*time.Second),
+					},
+					keyBy: rateLimitByIP,
+				},
+				"auth": {
+					policy: ratelimiter.Policy{
+						Algorithm: ratelimiter.FixedWindow,
+						Limit:     env.GetInt("RATE_LIMIT_AUTH_REQUESTS", 10),
+						Window:    env.GetDuration("RATE_LIMIT_AUTH_WINDOW", time.Minute),
+					},
+					keyBy: rateLimitByIP,
+				},
+				"post_create": {
+					policy: ratelimiter.Policy{
+						Algorithm: ratelimiter.TokenBucket,
+						Limit:     env.GetInt("RATE_LIMIT_POST_CREATE_REQUESTS", 10),
+						Window:    env.GetDuration("RATE_LIMIT_POST_CREATE_WINDOW", time.Minute),
+					},
+					keyBy: rateLimitByUser,
+				},
+				"attachment_upload": {
+					policy: ratelimiter.Policy{
+						Algorithm: ratelimiter.TokenBucket,
+						Limit:     env.GetInt("RATE_LIMIT_ATTACHMENT_UPLOAD_REQUESTS", 20),
+						Window:    env.GetDuration("RATE_LIMIT_ATTACHMENT_UPLOAD_WINDOW", time.Minute),
+					},
+					keyBy: rateLimitByUser,
+				},
+				"message_send": {
+					policy: ratelimiter.Policy{
+						Algorithm: ratelimiter.TokenBucket,
+						Limit:     env.GetInt("RATE_LIMIT_MESSAGE_SEND_REQUESTS", 30),
+						Window:    env.GetDuration("RATE_LIMIT_MESSAGE_SEND_WINDOW", time.Minute),
+					},
+					keyBy: rateLimitByUser,
+				},
+				"report_create": {
+					policy: ratelimiter.Policy{
+						Algorithm: ratelimiter.FixedWindow,
+						Limit:     env.GetInt("RATE_LIMIT_REPORT_CREATE_REQUESTS", 20),
+						Window:    env.GetDuration("RATE_LIMIT_REPORT_CREATE_WINDOW", time.Hour),
+					},
+					keyBy: rateLimitByUser,
+				},
+			},
 		},
 	}
 
 	logger := zap.Must(zap.NewProduction()).Sugar()
 	defer logger.Sync()
 
+	trustedProxies, err := parseTrustedProxies(env.GetString("TRUSTED_PROXIES", ""))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	cfg.proxies = trustedProxies
+
+	if cfg.scheduler.publishBatchSize <= 0 {
+		logger.Warnw("POST_PUBLISH_BATCH_SIZE must be positive, using the default", "value", cfg.scheduler.publishBatchSize)
+		cfg.scheduler.publishBatchSize = defaultPublishBatchSize
+	}
+
 	db, err := db.New(
 		cfg.db.addr,
 		cfg.db.maxOpenConns,
@@ -90,6 +241,50 @@ func main() {
 		cfg.mail.gomail.sender,
 	)
 
+	var rateLimitBackend ratelimiter.Backend = ratelimiter.NewMemoryBackend()
+	if cfg.rateLimit.backend == "redis" {
+		rdb := redis.NewClient(&redis.Options{
+			Addr:     cfg.rateLimit.redis.addr,
+			Password: cfg.rateLimit.redis.password,
+			DB:       cfg.rateLimit.redis.db,
+		})
+		defer rdb.Close()
+
+		if err := rdb.Ping(context.Background()).Err(); err != nil {
+			logger.Fatal(err)
+		}
+		logger.Info("redis connection established")
+
+		rateLimitBackend = ratelimiter.NewRedisBackend(rdb, "ratelimit:")
+	}
+
+	rateLimiters, err := newRateLimiters(cfg.rateLimit, rateLimitBackend)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	secretBox, err := secretbox.New(cfg.auth.mfa.encryptionKey)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	blobStore, err := newBlobStore(cfg.blob)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	cfg.unfurl.domainTTLs, err = parseDomainTTLs(env.GetString("UNFURL_DOMAIN_TTLS", ""))
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	unfurler := unfurl.New(unfurl.Options{
+		Timeout:      cfg.unfurl.timeout,
+		MaxBytes:     cfg.unfurl.maxBytes,
+		MaxRedirects: cfg.unfurl.maxRedirects,
+		UserAgent:    cfg.unfurl.userAgent,
+	})
+
 	jwtAuthenticator := auth.NewJWTAuthenticator(cfg.auth.token.secret, cfg.auth.token.aud, cfg.auth.token.iss)
 
 	app := &application{
@@ -98,8 +293,32 @@ func main() {
 		logger:        logger,
 		mailer:        mailer,
 		authenticator: jwtAuthenticator,
+		policy:        policy.New(store.Permissions, cfg.policy.cacheTTL),
+		rateLimiters:  rateLimiters,
+		secretBox:     secretBox,
+		oidcProviders: newOIDCProviders(cfg.auth.oidc),
+		blob:          blobStore,
+		unfurler:      unfurler,
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := scheduler.New(logger)
+	jobs.Every("publish-scheduled-posts", cfg.scheduler.publishInterval, app.publishScheduledPosts)
+	jobs.Every("purge-trash", cfg.scheduler.purgeInterval, app.purgeTrash)
+	jobs.Every("purge-login-attempts", cfg.scheduler.purgeInterval, app.purgeLoginAttempts)
+	jobs.Every("purge-oidc-states", cfg.scheduler.purgeInterval, app.purgeOIDCStates)
+	jobs.Every("purge-sessions", cfg.scheduler.purgeInterval, app.purgeSessions)
+	jobs.Every("process-data-exports", cfg.scheduler.exportInterval, app.processDataExports)
+	jobs.Every("purge-data-exports", cfg.scheduler.purgeInterval, app.purgeDataExports)
+	jobs.Every("purge-attachments", cfg.scheduler.purgeInterval, app.purgeAttachments)
+	jobs.Every("process-media", cfg.scheduler.mediaInterval, app.processMedia)
+	jobs.Every("unfurl-links", cfg.scheduler.unfurlInterval, app.unfurlLinks)
+	jobs.Every("purge-link-previews", cfg.scheduler.purgeInterval, app.purgeLinkPreviews)
+	jobs.Every("purge-jobs", cfg.scheduler.purgeInterval, app.purgeJobs)
+	jobs.Start(ctx)
+
 	mux := app.mount()
 
 	logger.Fatal(app.run(mux))
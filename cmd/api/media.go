@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/biboyqg/social/internal/media"
+	"github.com/biboyqg/social/internal/store"
+)
+
+// processMedia strips metadata from uploaded images and renders their
+// variants.
+func (app *application) processMedia(ctx context.Context) error {
+	return app.runJobs(ctx, store.JobProcessMedia, app.processMediaJob, app.failMediaJob)
+}
+
+func (app *application) processMediaJob(ctx context.Context, job *store.Job) error {
+	var payload store.MediaJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanent(err)
+	}
+
+	attachment, err := app.store.Attachments.StartProcessing(ctx, payload.AttachmentID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			return nil
+		}
+		return err
+	}
+
+	body, err := app.blob.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	sizes := app.config.media.thumbnailSizes
+	if attachment.Purpose == store.AttachmentForAvatar {
+		sizes = app.config.media.avatarSizes
+	}
+
+	// Processing is deterministic: an image that cannot be processed now
+	// never will be.
+	res, err := media.Process(data, media.Options{
+		ThumbnailSizes: sizes,
+		WebPMaxSize:    app.config.media.webpMaxSize,
+		MaxPixels:      app.config.media.maxPixels,
+	})
+	if err != nil {
+		return permanent(err)
+	}
+
+	keys := []string{attachment.StorageKey}
+	if err := app.blob.Put(ctx, attachment.StorageKey, bytes.NewReader(res.Original), int64(len(res.Original)), attachment.ContentType); err != nil {
+		return err
+	}
+
+	attachment.Variants = attachment.Variants[:0]
+	for _, v := range res.Variants {
+		key := attachment.StorageKey + "_" + v.Name
+		if err := app.blob.Put(ctx, key, bytes.NewReader(v.Data), int64(len(v.Data)), v.ContentType); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+
+		attachment.Variants = append(attachment.Variants, store.AttachmentVariant{
+			Name:        v.Name,
+			StorageKey:  key,
+			ContentType: v.ContentType,
+			Width:       v.Width,
+			Height:      v.Height,
+			Size:        int64(len(v.Data)),
+		})
+	}
+
+	attachment.Size = int64(len(res.Original))
+	attachment.Width = &res.Width
+	attachment.Height = &res.Height
+	attachment.Blurhash = res.Blurhash
+
+	if err := app.store.Attachments.CompleteProcessing(ctx, attachment); err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			// The attachment was deleted while we worked on it; nothing
+			// refers to the blobs we just wrote.
+			for _, key := range keys {
+				if err := app.blob.Delete(ctx, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return err
+	}
+
+	app.logger.Infow("processed image", "attachment_id", attachment.ID, "width", res.Width, "height", res.Height, "variants", len(res.Variants))
+	return nil
+}
+
+func (app *application) failMediaJob(ctx context.Context, job *store.Job) error {
+	var payload store.MediaJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil
+	}
+	return app.store.Attachments.SetStatus(ctx, payload.AttachmentID, store.AttachmentFailed)
+}
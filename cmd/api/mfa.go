@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/biboyqg/social/internal/totp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	mfaTokenType      = "mfa"
+	recoveryCodeCount = 10
+)
+
+var errInvalidMFACode = errors.New("invalid two-factor code")
+
+type mfaEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+//	@Summary		Enroll MFA
+//	@Description	Start setting up two-factor authentication. Add the secret to an authenticator app, then confirm with a code.
+//	@Tags			MFA
+//	@Accept			json
+//	@Produce		json
+//	@Success		201	{object}	mfaEnrollment
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa [post]
+func (app *application) enrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if user.MFAEnabled {
+		app.conflict(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sealed, err := app.secretBox.Seal([]byte(secret))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.MFA.Enroll(r.Context(), user.ID, sealed); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			app.conflict(w, r, errors.New("two-factor authentication is already enabled"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	enrollment := mfaEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.URI(app.config.auth.mfa.issuer, user.Email, secret),
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, enrollment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type confirmMFAPayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+//	@Summary		Confirm MFA
+//	@Description	Turn two-factor authentication on with a code from the authenticator app. The recovery codes are only shown once.
+//	@Tags			MFA
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		confirmMFAPayload	true	"Code"
+//	@Success		200		{object}	recoveryCodesResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/confirm [post]
+func (app *application) confirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload confirmMFAPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	mfa, err := app.store.MFA.Get(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if mfa.ConfirmedAt != nil {
+		app.conflict(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := app.secretBox.Open(mfa.Secret)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	step, ok := totp.Validate(string(secret), payload.Code, time.Now())
+	if !ok {
+		app.badRequest(w, r, errInvalidMFACode)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.MFA.Confirm(ctx, user.ID, step, hashes); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.conflict(w, r, errors.New("two-factor authentication is already enabled"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditMFAEnable, "user", user.ID, nil)
+
+	if err := app.jsonResponse(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type reauthenticatePayload struct {
+	Password string `json:"password" validate:"max=72"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+//	@Summary		Regenerate Recovery Codes
+//	@Description	Replace all recovery codes. Requires the password and a current code. Users without a password, created through an identity provider, must have signed in within the last few minutes instead.
+//	@Tags			MFA
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		reauthenticatePayload	true	"Password and two-factor code"
+//	@Success		200		{object}	recoveryCodesResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.reauthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.MFA.ReplaceRecoveryCodes(r.Context(), user.ID, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, &user.ID, auditMFARecoveryCodes, "user", user.ID, nil)
+
+	if err := app.jsonResponse(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Disable MFA
+//	@Description	Turn two-factor authentication off. Requires the password and a current code or a recovery code. Users without a password, created through an identity provider, must have signed in within the last few minutes instead.
+//	@Tags			MFA
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body	reauthenticatePayload	true	"Password and two-factor code"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		401	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		429	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/mfa [delete]
+func (app *application) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.reauthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.store.MFA.Disable(r.Context(), user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditMFADisable, "user", user.ID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type verifyMFAPayload struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+//	@Summary		Verify MFA
+//	@Description	Exchange an MFA challenge token and a code from the authenticator app, or a recovery code, for an access token
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		verifyMFAPayload	true	"Challenge and code"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		401		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		429		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Router			/authentication/token/mfa [post]
+func (app *application) verifyMFAHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload verifyMFAPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	userID, err := app.parseMFAChallenge(payload.MFAToken)
+	if err != nil {
+		app.unauthorized(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.unauthorized(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	login, err := app.startLogin(r, user.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	defer login.release(r)
+
+	if login.retryAfter > 0 {
+		app.loginLocked(w, r, ceilSeconds(login.retryAfter))
+		return
+	}
+
+	if !sleepContext(ctx, app.loginDelay(login.failures)) {
+		return
+	}
+
+	if err := app.verifyMFACode(ctx, user, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, errInvalidMFACode):
+			app.audit(r, &user.ID, auditLoginFailed, "user", user.ID, nil)
+			login.failed(r, user)
+			app.unauthorized(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkNotBanned(w, r, user.ID) {
+		return
+	}
+
+	if user.PasswordResetRequired {
+		app.passwordResetRequired(w, r, user)
+		return
+	}
+
+	login.succeeded(r)
+	app.issueToken(w, r, user)
+}
+
+// issueMFAChallenge responds with a short-lived token that proves the first
+// factor and can only be exchanged at the MFA verification endpoint.
+func (app *application) issueMFAChallenge(w http.ResponseWriter, r *http.Request, user *store.User) {
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"typ": mfaTokenType,
+		"exp": time.Now().Add(app.config.auth.mfa.challengeExp).Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.auth.token.iss,
+		"aud": app.config.auth.token.aud,
+	}
+
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, map[string]string{"mfa_token": token}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) parseMFAChallenge(token string) (int64, error) {
+	jwtToken, err := app.authenticator.ValidateToken(token)
+	if err != nil {
+		return 0, err
+	}
+
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok || !jwtToken.Valid || claims["typ"] != mfaTokenType {
+		return 0, errors.New("invalid MFA challenge token")
+	}
+
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, errors.New("invalid user ID in token claims")
+	}
+
+	return int64(userID), nil
+}
+
+// verifyMFACode accepts either a TOTP code or an unused recovery code, and
+// spends it. Anything else is errInvalidMFACode.
+func (app *application) verifyMFACode(ctx context.Context, user *store.User, code string) error {
+	mfa, err := app.store.MFA.Get(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+	if mfa.ConfirmedAt == nil {
+		return errInvalidMFACode
+	}
+
+	if len(code) != totp.Digits {
+		err := app.store.MFA.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+		if errors.Is(err, store.ErrNoRecord) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+
+	secret, err := app.secretBox.Open(mfa.Secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := totp.Validate(string(secret), code, time.Now())
+	if !ok {
+		return errInvalidMFACode
+	}
+
+	if err := app.store.MFA.UseStep(ctx, user.ID, step); err != nil {
+		if errors.Is(err, store.ErrMFACodeUsed) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// reauthenticate checks the password, or for users without one a recent
+// sign-in, and the second factor of the authenticated user before a
+// sensitive change to their 2FA settings. Failed guesses count towards the
+// same lockout as failed logins, so that a stolen session cannot be used to
+// guess them.
+func (app *application) reauthenticate(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	ctx := r.Context()
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+
+	var payload reauthenticatePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+
+	login, err := app.startLogin(r, user.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+	defer login.release(r)
+
+	if login.retryAfter > 0 {
+		app.loginLocked(w, r, ceilSeconds(login.retryAfter))
+		return nil, false
+	}
+
+	if !sleepContext(ctx, app.loginDelay(login.failures)) {
+		return nil, false
+	}
+
+	if err := app.confirmPassword(r, user, payload.Password); err != nil {
+		switch {
+		case errors.Is(err, errReloginRequired):
+			app.reloginRequired(w, r)
+		default:
+			app.audit(r, &user.ID, auditReauthFailed, "user", user.ID, nil)
+			login.failed(r, user)
+			app.unauthorized(w, r, err)
+		}
+		return nil, false
+	}
+
+	if err := app.verifyMFACode(ctx, user, payload.Code); err != nil {
+		switch {
+		case errors.Is(err, errInvalidMFACode):
+			app.audit(r, &user.ID, auditReauthFailed, "user", user.ID, nil)
+			login.failed(r, user)
+			app.unauthorized(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	login.succeeded(r)
+	return user, true
+}
+
+// generateRecoveryCodes returns codes formatted for the user, like
+// "abcd-efgh-ijkl-mnop", and their hashes for storage.
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(encoding.EncodeToString(b))
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		hashes[i] = hashRecoveryCode(raw)
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hash[:]
+}
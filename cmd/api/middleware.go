@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -26,36 +27,139 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 		}
 
 		token := authParts[1]
-		jwtToken, err := app.authenticator.ValidateToken(token)
+		ctx := r.Context()
+
+		var (
+			userID      int64
+			sessionID   int64
+			accessToken *store.AccessToken
+			err         error
+		)
+		if strings.HasPrefix(token, accessTokenPrefix) {
+			accessToken, err = app.store.AccessTokens.GetByHash(ctx, hashAccessToken(token))
+			if err != nil {
+				switch {
+				case errors.Is(err, store.ErrNoRecord):
+					app.unauthorized(w, r, errors.New("invalid or expired access token"))
+				default:
+					app.internalServerError(w, r, err)
+				}
+				return
+			}
+			userID = accessToken.UserID
+		} else {
+			userID, sessionID, err = app.parseSessionToken(token)
+			if err != nil {
+				app.unauthorized(w, r, err)
+				return
+			}
+		}
+
+		user, err := app.store.Users.GetByID(ctx, userID)
 		if err != nil {
 			app.unauthorized(w, r, err)
 			return
 		}
 
-		claims, ok := jwtToken.Claims.(jwt.MapClaims)
-		if !ok || !jwtToken.Valid {
-			app.unauthorized(w, r, errors.New("invalid token claims"))
+		// Bans are checked on every request so that tokens issued before the
+		// ban stop working right away.
+		if !app.checkNotBanned(w, r, user.ID) {
 			return
 		}
 
-		userID, ok := claims["sub"].(float64)
-		if !ok {
-			app.unauthorized(w, r, errors.New("invalid user ID in token claims"))
+		if user.PasswordResetRequired {
+			app.passwordResetRequired(w, r, user)
 			return
 		}
 
-		ctx := r.Context()
+		if accessToken != nil {
+			if err := app.store.AccessTokens.Touch(ctx, accessToken.ID); err != nil {
+				app.logger.Errorw("failed to record access token use", "error", err, "token_id", accessToken.ID)
+			}
+			ctx = context.WithValue(ctx, accessTokenCtxKey, accessToken)
+		} else {
+			session, err := app.store.Sessions.GetActive(ctx, user.ID, sessionID)
+			if err != nil {
+				switch {
+				case errors.Is(err, store.ErrNoRecord):
+					app.unauthorized(w, r, errors.New("session has ended"))
+				default:
+					app.internalServerError(w, r, err)
+				}
+				return
+			}
 
-		user, err := app.store.Users.GetByID(ctx, int64(userID))
-		if err != nil {
-			app.unauthorized(w, r, err)
-			return
+			if err := app.store.Sessions.Touch(ctx, session.ID, clientIP(r)); err != nil {
+				app.logger.Errorw("failed to record session activity", "error", err, "session_id", session.ID)
+			}
+			ctx = context.WithValue(ctx, sessionCtxKey, session)
 		}
 
 		ctx = context.WithValue(ctx, userCtxKey, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// parseSessionToken validates a login token and returns the user and the
+// session it was issued for.
+func (app *application) parseSessionToken(token string) (int64, int64, error) {
+	jwtToken, err := app.authenticator.ValidateToken(token)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok || !jwtToken.Valid {
+		return 0, 0, errors.New("invalid token claims")
+	}
+
+	// MFA challenge tokens only prove the password and are only good for
+	// the second login step.
+	if claims["typ"] == mfaTokenType {
+		return 0, 0, errors.New("token is an MFA challenge")
+	}
+
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid user ID in token claims")
+	}
+
+	// Tokens without a session cannot be revoked, so they are not accepted.
+	sessionID, ok := claims["sid"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid session ID in token claims")
+	}
+	return int64(userID), int64(sessionID), nil
+}
+
+// RequireScope stops personal access tokens that were not granted the scope.
+// Login sessions are not limited by scopes.
+func (app *application) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := getAccessTokenFromCtx(r); ok && !token.HasScope(scope) {
+				app.forbidden(w, r, fmt.Errorf("access token is missing the %q scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession only lets through users who logged in, keeping personal
+// access tokens away from account security settings.
+func (app *application) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := getAccessTokenFromCtx(r); ok {
+			app.forbidden(w, r, errors.New("access tokens cannot be used for this resource"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -95,7 +199,7 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
-func (app *application) checkPostOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+func (app *application) checkPostOwnership(permission string, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, err := app.getUserFromCtx(r)
 		if err != nil {
@@ -113,7 +217,7 @@ func (app *application) checkPostOwnership(requiredRole string, next http.Handle
 			return
 		}
 
-		allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRole)
+		allowed, err := app.can(r.Context(), user, permission)
 		if err != nil {
 			app.internalServerError(w, r, err)
 			return
@@ -128,11 +232,59 @@ func (app *application) checkPostOwnership(requiredRole string, next http.Handle
 	})
 }
 
-func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
-	role, err := app.store.Roles.GetByName(ctx, roleName)
+// checkNotBanned writes the ban response and returns false if the user has an
+// active suspension or ban.
+func (app *application) checkNotBanned(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	ban, err := app.store.Bans.GetActive(r.Context(), userID)
 	if err != nil {
-		return false, err
+		if errors.Is(err, store.ErrNoRecord) {
+			return true
+		}
+		app.internalServerError(w, r, err)
+		return false
 	}
 
-	return user.Role.Level >= role.Level, nil
+	app.accountBanned(w, r, ban)
+	return false
+}
+
+// RequirePermission only lets through authenticated users whose role has
+// been granted the permission.
+func (app *application) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := app.getUserFromCtx(r)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+
+			allowed, err := app.can(r.Context(), user, permission)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+
+			if !allowed {
+				app.forbidden(w, r, errors.New("user is not authorized to access this resource"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// can asks the policy engine whether the user's role grants the permission.
+func (app *application) can(ctx context.Context, user *store.User, permission string) (bool, error) {
+	return app.policy.Can(ctx, user.RoleID, permission)
+}
+
+// outranks reports whether actor may act against target: someone else whose
+// role holds only a strict subset of the actor's permissions.
+func (app *application) outranks(ctx context.Context, actor, target *store.User) (bool, error) {
+	if actor.ID == target.ID {
+		return false, nil
+	}
+	return app.policy.Outranks(ctx, actor.RoleID, target.RoleID)
 }
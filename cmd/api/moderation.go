@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/biboyqg/social/internal/mailer"
+	"github.com/biboyqg/social/internal/policy"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type reportCtxKey string
+
+const reportContextKey reportCtxKey = "report"
+
+type createReportPayload struct {
+	TargetType string `json:"target_type" validate:"required,oneof=post comment user"`
+	TargetID   int64  `json:"target_id" validate:"required,gt=0"`
+	Reason     string `json:"reason" validate:"required,max=1000"`
+}
+
+type assignReportPayload struct {
+	AssigneeID *int64 `json:"assignee_id" validate:"omitempty,gt=0"`
+}
+
+type resolveReportPayload struct {
+	Action       string     `json:"action" validate:"required,oneof=hide delete warn ban dismiss"`
+	Note         string     `json:"note" validate:"max=1000"`
+	BanPermanent bool       `json:"ban_permanent"`
+	BanExpiresAt *time.Time `json:"ban_expires_at"`
+}
+
+//	@Summary		Create Report
+//	@Description	Flag a post, comment or user for moderators to review
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			report	body		createReportPayload	true	"Report"
+//	@Success		201		{object}	store.Report
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/reports [post]
+func (app *application) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload createReportPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.reportTargetExists(r, payload.TargetType, payload.TargetID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	report := &store.Report{
+		ReporterID: &user.ID,
+		TargetType: payload.TargetType,
+		TargetID:   payload.TargetID,
+		Reason:     payload.Reason,
+	}
+
+	if err := app.store.Reports.Create(ctx, report); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			app.conflict(w, r, errors.New("you already reported this"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Get Moderation Queue
+//	@Description	List reports for moderators, oldest first
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			status		query		string	false	"Status (open, in_review, resolved, dismissed)"
+//	@Param			target_type	query		string	false	"Target type (post, comment, user)"
+//	@Param			assignee_id	query		int		false	"Assignee ID"
+//	@Param			limit		query		int		false	"Limit"
+//	@Param			offset		query		int		false	"Offset"
+//	@Success		200			{array}		store.Report
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/queue [get]
+func (app *application) getModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	q := store.ReportQueueQuery{
+		Status: store.ReportStatusOpen,
+		Limit:  20,
+		Offset: 0,
+	}
+
+	if err := q.Parse(r); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(q); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	reports, err := app.store.Reports.GetQueue(r.Context(), q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, reports); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Get Report
+//	@Description	Get a report together with the audit trail of every decision on it
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			reportID	path		int	true	"Report ID"
+//	@Success		200			{object}	store.Report
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID} [get]
+func (app *application) getReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := app.getReportFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	actions, err := app.store.Reports.GetActions(r.Context(), report.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	report.Actions = actions
+
+	if err := app.jsonResponse(w, http.StatusOK, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Assign Report
+//	@Description	Assign a pending report to a moderator, the caller by default
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			reportID	path	int					true	"Report ID"
+//	@Param			payload		body	assignReportPayload	false	"Assignee"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID}/assign [put]
+func (app *application) assignReportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload assignReportPayload
+
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &payload); err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	actor, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	report, err := app.getReportFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	assigneeID := actor.ID
+	if payload.AssigneeID != nil && *payload.AssigneeID != actor.ID {
+		assignee, err := app.store.Users.GetByID(ctx, *payload.AssigneeID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNoRecord):
+				app.notFound(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		allowed, err := app.can(ctx, assignee, policy.ReportModerate)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if !allowed {
+			app.badRequest(w, r, errors.New("reports can only be assigned to moderators"))
+			return
+		}
+
+		assigneeID = assignee.ID
+	}
+
+	if err := app.store.Reports.Assign(ctx, report.ID, actor.ID, assigneeID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrReportClosed):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &actor.ID, auditReportAssign, "report", report.ID, store.AuditChanges(
+		map[string]any{"assignee_id": report.AssigneeID, "status": report.Status},
+		map[string]any{"assignee_id": &assigneeID, "status": store.ReportStatusInReview},
+	))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Resolve Report
+//	@Description	Apply a moderation decision (hide, delete, warn, ban or dismiss) to the reported target. Every pending report on the same target is closed with it. Hiding, deleting and banning need the same permissions as the matching routes, and only dismiss applies to targets owned by users with the same or more permissions. Deleting a comment hides it.
+//	@Tags			Moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			reportID	path		int						true	"Report ID"
+//	@Param			payload		body		resolveReportPayload	true	"Decision"
+//	@Success		200			{object}	store.Report
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID}/resolve [post]
+func (app *application) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload resolveReportPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	actor, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	report, err := app.getReportFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !slices.Contains(store.ReportResolutions[report.TargetType], payload.Action) {
+		app.badRequest(w, r, errors.New("action "+payload.Action+" does not apply to a "+report.TargetType))
+		return
+	}
+
+	var owner *store.User
+	if payload.Action != store.ModerationDismiss && report.TargetOwnerID != nil {
+		owner, err = app.store.Users.GetByID(ctx, *report.TargetOwnerID)
+		if err != nil && !errors.Is(err, store.ErrNoRecord) {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	decision := store.ModerationDecision{
+		Action: payload.Action,
+		Note:   payload.Note,
+	}
+
+	switch {
+	case payload.Action == store.ModerationBan:
+		if owner == nil {
+			app.notFound(w, r, store.ErrNoRecord)
+			return
+		}
+
+		decision.BanPermanent = payload.BanPermanent
+		decision.BanExpiresAt, err = banExpiry(payload.BanPermanent, payload.BanExpiresAt)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		replacesPermanent, err := app.hasPermanentBan(ctx, owner.ID)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		allowed, err := app.checkBanPrecedence(ctx, actor, owner, payload.BanPermanent || replacesPermanent)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if !allowed {
+			app.forbidden(w, r, errors.New("user cannot ban this account"))
+			return
+		}
+	default:
+		if permission, ok := moderationPermissions[report.TargetType][payload.Action]; ok {
+			allowed, err := app.can(ctx, actor, permission)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			if !allowed {
+				app.forbidden(w, r, errors.New("user cannot "+payload.Action+" this "+report.TargetType))
+				return
+			}
+		}
+
+		// Moderators cannot act against accounts, or the posts and comments
+		// of accounts, holding all of their permissions.
+		if owner != nil && owner.ID != actor.ID {
+			allowed, err := app.outranks(ctx, actor, owner)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			if !allowed {
+				app.forbidden(w, r, errors.New("cannot moderate a user with the same or more permissions"))
+				return
+			}
+		}
+	}
+
+	previousStatus := report.Status
+
+	if err := app.store.Reports.Resolve(ctx, report, actor.ID, decision); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrReportClosed):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &actor.ID, auditReportResolve, "report", report.ID, store.AuditChanges(
+		map[string]any{"status": previousStatus, "resolution": nil},
+		map[string]any{"status": report.Status, "resolution": payload.Action},
+	))
+
+	if payload.Action == store.ModerationWarn && owner != nil {
+		vars := struct {
+			Username   string
+			TargetType string
+			Note       string
+		}{
+			Username:   owner.Username,
+			TargetType: report.TargetType,
+			Note:       payload.Note,
+		}
+
+		if err := app.mailer.Send(mailer.UserWarningTemplate, owner.Username, owner.Email, vars); err != nil {
+			app.logger.Errorw("failed to send warning email", "error", err, "user_id", owner.ID)
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// moderationPermissions are the permissions resolving a report with an action
+// requires on top of report:moderate, the same the routes for the action
+// require.
+var moderationPermissions = map[string]map[string]string{
+	store.ReportTargetPost: {
+		store.ModerationHide:   policy.PostHideAny,
+		store.ModerationDelete: policy.PostDeleteAny,
+	},
+	store.ReportTargetComment: {
+		store.ModerationHide:   policy.CommentDeleteAny,
+		store.ModerationDelete: policy.CommentDeleteAny,
+	},
+	store.ReportTargetUser: {
+		store.ModerationDelete: policy.UserDeleteAny,
+	},
+}
+
+// reportTargetExists returns ErrNoRecord unless the reporter can see the
+// target, so that reports cannot probe for posts and comments the read
+// endpoints would not show them.
+func (app *application) reportTargetExists(r *http.Request, targetType string, targetID int64) error {
+	ctx := r.Context()
+
+	switch targetType {
+	case store.ReportTargetPost:
+		return app.reportPostVisible(r, targetID)
+	case store.ReportTargetComment:
+		comment, err := app.store.Comments.GetByID(ctx, targetID)
+		if err != nil {
+			return err
+		}
+		if comment.HiddenAt != nil {
+			return store.ErrNoRecord
+		}
+		return app.reportPostVisible(r, comment.PostID)
+	case store.ReportTargetUser:
+		_, err := app.store.Users.GetByID(ctx, targetID)
+		return err
+	default:
+		return errors.New("unknown report target type")
+	}
+}
+
+func (app *application) reportPostVisible(r *http.Request, postID int64) error {
+	post, err := app.store.Posts.GetByID(r.Context(), postID)
+	if err != nil {
+		return err
+	}
+
+	visible, err := app.canViewPost(r, post)
+	if err != nil {
+		return err
+	}
+	if !visible {
+		return store.ErrNoRecord
+	}
+	return nil
+}
+
+func (app *application) reportsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		reportID, err := strconv.ParseInt(chi.URLParam(r, "reportID"), 10, 64)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		report, err := app.store.Reports.GetByID(ctx, reportID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNoRecord):
+				app.notFound(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, reportContextKey, report)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (app *application) getReportFromCtx(r *http.Request) (*store.Report, error) {
+	report, ok := r.Context().Value(reportContextKey).(*store.Report)
+	if !ok {
+		return nil, errors.New("report not found in context")
+	}
+	return report, nil
+}
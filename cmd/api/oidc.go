@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/biboyqg/social/internal/env"
+	"github.com/biboyqg/social/internal/oidc"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	usernameAttempts = 5
+
+	// oidcStateCookie binds a login to the browser that started it, so that
+	// a state handed to someone else cannot complete it.
+	oidcStateCookie = "oidc_state"
+)
+
+var (
+	errUnknownProvider  = errors.New("unknown identity provider")
+	errInvalidOIDCState = errors.New("invalid or expired login state")
+	errUnverifiedEmail  = errors.New("the identity provider has not verified this email")
+
+	usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+)
+
+// oidcProvidersFromEnv reads the providers listed in OIDC_PROVIDERS, each
+// configured through OIDC_<NAME>_* variables.
+func oidcProvidersFromEnv(frontendURL string) map[string]oidc.Config {
+	providers := make(map[string]oidc.Config)
+
+	for _, name := range strings.Split(env.GetString("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers[name] = oidc.Config{
+			IssuerURL:    env.GetString(prefix+"ISSUER_URL", ""),
+			ClientID:     env.GetString(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.GetString(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  env.GetString(prefix+"REDIRECT_URL", fmt.Sprintf("%s/auth/%s/callback", frontendURL, name)),
+			Scopes:       strings.Fields(env.GetString(prefix+"SCOPES", "")),
+		}
+	}
+
+	return providers
+}
+
+func newOIDCProviders(cfg oidcConfig) map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider, len(cfg.providers))
+	for name, c := range cfg.providers {
+		providers[name] = oidc.NewProvider(name, c)
+	}
+	return providers
+}
+
+type oidcAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+//	@Summary		Start OIDC Login
+//	@Description	Get the URL to send the user to in order to sign in with an external identity provider. The response sets a cookie the browser must send back with the callback.
+//	@Tags			Authentication
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		200			{object}	oidcAuthorization
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/authentication/oidc/{provider} [get]
+func (app *application) startOIDCHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFound(w, r, errUnknownProvider)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		values[i] = v
+	}
+
+	state := &store.OIDCState{
+		State:        values[0],
+		Provider:     provider.Name(),
+		Nonce:        values[1],
+		CodeVerifier: values[2],
+		ExpiresAt:    time.Now().Add(app.config.auth.oidc.stateExp),
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Identities.CreateState(r.Context(), state); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.setOIDCStateCookie(w, provider.Name(), state.State, int(app.config.auth.oidc.stateExp.Seconds()))
+
+	if err := app.jsonResponse(w, http.StatusOK, oidcAuthorization{AuthorizationURL: authURL}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type OIDCCallbackPayload struct {
+	Code  string `json:"code" validate:"required,max=2048"`
+	State string `json:"state" validate:"required,max=64"`
+}
+
+//	@Summary		Complete OIDC Login
+//	@Description	Exchange the code the identity provider redirected back with for a token. The state must match the cookie set when the login was started. The external account is linked to the user with the same verified email, or a new user is created. Users with two-factor authentication get an mfa_token instead.
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			provider	path		string				true	"Provider name"
+//	@Param			payload		body		OIDCCallbackPayload	true	"Code and state from the redirect"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		401			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Router			/authentication/oidc/{provider}/callback [post]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFound(w, r, errUnknownProvider)
+		return
+	}
+
+	var payload OIDCCallbackPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(payload.State)) != 1 {
+		app.badRequest(w, r, errInvalidOIDCState)
+		return
+	}
+	app.setOIDCStateCookie(w, provider.Name(), "", -1)
+
+	state, err := app.store.Identities.ConsumeState(ctx, provider.Name(), payload.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.badRequest(w, r, errInvalidOIDCState)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	claims, err := provider.Exchange(ctx, payload.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		app.unauthorized(w, r, err)
+		return
+	}
+
+	user, ok := app.oidcUser(w, r, provider.Name(), claims)
+	if !ok {
+		return
+	}
+
+	if !app.checkNotBanned(w, r, user.ID) {
+		return
+	}
+
+	if user.PasswordResetRequired {
+		app.passwordResetRequired(w, r, user)
+		return
+	}
+
+	// The provider only stands in for the password, so 2FA still applies.
+	if user.MFAEnabled {
+		app.issueMFAChallenge(w, r, user)
+		return
+	}
+
+	app.recordLoginAttempt(r, user.Email, true)
+	app.issueToken(w, r, user)
+}
+
+// setOIDCStateCookie stores the state of a login in a cookie only sent back
+// to the provider's endpoints. A negative maxAge clears it.
+func (app *application) setOIDCStateCookie(w http.ResponseWriter, provider, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/v1/authentication/oidc/" + provider,
+		MaxAge:   maxAge,
+		Secure:   app.config.env != "dev",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oidcUser finds the user an external identity belongs to. Identities seen
+// for the first time are linked to the active user with the same verified
+// email, or get a new activated user.
+func (app *application) oidcUser(w http.ResponseWriter, r *http.Request, provider string, claims *oidc.Claims) (*store.User, bool) {
+	ctx := r.Context()
+
+	userID, err := app.store.Identities.GetUserID(ctx, provider, claims.Subject)
+	switch {
+	case err == nil:
+		user, err := app.store.Users.GetByID(ctx, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNoRecord):
+				app.unauthorized(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return nil, false
+		}
+		return user, true
+	case !errors.Is(err, store.ErrNoRecord):
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		app.forbidden(w, r, errUnverifiedEmail)
+		return nil, false
+	}
+
+	identity := &store.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		identity.UserID = user.ID
+		if err := app.store.Identities.Link(ctx, identity); err != nil {
+			switch {
+			case errors.Is(err, store.ErrAlreadyExists):
+				app.conflict(w, r, fmt.Errorf("this account is already linked to another %s identity", provider))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return nil, false
+		}
+	case errors.Is(err, store.ErrNoRecord):
+		user, err = app.createOIDCUser(r, claims, identity)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrDuplicateEmail):
+				app.conflict(w, r, errors.New("an inactive account with this email already exists"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return nil, false
+		}
+	default:
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+
+	app.audit(r, &user.ID, auditIdentityLink, "user", user.ID, store.AuditChanges(nil, map[string]any{"provider": provider}))
+
+	// Linking by email skipped the fields only GetByID loads.
+	user, err = app.store.Users.GetByID(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// createOIDCUser creates an activated user for the identity. They have no
+// password of their own: the stored one is random and never given out, and
+// a recent sign-in through the provider stands in for it until they set one.
+func (app *application) createOIDCUser(r *http.Request, claims *oidc.Claims, identity *store.Identity) (*store.User, error) {
+	role, err := app.store.Roles.GetByName(r.Context(), "user")
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	base := oidcUsername(claims)
+
+	for attempt := 0; ; attempt++ {
+		username := base
+		if attempt > 0 {
+			suffix := make([]byte, 3)
+			if _, err := rand.Read(suffix); err != nil {
+				return nil, err
+			}
+			username = base + "_" + hex.EncodeToString(suffix)
+		}
+
+		user := &store.User{
+			Username: username,
+			Email:    claims.Email,
+			RoleID:   role.ID,
+		}
+		if err := user.Password.Set(password); err != nil {
+			return nil, err
+		}
+
+		err := app.store.Users.CreateWithIdentity(r.Context(), user, identity)
+		if errors.Is(err, store.ErrDuplicateUsername) && attempt+1 < usernameAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+}
+
+// oidcUsername derives a username from the provider's claims, leaving room
+// for the suffix added when it is taken.
+func oidcUsername(claims *oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	name = usernameUnsafe.ReplaceAllString(name, "")
+	if len(name) > 24 {
+		name = name[:24]
+	}
+	for len(name) < 3 {
+		name += "_"
+	}
+	return name
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/biboyqg/social/internal/auth"
+	"github.com/biboyqg/social/internal/oidc"
+	"github.com/biboyqg/social/internal/oidc/oidctest"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// The fakes embed the real stores for their method sets and override what
+// the OIDC login uses. Anything else panics on the missing database.
+
+type fakeUsers struct {
+	*store.UserStore
+	identities *fakeIdentities
+
+	mu    sync.Mutex
+	users map[int64]*store.User
+}
+
+func (s *fakeUsers) add(user *store.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.ID = int64(len(s.users) + 1)
+	user.IsActive = true
+	s.users[user.ID] = user
+}
+
+func (s *fakeUsers) GetByID(ctx context.Context, id int64) (*store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, store.ErrNoRecord
+	}
+	u := *user
+	return &u, nil
+}
+
+func (s *fakeUsers) GetByEmail(ctx context.Context, email string) (*store.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			u := *user
+			return &u, nil
+		}
+	}
+	return nil, store.ErrNoRecord
+}
+
+func (s *fakeUsers) CreateWithIdentity(ctx context.Context, user *store.User, identity *store.Identity) error {
+	user.HasPassword = false
+	s.add(user)
+
+	identity.UserID = user.ID
+	return s.identities.Link(ctx, identity)
+}
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+type fakeIdentities struct {
+	*store.IdentityStore
+
+	mu     sync.Mutex
+	states map[string]*store.OIDCState
+	links  map[identityKey]int64
+}
+
+func (s *fakeIdentities) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userID, ok := s.links[identityKey{provider, subject}]
+	if !ok {
+		return 0, store.ErrNoRecord
+	}
+	return userID, nil
+}
+
+func (s *fakeIdentities) Link(ctx context.Context, identity *store.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, userID := range s.links {
+		if key.provider == identity.Provider && userID == identity.UserID {
+			return store.ErrAlreadyExists
+		}
+	}
+	s.links[identityKey{identity.Provider, identity.Subject}] = identity.UserID
+	return nil
+}
+
+func (s *fakeIdentities) CreateState(ctx context.Context, state *store.OIDCState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[state.State] = state
+	return nil
+}
+
+func (s *fakeIdentities) ConsumeState(ctx context.Context, provider, state string) (*store.OIDCState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.states[state]
+	if !ok || st.Provider != provider || !st.ExpiresAt.After(time.Now()) {
+		return nil, store.ErrNoRecord
+	}
+	delete(s.states, state)
+	return st, nil
+}
+
+type fakeRoles struct{ *store.RoleStore }
+
+func (fakeRoles) GetByName(ctx context.Context, name string) (*store.Role, error) {
+	return &store.Role{ID: 1, Name: name}, nil
+}
+
+type fakeBans struct{ *store.BanStore }
+
+func (fakeBans) GetActive(ctx context.Context, userID int64) (*store.Ban, error) {
+	return nil, store.ErrNoRecord
+}
+
+type fakeSessions struct {
+	*store.SessionStore
+
+	mu     sync.Mutex
+	nextID int64
+}
+
+func (s *fakeSessions) Create(ctx context.Context, session *store.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	session.ID = s.nextID
+	return nil
+}
+
+type fakeAudit struct {
+	*store.AuditStore
+
+	mu     sync.Mutex
+	events []store.AuditEvent
+}
+
+func (s *fakeAudit) Create(ctx context.Context, event *store.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *fakeAudit) actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	actions := make([]string, len(s.events))
+	for i, event := range s.events {
+		actions[i] = event.Action
+	}
+	return actions
+}
+
+type fakeLoginAttempts struct{ *store.LoginAttemptStore }
+
+func (fakeLoginAttempts) Create(ctx context.Context, attempt *store.LoginAttempt) error {
+	return nil
+}
+
+type oidcTest struct {
+	provider   *oidctest.Server
+	api        *httptest.Server
+	users      *fakeUsers
+	identities *fakeIdentities
+	audit      *fakeAudit
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+
+	provider := oidctest.NewServer(t)
+
+	identities := &fakeIdentities{
+		states: make(map[string]*store.OIDCState),
+		links:  make(map[identityKey]int64),
+	}
+	users := &fakeUsers{identities: identities, users: make(map[int64]*store.User)}
+	audit := &fakeAudit{}
+
+	app := &application{
+		config: config{
+			env: "dev",
+			auth: authConfig{
+				token: tokenConfig{secret: "secret", aud: "test", iss: "test", exp: time.Hour},
+				oidc:  oidcConfig{stateExp: time.Minute},
+			},
+		},
+		store: store.Storage{
+			Users:         users,
+			Identities:    identities,
+			Roles:         fakeRoles{},
+			Bans:          fakeBans{},
+			Sessions:      &fakeSessions{},
+			Audit:         audit,
+			LoginAttempts: fakeLoginAttempts{},
+		},
+		logger:        zap.NewNop().Sugar(),
+		authenticator: auth.NewJWTAuthenticator("secret", "test", "test"),
+		oidcProviders: map[string]*oidc.Provider{
+			"test": oidc.NewProvider("test", provider.Config()),
+		},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/v1/authentication/oidc/{provider}", app.startOIDCHandler)
+	r.Post("/v1/authentication/oidc/{provider}/callback", app.oidcCallbackHandler)
+
+	api := httptest.NewServer(r)
+	t.Cleanup(api.Close)
+
+	return &oidcTest{
+		provider:   provider,
+		api:        api,
+		users:      users,
+		identities: identities,
+		audit:      audit,
+	}
+}
+
+// client returns an HTTP client that keeps cookies, like a browser.
+func (tt *oidcTest) client(t *testing.T) *http.Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// start asks the API for the authorization URL and signs in at the provider,
+// returning the payload for the callback.
+func (tt *oidcTest) start(t *testing.T, client *http.Client, grant oidctest.Grant) OIDCCallbackPayload {
+	t.Helper()
+
+	res, err := client.Get(tt.api.URL + "/v1/authentication/oidc/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("start: status %d", res.StatusCode)
+	}
+
+	var envelope struct {
+		Data oidcAuthorization `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	code, state, err := tt.provider.Authorize(envelope.Data.AuthorizationURL, grant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return OIDCCallbackPayload{Code: code, State: state}
+}
+
+// callback completes the login and returns the status and, on success, the
+// token.
+func (tt *oidcTest) callback(t *testing.T, client *http.Client, payload OIDCCallbackPayload) (int, string) {
+	t.Helper()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Post(tt.api.URL+"/v1/authentication/oidc/test/callback", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var envelope struct {
+		Data map[string]string `json:"data"`
+	}
+	if res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(&envelope); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode, envelope.Data["token"]
+}
+
+var testIdentity = oidctest.Identity{
+	Subject:           "subject-1",
+	Email:             "alice@example.com",
+	EmailVerified:     true,
+	PreferredUsername: "alice",
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	tt := newOIDCTest(t)
+	client := tt.client(t)
+
+	status, token := tt.callback(t, client, tt.start(t, client, oidctest.Grant{Identity: testIdentity}))
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("callback: status %d, token %q", status, token)
+	}
+
+	user, err := tt.users.GetByEmail(context.Background(), testIdentity.Email)
+	if err != nil {
+		t.Fatalf("user was not created: %v", err)
+	}
+	if user.Username != "alice" {
+		t.Errorf("username = %q, want %q", user.Username, "alice")
+	}
+	if user.HasPassword {
+		t.Error("user created through the provider has a password")
+	}
+
+	// Signing in again finds the same user through the identity.
+	status, _ = tt.callback(t, client, tt.start(t, client, oidctest.Grant{Identity: testIdentity}))
+	if status != http.StatusOK {
+		t.Fatalf("second callback: status %d", status)
+	}
+	if n := len(tt.users.users); n != 1 {
+		t.Errorf("%d users, want 1", n)
+	}
+}
+
+func TestOIDCLoginLinksExistingUser(t *testing.T) {
+	tt := newOIDCTest(t)
+	client := tt.client(t)
+
+	existing := &store.User{Username: "alice_local", Email: testIdentity.Email, HasPassword: true}
+	tt.users.add(existing)
+
+	status, _ := tt.callback(t, client, tt.start(t, client, oidctest.Grant{Identity: testIdentity}))
+	if status != http.StatusOK {
+		t.Fatalf("callback: status %d", status)
+	}
+
+	userID, err := tt.identities.GetUserID(context.Background(), "test", testIdentity.Subject)
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	if userID != existing.ID {
+		t.Errorf("identity linked to user %d, want %d", userID, existing.ID)
+	}
+	if n := len(tt.users.users); n != 1 {
+		t.Errorf("%d users, want 1", n)
+	}
+
+	linked := false
+	for _, action := range tt.audit.actions() {
+		linked = linked || action == auditIdentityLink
+	}
+	if !linked {
+		t.Errorf("audit actions = %v, want %q", tt.audit.actions(), auditIdentityLink)
+	}
+}
+
+func TestOIDCLoginRejectsUnverifiedEmail(t *testing.T) {
+	tt := newOIDCTest(t)
+	client := tt.client(t)
+
+	tt.users.add(&store.User{Username: "alice_local", Email: testIdentity.Email, HasPassword: true})
+
+	identity := testIdentity
+	identity.EmailVerified = false
+
+	status, _ := tt.callback(t, client, tt.start(t, client, oidctest.Grant{Identity: identity}))
+	if status != http.StatusForbidden {
+		t.Fatalf("callback: status %d, want %d", status, http.StatusForbidden)
+	}
+	if _, err := tt.identities.GetUserID(context.Background(), "test", identity.Subject); err == nil {
+		t.Error("unverified identity was linked")
+	}
+}
+
+func TestOIDCCallbackRejectsExpiredState(t *testing.T) {
+	tt := newOIDCTest(t)
+	client := tt.client(t)
+
+	payload := tt.start(t, client, oidctest.Grant{Identity: testIdentity})
+
+	tt.identities.mu.Lock()
+	tt.identities.states[payload.State].ExpiresAt = time.Now().Add(-time.Second)
+	tt.identities.mu.Unlock()
+
+	if status, _ := tt.callback(t, client, payload); status != http.StatusBadRequest {
+		t.Fatalf("callback: status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCCallbackRejectsBadNonce(t *testing.T) {
+	tt := newOIDCTest(t)
+	client := tt.client(t)
+
+	status, _ := tt.callback(t, client, tt.start(t, client, oidctest.Grant{Identity: testIdentity, Nonce: "replayed"}))
+	if status != http.StatusUnauthorized {
+		t.Fatalf("callback: status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	tt := newOIDCTest(t)
+
+	payload := tt.start(t, tt.client(t), oidctest.Grant{Identity: testIdentity})
+
+	// Another browser, such as a victim's, cannot complete the login.
+	if status, _ := tt.callback(t, tt.client(t), payload); status != http.StatusBadRequest {
+		t.Fatalf("callback: status %d, want %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/biboyqg/social/internal/store"
+)
+
+// maxPollDuration bounds how long a poll stays open once its post is
+// published.
+const maxPollDuration = 30 * 24 * time.Hour
+
+type createPollPayload struct {
+	Options  []string  `json:"options" validate:"required,min=2,max=4,unique,dive,required,max=100"`
+	Multiple bool      `json:"multiple"`
+	ClosesAt time.Time `json:"closes_at" validate:"required"`
+}
+
+type votePollPayload struct {
+	OptionIDs []int64 `json:"option_ids" validate:"required,min=1,unique,dive,min=1"`
+}
+
+// newPoll turns the poll of a post payload into the form the post store
+// expects. The poll must close after the post is published.
+func newPoll(payload *createPollPayload, publishAt *time.Time) (*store.Poll, error) {
+	opensAt := time.Now()
+	if publishAt != nil {
+		opensAt = *publishAt
+	}
+
+	if !payload.ClosesAt.After(opensAt) {
+		return nil, errors.New("poll closes_at must be after the post is published")
+	}
+	if payload.ClosesAt.Sub(opensAt) > maxPollDuration {
+		return nil, fmt.Errorf("a poll can stay open for at most %s", maxPollDuration)
+	}
+
+	poll := &store.Poll{
+		Multiple: payload.Multiple,
+		ClosesAt: payload.ClosesAt.UTC().Format(time.RFC3339),
+		Options:  make([]store.PollOption, len(payload.Options)),
+	}
+	for i, text := range payload.Options {
+		poll.Options[i] = store.PollOption{Text: text}
+	}
+	return poll, nil
+}
+
+//	@Summary		Vote in Poll
+//	@Description	Vote for one option of the poll of a post, or several if it allows multiple choices. Voting again for the same options changes nothing; votes cannot be changed. Returns the poll with its results.
+//	@Tags			Posts
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path		int				true	"Post ID"
+//	@Param			payload	body		votePollPayload	true	"Options"
+//	@Success		200		{object}	store.Poll
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/poll/vote [put]
+func (app *application) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var payload votePollPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if post.PublishedAt == nil {
+		app.badRequest(w, r, errors.New("poll is not open yet"))
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Polls.Vote(ctx, post.ID, user.ID, payload.OptionIDs); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrPollClosed), errors.Is(err, store.ErrInvalidVote):
+			app.badRequest(w, r, err)
+		case errors.Is(err, store.ErrAlreadyVoted):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.loadPolls(ctx, user.ID, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, post.Poll); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// loadPolls fills in the polls of the given posts as userID sees them.
+func (app *application) loadPolls(ctx context.Context, userID int64, posts ...*store.Post) error {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	polls, err := app.store.Polls.GetByPostIDs(ctx, userID, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.Poll = polls[post.ID]
+	}
+	return nil
+}
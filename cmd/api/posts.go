@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"strconv"
 	"context"
+	"time"
 
+	"github.com/biboyqg/social/internal/policy"
 	"github.com/biboyqg/social/internal/store"
 	"github.com/go-chi/chi/v5"
 )
@@ -14,25 +16,32 @@ type postCtxKey string
 const postContextKey postCtxKey = "post"
 
 type createPostPayload struct {
-	Title   string   `json:"title" validate:"required,max=100"`
-	Content string   `json:"content" validate:"required,max=1000"`
-	Tags    []string `json:"tags"`
+	Title         string     `json:"title" validate:"required,max=100"`
+	Content       string     `json:"content" validate:"required,max=1000"`
+	Tags          []string   `json:"tags"`
+	PublishAt     *time.Time `json:"publish_at"`
+	AttachmentIDs []int64    `json:"attachment_ids" validate:"omitempty,unique,dive,min=1"`
+	QuotePostID   *int64             `json:"quote_post_id" validate:"omitempty,min=1"`
+	Poll          *createPollPayload `json:"poll" validate:"omitempty"`
+	CommunityID   *int64             `json:"community_id" validate:"omitempty,min=1"`
 }
 
 type updatePostPayload struct {
-	Title   *string   `json:"title" validate:"omitempty,max=100"`
-	Content *string   `json:"content" validate:"omitempty,max=1000"`
-	Tags    []string  `json:"tags"`
+	Title         *string  `json:"title" validate:"omitempty,max=100"`
+	Content       *string  `json:"content" validate:"omitempty,max=1000"`
+	Tags          []string `json:"tags"`
+	AttachmentIDs []int64  `json:"attachment_ids" validate:"omitempty,unique,dive,min=1"`
 }
 
 //	@Summary		Create Post
-//	@Description	Create a new post
+//	@Description	Create a new post. If publish_at is set, the post stays hidden until that time. attachment_ids lists uploads to attach. quote_post_id quotes another published post. poll adds a poll of 2 to 4 options; its results show once you voted or it closed. community_id posts in a community you are a member of. Previews of links in the content are fetched in the background and show up in link_previews once ready.
 //	@Tags			Posts
 //	@Accept			json
 //	@Produce		json
 //	@Param			post	body		createPostPayload	true	"Post"
 //	@Success		201		{object}	store.Post
 //	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Security		ApiKeyAuth
 //	@Router			/posts [post]
@@ -49,22 +58,94 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if payload.PublishAt != nil && !payload.PublishAt.After(time.Now()) {
+		app.badRequest(w, r, errors.New("publish_at must be in the future"))
+		return
+	}
+
 	user, err := app.getUserFromCtx(r)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
+	attachments, err := app.attachmentRefs(payload.AttachmentIDs)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
 	post := store.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		Tags:    payload.Tags,
-		UserID:  user.ID,
+		Title:       payload.Title,
+		Content:     payload.Content,
+		Tags:        payload.Tags,
+		UserID:      user.ID,
+		Attachments: attachments,
+		QuoteOfID:   payload.QuotePostID,
+		CommunityID: payload.CommunityID,
+	}
+
+	if payload.PublishAt != nil {
+		publishAt := payload.PublishAt.UTC().Format(time.RFC3339)
+		post.PublishAt = &publishAt
+	}
+
+	if payload.Poll != nil {
+		post.Poll, err = newPoll(payload.Poll, payload.PublishAt)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
 	}
 
 	ctx := r.Context()
 
+	if post.QuoteOfID != nil {
+		if err := app.checkQuotable(ctx, *post.QuoteOfID); err != nil {
+			switch {
+			case errors.Is(err, errNotQuotable):
+				app.badRequest(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+	}
+
+	if post.CommunityID != nil {
+		if err := app.checkCommunityMember(ctx, *post.CommunityID, user.ID); err != nil {
+			switch {
+			case errors.Is(err, errNotCommunityMember):
+				app.forbidden(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+	}
+
 	if err := app.store.Posts.Create(ctx, &post); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAttachmentUnavailable):
+			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.linkPost(ctx, &post)
+	if err := app.loadLinkPreviews(ctx, &post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadQuotedPosts(ctx, &post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadPolls(ctx, user.ID, &post); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -79,11 +160,14 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 //	@Tags			Posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			postID	path		int	true	"Post ID"
-//	@Success		200		{object}	store.Post
-//	@Failure		400		{object}	map[string]string
-//	@Failure		404		{object}	map[string]string
-//	@Failure		500		{object}	map[string]string
+//	@Param			postID			path		int		true	"Post ID"
+//	@Param			If-None-Match	header		string	false	"ETag from a previous response"
+//	@Success		200				{object}	store.Post
+//	@Header			200				{string}	ETag	"Tag of the response, starting with the post version If-Match checks"
+//	@Success		304				{string}	string	"Not Modified"
+//	@Failure		400				{object}	map[string]string
+//	@Failure		404				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{postID} [get]
 func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
@@ -103,22 +187,72 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	post.Comments = comments
 
+	if err := app.loadAttachments(ctx, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadLinkPreviews(ctx, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadQuotedPosts(ctx, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadBookmarked(ctx, user.ID, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadPolls(ctx, user.ID, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// The tag covers the whole response, so it can only be computed once
+	// everything is loaded.
+	etag, err := postETag(post)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, &post); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
 
 //	@Summary		Update Post
-//	@Description	Update the post by post ID
+//	@Description	Update the post by post ID. attachment_ids, when set, replaces the attachments of the post; those left out are deleted.
 //	@Tags			Posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			postID	path		int					true	"Post ID"
-//	@Param			post	body		updatePostPayload	true	"Post"
-//	@Success		200		{object}	store.Post
-//	@Failure		400		{object}	map[string]string
-//	@Failure		404		{object}	map[string]string
-//	@Failure		500		{object}	map[string]string
+//	@Param			postID		path		int					true	"Post ID"
+//	@Param			If-Match	header		string				true	"ETag of the version being edited"
+//	@Param			post		body		updatePostPayload	true	"Post"
+//	@Success		200			{object}	store.Post
+//	@Header			200			{string}	ETag	"New post version"
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		412			{object}	map[string]string
+//	@Failure		428			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{postID} [patch]
 func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
@@ -142,6 +276,9 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	before := auditPostFields(post)
+	contentChanged := payload.Content != nil && *payload.Content != post.Content
+
 	if payload.Title != nil {
 		post.Title = *payload.Title
 	}
@@ -151,17 +288,65 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 	if payload.Tags != nil {
 		post.Tags = payload.Tags
 	}
+	if payload.AttachmentIDs != nil {
+		post.Attachments, err = app.attachmentRefs(payload.AttachmentIDs)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+	}
 
-	if err := app.store.Posts.Update(ctx, post); err != nil {
+	editor, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Posts.Update(ctx, post, editor.ID); err != nil {
 		switch {
 		case errors.Is(err, store.ErrNoRecord):
 			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrEditConflict):
+			app.conflict(w, r, err)
+		case errors.Is(err, store.ErrAttachmentUnavailable):
+			app.badRequest(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
 		}
 		return
 	}
 
+	if post.Attachments == nil {
+		if err := app.loadAttachments(ctx, post); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if contentChanged {
+		app.linkPost(ctx, post)
+	}
+	if err := app.loadLinkPreviews(ctx, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.loadQuotedPosts(ctx, post); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if editor.ID != post.UserID {
+		app.audit(r, &editor.ID, auditPostUpdate, "post", post.ID, store.AuditChanges(before, auditPostFields(post)))
+	}
+
+	etag, err := postETag(post)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
 	if err := app.jsonResponse(w, http.StatusOK, &post); err != nil {
 		app.internalServerError(w, r, err)
 	}
@@ -172,14 +357,59 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 //	@Tags			Posts
 //	@Accept			json
 //	@Produce		json
+//	@Param			postID		path		int		true	"Post ID"
+//	@Param			If-Match	header		string	true	"ETag of the version being deleted"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		412			{object}	map[string]string
+//	@Failure		428			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID} [delete]
+func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Posts.Delete(ctx, post.ID, post.Version); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		case errors.Is(err, store.ErrEditConflict):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.auditPostOverride(r, post, auditPostDelete)
+
+	if err := app.jsonResponse(w, http.StatusOK, map[string]string{"message": "post deleted"}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Restore Post
+//	@Description	Restore a post from the trash. Only the author or an admin can restore it.
+//	@Tags			Posts
+//	@Accept			json
+//	@Produce		json
 //	@Param			postID	path		int	true	"Post ID"
-//	@Success		200		{object}	map[string]string
+//	@Success		200		{object}	store.Post
 //	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
 //	@Failure		404		{object}	map[string]string
 //	@Failure		500		{object}	map[string]string
 //	@Security		ApiKeyAuth
-//	@Router			/posts/{postID} [delete]
-func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
+//	@Router			/posts/{postID}/restore [put]
+func (app *application) restorePostHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	post, err := app.getPostFromCtx(r)
@@ -188,7 +418,7 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := app.store.Posts.Delete(ctx, post.ID); err != nil {
+	if err := app.store.Posts.Restore(ctx, post.ID); err != nil {
 		switch {
 		case errors.Is(err, store.ErrNoRecord):
 			app.notFound(w, r, err)
@@ -198,7 +428,11 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, map[string]string{"message": "post deleted"}); err != nil {
+	app.auditPostOverride(r, post, auditPostRestore)
+
+	post.DeletedAt = nil
+
+	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
@@ -224,6 +458,67 @@ func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		visible, err := app.canViewPost(r, post)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if !visible {
+			app.notFound(w, r, store.ErrNoRecord)
+			return
+		}
+
+		ctx = context.WithValue(ctx, postContextKey, post)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// canViewPost hides scheduled posts from everyone but their author, and
+// posts hidden by moderation from everyone but their author and moderators.
+func (app *application) canViewPost(r *http.Request, post *store.Post) (bool, error) {
+	if post.PublishedAt != nil && post.HiddenAt == nil {
+		return true, nil
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		return false, err
+	}
+
+	if user.ID == post.UserID {
+		return true, nil
+	}
+
+	if post.PublishedAt == nil {
+		return false, nil
+	}
+
+	return app.can(r.Context(), user, policy.PostViewHidden)
+}
+
+// trashedPostsContextMiddleware loads a post from the trash so restore
+// handlers can reuse the ownership checks written for live posts.
+func (app *application) trashedPostsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		postID, err := strconv.ParseInt(chi.URLParam(r, "postID"), 10, 64)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+
+		post, err := app.store.Posts.GetDeletedByID(ctx, postID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNoRecord):
+				app.notFound(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
 		ctx = context.WithValue(ctx, postContextKey, post)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -237,3 +532,22 @@ func (app *application) getPostFromCtx(r *http.Request) (*store.Post, error) {
 	}
 	return post, nil
 }
+
+// auditPostOverride records actions that someone other than the author took
+// on a post through the checkPostOwnership permission override.
+func (app *application) auditPostOverride(r *http.Request, post *store.Post, action string) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil || user.ID == post.UserID {
+		return
+	}
+
+	app.audit(r, &user.ID, action, "post", post.ID, nil)
+}
+
+func auditPostFields(post *store.Post) map[string]any {
+	return map[string]any{
+		"title":   post.Title,
+		"content": post.Content,
+		"tags":    append([]string(nil), post.Tags...),
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/biboyqg/social/internal/unfurl"
+)
+
+// linkPreviewRetention is how long an expired preview no post links to is
+// kept around before it is purged.
+const linkPreviewRetention = 7 * 24 * time.Hour
+
+// linkPost records the links in the content of a post and queues the fetch
+// of their previews. The post is saved already, so a failure here only costs
+// the previews and is logged rather than returned.
+func (app *application) linkPost(ctx context.Context, post *store.Post) {
+	urls := unfurl.ExtractURLs(post.Content, app.config.unfurl.maxLinks)
+	if err := app.store.LinkPreviews.SetPostLinks(ctx, post.ID, urls); err != nil {
+		app.logger.Errorw("failed to record post links", "post_id", post.ID, "error", err)
+	}
+}
+
+// loadLinkPreviews fills in the previews of the links in the given posts.
+func (app *application) loadLinkPreviews(ctx context.Context, posts ...*store.Post) error {
+	ids := make([]int64, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+
+	previews, err := app.store.LinkPreviews.GetByPostIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.LinkPreviews = previews[post.ID]
+	}
+	return nil
+}
+
+// unfurlLinks fetches the previews of links found in posts.
+func (app *application) unfurlLinks(ctx context.Context) error {
+	return app.runJobs(ctx, store.JobUnfurlLink, app.unfurlLinkJob, app.failLinkJob)
+}
+
+func (app *application) unfurlLinkJob(ctx context.Context, job *store.Job) error {
+	var payload store.LinkJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanent(err)
+	}
+
+	// Popular links get queued by many posts before the first fetch lands.
+	fresh, err := app.store.LinkPreviews.IsFresh(ctx, payload.URL)
+	if err != nil {
+		return err
+	}
+	if fresh {
+		return nil
+	}
+
+	preview, err := app.unfurler.Fetch(ctx, payload.URL)
+	if err != nil {
+		switch {
+		case errors.Is(err, unfurl.ErrBlocked),
+			errors.Is(err, unfurl.ErrNotHTML),
+			errors.Is(err, unfurl.ErrNoMetadata),
+			errors.Is(err, unfurl.ErrUnavailable):
+			return app.store.LinkPreviews.SaveFailure(ctx, payload.URL, err.Error(), time.Now().Add(app.config.unfurl.failureTTL))
+		default:
+			return err
+		}
+	}
+
+	return app.store.LinkPreviews.Save(ctx, &store.LinkPreview{
+		URL:         preview.URL,
+		Title:       preview.Title,
+		Description: preview.Description,
+		ImageURL:    preview.ImageURL,
+		SiteName:    preview.SiteName,
+	}, time.Now().Add(app.linkPreviewTTL(payload.URL)))
+}
+
+// failLinkJob caches the failure of a link that could not be fetched, so
+// that it is not queued again until the failure expires.
+func (app *application) failLinkJob(ctx context.Context, job *store.Job) error {
+	var payload store.LinkJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil
+	}
+
+	reason := fmt.Sprintf("gave up after %d attempts", job.Attempts)
+	return app.store.LinkPreviews.SaveFailure(ctx, payload.URL, reason, time.Now().Add(app.config.unfurl.failureTTL))
+}
+
+// linkPreviewTTL returns how long the preview of rawURL is cached. A TTL set
+// for a domain also applies to its subdomains, unless they have their own.
+func (app *application) linkPreviewTTL(rawURL string) time.Duration {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return app.config.unfurl.cacheTTL
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for host != "" {
+		if ttl, ok := app.config.unfurl.domainTTLs[host]; ok {
+			return ttl
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return app.config.unfurl.cacheTTL
+}
+
+// purgeLinkPreviews drops expired previews of links no post has anymore.
+func (app *application) purgeLinkPreviews(ctx context.Context) error {
+	n, err := app.store.LinkPreviews.PurgeUnused(ctx, time.Now().Add(-linkPreviewRetention))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Infow("purged link previews", "count", n)
+	}
+	return nil
+}
+
+// parseDomainTTLs reads cache TTLs per domain written as
+// "example.com=1h,news.example.org=10m".
+func parseDomainTTLs(s string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		domain, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid domain TTL %q", entry)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid domain TTL %q", entry)
+		}
+		ttls[strings.ToLower(strings.TrimSpace(domain))] = ttl
+	}
+	return ttls, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/biboyqg/social/internal/store"
+)
+
+const (
+	// jobStaleAfter is how long a job can stay running before another
+	// worker takes it over.
+	jobStaleAfter = 10 * time.Minute
+
+	jobRetryDelay    = 30 * time.Second
+	jobMaxRetryDelay = time.Hour
+)
+
+// permanentError marks a job failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// runJobs works through every due job of a kind. Failed jobs are retried
+// with exponential backoff unless the error is permanent; giveUp, if set,
+// runs once a job will not be retried again, including jobs whose worker
+// died during their last attempt.
+func (app *application) runJobs(
+	ctx context.Context,
+	kind string,
+	handle func(ctx context.Context, job *store.Job) error,
+	giveUp func(ctx context.Context, job *store.Job) error,
+) error {
+	stale, err := app.store.Jobs.FailStale(ctx, kind, jobStaleAfter)
+	if err != nil {
+		return err
+	}
+	for i := range stale {
+		job := &stale[i]
+		app.logger.Errorw("job gave up", "kind", kind, "job_id", job.ID, "attempts", job.Attempts, "error", job.LastError)
+
+		if giveUp != nil {
+			if err := giveUp(ctx, job); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		job, err := app.store.Jobs.ClaimNext(ctx, kind, jobStaleAfter)
+		if err != nil {
+			if errors.Is(err, store.ErrNoRecord) {
+				return nil
+			}
+			return err
+		}
+
+		jobErr := handle(ctx, job)
+		if jobErr == nil {
+			if err := app.store.Jobs.Complete(ctx, job.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		app.logger.Warnw("job failed", "kind", kind, "job_id", job.ID, "attempt", job.Attempts, "error", jobErr)
+
+		status := store.JobFailed
+		var perm permanentError
+		if errors.As(jobErr, &perm) {
+			err = app.store.Jobs.Fail(ctx, job.ID, jobErr.Error())
+		} else {
+			status, err = app.store.Jobs.Retry(ctx, job.ID, jobErr.Error(), jobBackoff(job.Attempts))
+		}
+		if err != nil {
+			return err
+		}
+
+		if status == store.JobFailed {
+			app.logger.Errorw("job gave up", "kind", kind, "job_id", job.ID, "attempts", job.Attempts, "error", jobErr)
+
+			if giveUp != nil {
+				if err := giveUp(ctx, job); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// jobBackoff doubles the delay before each retry, up to jobMaxRetryDelay.
+func jobBackoff(attempt int) time.Duration {
+	delay := jobRetryDelay
+	for i := 1; i < attempt && delay < jobMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, jobMaxRetryDelay)
+}
+
+// purgeJobs drops jobs that finished more than a week ago.
+func (app *application) purgeJobs(ctx context.Context) error {
+	n, err := app.store.Jobs.PurgeFinished(ctx, time.Now().Add(-7*24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.logger.Infow("purged finished jobs", "count", n)
+	}
+	return nil
+}
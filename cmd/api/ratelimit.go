@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/biboyqg/social/internal/ratelimiter"
+)
+
+// Rate limit keys.
+const (
+	rateLimitByIP   = "ip"
+	rateLimitByUser = "user"
+)
+
+// RateLimitMiddleware applies the named rate limit from the config. Requests
+// pass through untouched when rate limiting is disabled or the route has no
+// limiter. If the backend fails the request is let through: an outage of the
+// limiter should not take the API down with it.
+func (app *application) RateLimitMiddleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limiter, ok := app.rateLimiters[name]
+		if !ok {
+			return next
+		}
+		keyBy := app.config.rateLimit.routes[name].keyBy
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := limiter.Allow(r.Context(), app.rateLimitKey(r, keyBy))
+			if err != nil {
+				app.logger.Errorw("rate limiter failed", "error", err, "limiter", name)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+			if !res.Allowed {
+				app.rateLimitExceeded(w, r, ceilSeconds(res.RetryAfter))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies the client. Keying by user falls back to the IP on
+// routes where the request is not authenticated.
+func (app *application) rateLimitKey(r *http.Request, keyBy string) string {
+	if keyBy == rateLimitByUser {
+		if user, err := app.getUserFromCtx(r); err == nil {
+			return "user:" + strconv.FormatInt(user.ID, 10)
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func newRateLimiters(cfg rateLimitConfig, backend ratelimiter.Backend) (map[string]*ratelimiter.Limiter, error) {
+	limiters := make(map[string]*ratelimiter.Limiter)
+	if !cfg.enabled {
+		return limiters, nil
+	}
+
+	for name, rule := range cfg.routes {
+		limiter, err := ratelimiter.New(name, rule.policy, backend)
+		if err != nil {
+			return nil, err
+		}
+		limiters[name] = limiter
+	}
+	return limiters, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIPMiddleware(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{config: config{proxies: proxies}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:1234", "", "", "203.0.113.7:1234"},
+		{"untrusted peer sets headers", "203.0.113.7:1234", "198.51.100.1", "198.51.100.2", "203.0.113.7:1234"},
+		{"trusted proxy", "10.0.0.2:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed entry before the client", "10.0.0.2:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:1234", "198.51.100.1, 192.168.1.10, 10.1.1.1", "", "198.51.100.1"},
+		{"only trusted proxies", "10.0.0.2:1234", "10.0.0.3, 10.0.0.4", "", "10.0.0.3"},
+		{"X-Real-IP from a trusted proxy", "192.168.1.10:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"garbage header", "10.0.0.2:1234", "not-an-ip", "", "10.0.0.2:1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := app.RealIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if _, err := parseTrustedProxies(s); err == nil {
+			t.Errorf("parseTrustedProxies(%q) accepted it", s)
+		}
+	}
+}
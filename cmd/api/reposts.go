@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/biboyqg/social/internal/store"
+)
+
+var errNotQuotable = errors.New("quoted post does not exist or is not published")
+
+//	@Summary		Repost Post
+//	@Description	Share the post with your followers. It shows in their feeds, attributed to you.
+//	@Tags			Posts
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path	int	true	"Post ID"
+//	@Success		201
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/repost [put]
+func (app *application) repostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// The author of a scheduled post and moderators of a hidden one can
+	// see it, but it must not reach anyone else through a repost.
+	if post.PublishedAt == nil || post.HiddenAt != nil {
+		app.badRequest(w, r, errors.New("only published posts can be reposted"))
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Reposts.Create(ctx, user.ID, post.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrAlreadyExists):
+			app.conflict(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Undo Repost
+//	@Description	Stop sharing a post you reposted
+//	@Tags			Posts
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path	int	true	"Post ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/repost [delete]
+func (app *application) unrepostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Reposts.Delete(ctx, user.ID, post.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkQuotable makes sure a new post may quote the post with the given ID:
+// only posts anyone can see can be quoted.
+func (app *application) checkQuotable(ctx context.Context, id int64) error {
+	posts, err := app.store.Posts.GetPublishedByIDs(ctx, []int64{id})
+	if err != nil {
+		return err
+	}
+	if posts[id] == nil {
+		return errNotQuotable
+	}
+	return nil
+}
+
+// loadQuotedPosts fills in the posts the given posts quote, leaving out
+// those that are no longer visible.
+func (app *application) loadQuotedPosts(ctx context.Context, posts ...*store.Post) error {
+	var ids []int64
+	for _, post := range posts {
+		if post.QuoteOfID != nil {
+			ids = append(ids, *post.QuoteOfID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	quoted, err := app.store.Posts.GetPublishedByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		if post.QuoteOfID != nil {
+			post.Quoted = quoted[*post.QuoteOfID]
+		}
+	}
+	return nil
+}
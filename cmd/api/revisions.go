@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/biboyqg/social/internal/diff"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type postRevisionDiff struct {
+	PostID      int64       `json:"post_id"`
+	From        int         `json:"from"`
+	To          int         `json:"to"`
+	Title       []diff.Line `json:"title"`
+	Content     []diff.Line `json:"content"`
+	TagsAdded   []string    `json:"tags_added"`
+	TagsRemoved []string    `json:"tags_removed"`
+}
+
+//	@Summary		Get Post Revisions
+//	@Description	Get every stored revision of a post, newest first
+//	@Tags			Posts
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path		int	true	"Post ID"
+//	@Success		200		{array}		store.PostRevision
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/revisions [get]
+func (app *application) getPostRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	revisions, err := app.store.PostRevisions.GetByPostID(r.Context(), post.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, revisions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Get Post Revision
+//	@Description	Get a single revision of a post by version
+//	@Tags			Posts
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path		int	true	"Post ID"
+//	@Param			version	path		int	true	"Version"
+//	@Success		200		{object}	store.PostRevision
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/revisions/{version} [get]
+func (app *application) getPostRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	revision, err := app.store.PostRevisions.GetByVersion(r.Context(), post.ID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, revision); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Diff Post Revisions
+//	@Description	Get a line-based diff between two versions of a post
+//	@Tags			Posts
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path		int	true	"Post ID"
+//	@Param			from	query		int	true	"Base version"
+//	@Param			to		query		int	false	"Target version, defaults to the current one"
+//	@Success		200		{object}	postRevisionDiff
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/revisions/diff [get]
+func (app *application) getPostRevisionDiffHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	post, err := app.getPostFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	from, err := strconv.Atoi(qs.Get("from"))
+	if err != nil {
+		app.badRequest(w, r, errors.New("from must be a version number"))
+		return
+	}
+
+	to := post.Version
+	if v := qs.Get("to"); v != "" {
+		to, err = strconv.Atoi(v)
+		if err != nil {
+			app.badRequest(w, r, errors.New("to must be a version number"))
+			return
+		}
+	}
+
+	fromRev, err := app.store.PostRevisions.GetByVersion(ctx, post.ID, from)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	toRev, err := app.store.PostRevisions.GetByVersion(ctx, post.ID, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	tagsAdded, tagsRemoved := diff.Sets(fromRev.Tags, toRev.Tags)
+
+	res := postRevisionDiff{
+		PostID:      post.ID,
+		From:        from,
+		To:          to,
+		Title:       diff.Lines(fromRev.Title, toRev.Title),
+		Content:     diff.Lines(fromRev.Content, toRev.Content),
+		TagsAdded:   tagsAdded,
+		TagsRemoved: tagsRemoved,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, res); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+type sessionContextKey string
+
+const sessionCtxKey sessionContextKey = "session"
+
+//	@Summary		List Sessions
+//	@Description	List the devices the authenticated user is logged in on. The session making the request is marked as current.
+//	@Tags			Sessions
+//	@Produce		json
+//	@Success		200	{array}		store.Session
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions [get]
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sessions, err := app.store.Sessions.GetActiveByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if current, ok := getSessionFromCtx(r); ok {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current.ID
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, sessions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Revoke Session
+//	@Description	Log the authenticated user out of one of their sessions
+//	@Tags			Sessions
+//	@Param			sessionID	path	int	true	"Session ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions/{sessionID} [delete]
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sessionID, err := strconv.ParseInt(chi.URLParam(r, "sessionID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := app.store.Sessions.Revoke(r.Context(), user.ID, sessionID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditSessionRevoke, "session", sessionID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//	@Summary		Revoke Other Sessions
+//	@Description	Log the authenticated user out everywhere except the session making the request
+//	@Tags			Sessions
+//	@Produce		json
+//	@Success		200	{object}	map[string]int64
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions [delete]
+func (app *application) revokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	n, err := app.revokeOtherSessions(r, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, map[string]int64{"revoked": n}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// revokeOtherSessions ends every session of the user but the one making the
+// request.
+func (app *application) revokeOtherSessions(r *http.Request, user *store.User) (int64, error) {
+	var keepID int64
+	if current, ok := getSessionFromCtx(r); ok {
+		keepID = current.ID
+	}
+
+	n, err := app.store.Sessions.RevokeOthers(r.Context(), user.ID, keepID)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0 {
+		app.audit(r, &user.ID, auditSessionRevokeOthers, "user", user.ID, store.AuditChanges(nil, map[string]any{"revoked": n}))
+	}
+	return n, nil
+}
+
+func getSessionFromCtx(r *http.Request) (*store.Session, bool) {
+	session, ok := r.Context().Value(sessionCtxKey).(*store.Session)
+	return session, ok
+}
+
+// deviceName gives a rough, human readable description of the device behind
+// a user agent, such as "Firefox on Windows".
+func deviceName(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	platform := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			platform = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	default:
+		return truncate(userAgent, 100)
+	}
+}
+
+// truncate cuts s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/biboyqg/social/internal/policy"
+	"github.com/biboyqg/social/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// accessTokenPrefix marks personal access tokens, so that they can be told
+	// apart from JWTs and spotted by secret scanners.
+	accessTokenPrefix    = "sp_"
+	accessTokenPrefixLen = 10
+)
+
+type accessTokenContextKey string
+
+const accessTokenCtxKey accessTokenContextKey = "access_token"
+
+type CreateAccessTokenPayload struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresInDays *int     `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+type createdAccessToken struct {
+	store.AccessToken
+	Token string `json:"token"`
+}
+
+//	@Summary		Create Access Token
+//	@Description	Create a personal access token for bots and integrations. The token is only shown in this response.
+//	@Tags			Access Tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateAccessTokenPayload	true	"Token name, scopes and expiry"
+//	@Success		201		{object}	createdAccessToken
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [post]
+func (app *application) createAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var payload CreateAccessTokenPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	for _, scope := range payload.Scopes {
+		if !policy.ValidScope(scope) {
+			app.badRequest(w, r, fmt.Errorf("unknown scope %q", scope))
+			return
+		}
+	}
+
+	plain, err := generateAccessToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	token := store.AccessToken{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Prefix: plain[:accessTokenPrefixLen],
+		Scopes: payload.Scopes,
+	}
+	if payload.ExpiresInDays != nil {
+		expiresAt := time.Now().AddDate(0, 0, *payload.ExpiresInDays).UTC().Truncate(time.Second)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := app.store.AccessTokens.Create(r.Context(), &token, hashAccessToken(plain)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.audit(r, &user.ID, auditAccessTokenCreate, "access_token", token.ID, store.AuditChanges(nil, map[string]any{
+		"name":   token.Name,
+		"scopes": token.Scopes,
+	}))
+
+	if err := app.jsonResponse(w, http.StatusCreated, createdAccessToken{AccessToken: token, Token: plain}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		List Access Tokens
+//	@Description	List the personal access tokens of the authenticated user
+//	@Tags			Access Tokens
+//	@Produce		json
+//	@Success		200	{array}		store.AccessToken
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [get]
+func (app *application) listAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	tokens, err := app.store.AccessTokens.GetByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+//	@Summary		Revoke Access Token
+//	@Description	Revoke one of the personal access tokens of the authenticated user
+//	@Tags			Access Tokens
+//	@Param			tokenID	path	int	true	"Token ID"
+//	@Success		204
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens/{tokenID} [delete]
+func (app *application) revokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.getUserFromCtx(r)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	if err := app.store.AccessTokens.Delete(r.Context(), user.ID, tokenID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRecord):
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &user.ID, auditAccessTokenRevoke, "access_token", tokenID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getAccessTokenFromCtx(r *http.Request) (*store.AccessToken, bool) {
+	token, ok := r.Context().Value(accessTokenCtxKey).(*store.AccessToken)
+	return token, ok
+}
+
+func generateAccessToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return accessTokenPrefix + hex.EncodeToString(b), nil
+}
+
+func hashAccessToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_posts_pending_publish;
+
+ALTER TABLE posts DROP COLUMN published_at;
+ALTER TABLE posts DROP COLUMN publish_at;
@@ -0,0 +1,6 @@
+ALTER TABLE posts ADD COLUMN publish_at TIMESTAMP(0) with time zone;
+ALTER TABLE posts ADD COLUMN published_at TIMESTAMP(0) with time zone;
+
+UPDATE posts SET published_at = created_at;
+
+CREATE INDEX idx_posts_pending_publish ON posts (publish_at) WHERE published_at IS NULL;
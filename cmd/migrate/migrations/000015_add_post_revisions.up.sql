@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    tags VARCHAR(100) [],
+    edited_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+
+    UNIQUE (post_id, version)
+);
+
+-- Existing posts only have their current content left; record it as the
+-- first known revision.
+INSERT INTO post_revisions (post_id, version, title, content, tags, edited_by, created_at)
+SELECT id, version, title, content, tags, user_id, updated_at FROM posts;
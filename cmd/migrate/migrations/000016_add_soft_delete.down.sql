@@ -0,0 +1,13 @@
+ALTER TABLE user_invitations DROP CONSTRAINT fk_user_invitations_user;
+
+ALTER TABLE comments DROP CONSTRAINT fk_comments_user;
+ALTER TABLE comments DROP CONSTRAINT fk_comments_post;
+
+ALTER TABLE posts DROP CONSTRAINT fk_user;
+ALTER TABLE posts ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id);
+
+DROP INDEX IF EXISTS idx_users_deleted_at;
+DROP INDEX IF EXISTS idx_posts_deleted_at;
+
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE posts DROP COLUMN deleted_at;
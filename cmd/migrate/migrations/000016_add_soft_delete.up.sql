@@ -0,0 +1,21 @@
+ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMP(0) with time zone;
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP(0) with time zone;
+
+CREATE INDEX idx_posts_deleted_at ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Purging a user must take their posts, comments and invitations with it.
+ALTER TABLE posts DROP CONSTRAINT fk_user;
+ALTER TABLE posts ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+DELETE FROM comments c
+WHERE NOT EXISTS (SELECT 1 FROM posts p WHERE p.id = c.post_id)
+   OR NOT EXISTS (SELECT 1 FROM users u WHERE u.id = c.user_id);
+
+ALTER TABLE comments ADD CONSTRAINT fk_comments_post FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE;
+ALTER TABLE comments ADD CONSTRAINT fk_comments_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+DELETE FROM user_invitations ui
+WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.id = ui.user_id);
+
+ALTER TABLE user_invitations ADD CONSTRAINT fk_user_invitations_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
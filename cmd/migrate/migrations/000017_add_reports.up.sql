@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS reports (
+    id BIGSERIAL PRIMARY KEY,
+    reporter_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    target_type VARCHAR(16) NOT NULL CHECK (target_type IN ('post', 'comment', 'user')),
+    target_id BIGINT NOT NULL,
+    reason VARCHAR(1000) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'in_review', 'resolved', 'dismissed')),
+    assignee_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    resolution VARCHAR(16) CHECK (resolution IN ('hide', 'delete', 'warn', 'ban', 'dismiss')),
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- A user can only have one pending report per target.
+CREATE UNIQUE INDEX idx_reports_pending_unique ON reports (reporter_id, target_type, target_id) WHERE status IN ('open', 'in_review');
+CREATE INDEX idx_reports_status ON reports (status, created_at);
+CREATE INDEX idx_reports_target ON reports (target_type, target_id);
+
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id BIGSERIAL PRIMARY KEY,
+    report_id BIGINT NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+    actor_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(16) NOT NULL,
+    note VARCHAR(1000) NOT NULL DEFAULT '',
+    from_status VARCHAR(16) NOT NULL,
+    to_status VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_moderation_actions_report_id ON moderation_actions (report_id);
+
+ALTER TABLE posts ADD COLUMN hidden_at TIMESTAMP(0) with time zone;
+ALTER TABLE comments ADD COLUMN hidden_at TIMESTAMP(0) with time zone;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS user_bans (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    banned_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    reason VARCHAR(1000) NOT NULL,
+    permanent BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP(0) with time zone,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    lifted_at TIMESTAMP(0) with time zone,
+    lifted_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+
+    CHECK (NOT permanent OR expires_at IS NULL)
+);
+
+CREATE INDEX idx_user_bans_user_id ON user_bans (user_id) WHERE lifted_at IS NULL;
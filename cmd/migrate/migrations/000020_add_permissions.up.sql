@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id BIGINT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('post:update:any', 'Edit posts written by other users'),
+    ('post:delete:any', 'Delete posts written by other users'),
+    ('post:restore:any', 'Restore posts other users deleted'),
+    ('post:view:hidden', 'See posts hidden by moderation'),
+    ('comment:delete:any', 'Hide or delete comments written by other users'),
+    ('report:moderate', 'Work the moderation queue and resolve reports'),
+    ('user:ban', 'Suspend users ranked below you'),
+    ('user:ban:permanent', 'Ban users permanently'),
+    ('user:delete:any', 'Delete and restore user accounts'),
+    ('user:manage', 'List, activate, deactivate and reset users'),
+    ('role:manage', 'Change user roles and role permissions');
+
+-- Reproduce the old level checks: moderators got everything gated on
+-- "moderator", admins everything.
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+JOIN permissions p ON p.name IN (
+    'post:update:any',
+    'post:view:hidden',
+    'comment:delete:any',
+    'report:moderate',
+    'user:ban'
+)
+WHERE r.name = 'moderator';
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r
+CROSS JOIN permissions p
+WHERE r.name = 'admin';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    -- Attempts are tracked by the email that was tried, registered or not,
+    -- so that lockouts look the same for unknown accounts.
+    email CITEXT NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    succeeded BOOLEAN NOT NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip, created_at);
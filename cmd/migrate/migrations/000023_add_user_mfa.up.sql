@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    -- AES-GCM encrypted TOTP secret.
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP(0) with time zone,
+    -- Last TOTP time step accepted, so that a code cannot be used twice.
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash bytea NOT NULL,
+    used_at TIMESTAMP(0) with time zone,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS oidc_states;
+
+DROP TABLE IF EXISTS user_identities;
+
+ALTER TABLE users DROP COLUMN IF EXISTS has_password;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    -- SHA-256 of the token; the token itself is only shown once.
+    token_hash bytea NOT NULL UNIQUE,
+    -- Start of the token, so that users can tell their tokens apart.
+    prefix VARCHAR(16) NOT NULL,
+    scopes VARCHAR(32)[] NOT NULL,
+    expires_at TIMESTAMP(0) with time zone,
+    last_used_at TIMESTAMP(0) with time zone,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens (user_id);
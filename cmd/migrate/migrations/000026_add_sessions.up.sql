@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device VARCHAR(100) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    -- Matches the expiry of the token issued for the session.
+    expires_at TIMESTAMP(0) with time zone NOT NULL,
+    revoked_at TIMESTAMP(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS data_exports;
+
+DROP TABLE IF EXISTS account_deletions;
+
+ALTER TABLE users DROP COLUMN IF EXISTS purge_after;
+
+DELETE FROM comments WHERE user_id IS NULL;
+ALTER TABLE comments DROP CONSTRAINT fk_comments_user;
+ALTER TABLE comments ADD CONSTRAINT fk_comments_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE comments ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,34 @@
+-- Comments a purged user left on other people's posts stay, without an author.
+ALTER TABLE comments ALTER COLUMN user_id DROP DEFAULT;
+ALTER TABLE comments ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE comments DROP CONSTRAINT fk_comments_user;
+ALTER TABLE comments ADD CONSTRAINT fk_comments_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL;
+
+-- Set when users delete their own account: they are purged after the grace
+-- period instead of the trash retention.
+ALTER TABLE users ADD COLUMN purge_after TIMESTAMP(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS account_deletions (
+    token bytea PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP(0) with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS data_exports (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'ready', 'failed')),
+    archive bytea,
+    size BIGINT NOT NULL DEFAULT 0,
+    error VARCHAR(1000) NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP(0) with time zone,
+    completed_at TIMESTAMP(0) with time zone,
+    expires_at TIMESTAMP(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports (user_id);
+CREATE INDEX IF NOT EXISTS idx_data_exports_status ON data_exports (status, created_at) WHERE status IN ('pending', 'running');
+
+-- One export in progress per user at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_in_progress ON data_exports (user_id) WHERE status IN ('pending', 'running');
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS attachments;
+
+DROP FUNCTION IF EXISTS attachments_queue_blob_deletion();
+
+DROP TABLE IF EXISTS blob_deletions;
@@ -0,0 +1,33 @@
+-- Files are uploaded first and attached to a post when it is created or
+-- edited. Uploads that never get attached are purged.
+CREATE TABLE IF NOT EXISTS attachments (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id BIGINT REFERENCES posts(id) ON DELETE CASCADE,
+    storage_key VARCHAR(255) NOT NULL UNIQUE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments (post_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_unattached ON attachments (created_at) WHERE post_id IS NULL;
+
+-- Blobs whose attachment row is gone, however it went (directly, or with its
+-- post or user), waiting for a job to remove them from blob storage.
+CREATE TABLE IF NOT EXISTS blob_deletions (
+    storage_key VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE FUNCTION attachments_queue_blob_deletion() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO blob_deletions (storage_key) VALUES (OLD.storage_key) ON CONFLICT DO NOTHING;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER attachments_delete_blob
+    AFTER DELETE ON attachments
+    FOR EACH ROW EXECUTE FUNCTION attachments_queue_blob_deletion();
@@ -0,0 +1,16 @@
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_id;
+
+DELETE FROM attachments WHERE purpose = 'avatar';
+
+DROP TABLE IF EXISTS attachment_variants;
+
+DROP INDEX IF EXISTS idx_attachments_unattached;
+CREATE INDEX IF NOT EXISTS idx_attachments_unattached ON attachments (created_at) WHERE post_id IS NULL;
+
+ALTER TABLE attachments DROP COLUMN IF EXISTS blurhash;
+ALTER TABLE attachments DROP COLUMN IF EXISTS height;
+ALTER TABLE attachments DROP COLUMN IF EXISTS width;
+ALTER TABLE attachments DROP COLUMN IF EXISTS status;
+ALTER TABLE attachments DROP COLUMN IF EXISTS purpose;
+
+DROP TABLE IF EXISTS jobs;
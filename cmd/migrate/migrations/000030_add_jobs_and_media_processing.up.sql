@@ -0,0 +1,53 @@
+-- A general purpose queue for background work that should be retried when it
+-- fails.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(16) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'done', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    last_error VARCHAR(1000) NOT NULL DEFAULT '',
+    run_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP(0) with time zone,
+    completed_at TIMESTAMP(0) with time zone,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_pending ON jobs (kind, run_at) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS idx_jobs_finished ON jobs (completed_at) WHERE status IN ('done', 'failed');
+
+-- Images are processed after upload; other files are ready right away.
+ALTER TABLE attachments ADD COLUMN purpose VARCHAR(16) NOT NULL DEFAULT 'post' CHECK (purpose IN ('post', 'avatar'));
+ALTER TABLE attachments ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'ready' CHECK (status IN ('pending', 'processing', 'ready', 'failed'));
+ALTER TABLE attachments ADD COLUMN width INT;
+ALTER TABLE attachments ADD COLUMN height INT;
+ALTER TABLE attachments ADD COLUMN blurhash VARCHAR(100) NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS idx_attachments_unattached;
+CREATE INDEX IF NOT EXISTS idx_attachments_unattached ON attachments (created_at) WHERE post_id IS NULL AND purpose = 'post';
+
+CREATE TABLE IF NOT EXISTS attachment_variants (
+    attachment_id BIGINT NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    name VARCHAR(32) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL UNIQUE,
+    content_type VARCHAR(100) NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    size BIGINT NOT NULL,
+    PRIMARY KEY (attachment_id, name)
+);
+
+CREATE TRIGGER attachment_variants_delete_blob
+    AFTER DELETE ON attachment_variants
+    FOR EACH ROW EXECUTE FUNCTION attachments_queue_blob_deletion();
+
+ALTER TABLE users ADD COLUMN avatar_id BIGINT REFERENCES attachments(id) ON DELETE SET NULL;
+
+-- Images uploaded before processing existed still carry their metadata.
+UPDATE attachments SET status = 'pending' WHERE content_type IN ('image/jpeg', 'image/png', 'image/gif', 'image/webp');
+
+INSERT INTO jobs (kind, payload)
+SELECT 'media.process', jsonb_build_object('attachment_id', id)
+FROM attachments
+WHERE status = 'pending';
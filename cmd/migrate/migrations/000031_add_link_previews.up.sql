@@ -0,0 +1,25 @@
+-- Previews are cached per URL and shared by every post linking to it. Failed
+-- fetches are cached too, for less time, so that broken links are not
+-- fetched over and over.
+CREATE TABLE IF NOT EXISTS link_previews (
+    url VARCHAR(2048) PRIMARY KEY,
+    status VARCHAR(16) NOT NULL CHECK (status IN ('ready', 'failed')),
+    title VARCHAR(300) NOT NULL DEFAULT '',
+    description VARCHAR(1000) NOT NULL DEFAULT '',
+    image_url VARCHAR(2048) NOT NULL DEFAULT '',
+    site_name VARCHAR(200) NOT NULL DEFAULT '',
+    error VARCHAR(1000) NOT NULL DEFAULT '',
+    fetched_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_link_previews_expires_at ON link_previews (expires_at);
+
+CREATE TABLE IF NOT EXISTS post_links (
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    PRIMARY KEY (post_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_links_url ON post_links (url);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_posts_quote_of_id;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS quote_of_id;
+
+DROP TABLE IF EXISTS reposts;
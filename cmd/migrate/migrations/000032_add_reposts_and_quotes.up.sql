@@ -0,0 +1,16 @@
+-- A repost shares a post as is; reposting twice is a no-op. Reposts go with
+-- the original once it is purged from the trash.
+CREATE TABLE IF NOT EXISTS reposts (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reposts_post_id ON reposts (post_id);
+
+-- A quote is a post of its own that refers to another. It outlives the
+-- original, which then simply stops showing.
+ALTER TABLE posts ADD COLUMN quote_of_id BIGINT REFERENCES posts(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_posts_quote_of_id ON posts (quote_of_id) WHERE quote_of_id IS NOT NULL;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS bookmark_collections (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
+
+-- A bookmark sits in at most one collection. Deleting a collection keeps its
+-- bookmarks, unfiled.
+CREATE TABLE IF NOT EXISTS bookmarks (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id BIGINT NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    collection_id BIGINT REFERENCES bookmark_collections(id) ON DELETE SET NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_bookmarks_user_id_created_at ON bookmarks (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_bookmarks_collection_id ON bookmarks (collection_id) WHERE collection_id IS NOT NULL;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS polls (
+    post_id BIGINT PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+    multiple BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at TIMESTAMP(0) with time zone NOT NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    id BIGSERIAL PRIMARY KEY,
+    post_id BIGINT NOT NULL REFERENCES polls(post_id) ON DELETE CASCADE,
+    position SMALLINT NOT NULL,
+    text VARCHAR(100) NOT NULL,
+    UNIQUE (post_id, position)
+);
+
+-- A ballot records that a user voted, whatever they chose, so that a user
+-- votes once however many requests race. Counts are always computed from the
+-- votes themselves rather than kept in counters.
+CREATE TABLE IF NOT EXISTS poll_ballots (
+    post_id BIGINT NOT NULL REFERENCES polls(post_id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (post_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    post_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    option_id BIGINT NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+    PRIMARY KEY (post_id, user_id, option_id),
+    FOREIGN KEY (post_id, user_id) REFERENCES poll_ballots(post_id, user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_poll_votes_option_id ON poll_votes (option_id);
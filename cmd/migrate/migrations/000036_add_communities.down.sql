@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_posts_community_id;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS community_id;
+
+DROP TABLE IF EXISTS community_members;
+
+DROP TABLE IF EXISTS communities;
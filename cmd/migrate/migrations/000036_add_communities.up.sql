@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS communities (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description VARCHAR(1000) NOT NULL DEFAULT '',
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Each community has exactly one owner, who can hand the role over but not
+-- leave it empty.
+CREATE TABLE IF NOT EXISTS community_members (
+    community_id BIGINT NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'moderator', 'member')),
+    joined_at TIMESTAMP(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (community_id, user_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_community_members_owner ON community_members (community_id) WHERE role = 'owner';
+CREATE INDEX IF NOT EXISTS idx_community_members_user_id ON community_members (user_id);
+
+ALTER TABLE posts ADD COLUMN community_id BIGINT REFERENCES communities(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_posts_community_id ON posts (community_id) WHERE community_id IS NOT NULL;
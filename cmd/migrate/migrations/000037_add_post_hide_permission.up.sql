@@ -0,0 +1,7 @@
+INSERT INTO permissions (name, description) VALUES
+    ('post:hide:any', 'Hide posts written by other users');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r, permissions p
+WHERE r.name IN ('moderator', 'admin') AND p.name = 'post:hide:any';
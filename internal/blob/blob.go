@@ -0,0 +1,25 @@
+// Package blob stores uploaded files behind a small interface, so that the
+// API can keep them on local disk in development and in an S3-compatible
+// bucket in production.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs under keys made of URL-safe characters and slashes.
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any existing
+	// blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under key. It returns ErrNotFound if there is
+	// none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+}
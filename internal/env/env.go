@@ -3,6 +3,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,3 +31,30 @@ func GetDuration(key string, fallback time.Duration) time.Duration {
 	}
 	return fallback
 }
+
+func GetBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// GetInts reads a comma-separated list of integers.
+func GetInts(key string, fallback []int) []int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	var ints []int
+	for _, field := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return fallback
+		}
+		ints = append(ints, i)
+	}
+	return ints
+}
@@ -22,30 +22,29 @@ func NewGomailer(host string, port int, username string, password string, sender
 	return &Gomailer{dialer: dialer, sender: sender}
 }
 
-func (g *Gomailer) Send(templatePath, username, email, activationURL string) error {
+func (g *Gomailer) Send(templateFile, username, email string, data any) error {
 	// read template file
-	t, err := template.ParseFS(FS, "templates/"+templatePath)
+	t, err := template.ParseFS(FS, "templates/"+templateFile)
 	if err != nil {
-		return fmt.Errorf("mailer: failed to parse template file %s: %w", templatePath, err)
+		return fmt.Errorf("mailer: failed to parse template file %s: %w", templateFile, err)
 	}
 
 	// execute template
-	var body bytes.Buffer
-	if err := t.Execute(&body, struct {
-		Username      string
-		ActivationURL string
-	}{
-		Username:      username,
-		ActivationURL: activationURL,
-	}); err != nil {
-		return fmt.Errorf("mailer: failed to execute template file %s: %w", templatePath, err)
+	subject := new(bytes.Buffer)
+	if err := t.ExecuteTemplate(subject, "subject", data); err != nil {
+		return fmt.Errorf("mailer: failed to execute subject of %s: %w", templateFile, err)
+	}
+
+	body := new(bytes.Buffer)
+	if err := t.ExecuteTemplate(body, "body", data); err != nil {
+		return fmt.Errorf("mailer: failed to execute body of %s: %w", templateFile, err)
 	}
 
 	// create message
 	msg := mail.NewMessage()
 	msg.SetHeader("From", g.sender)
 	msg.SetHeader("To", email)
-	msg.SetHeader("Subject", "Finish Registration with the system")
+	msg.SetHeader("Subject", subject.String())
 	msg.SetBody("text/html", body.String())
 
 	// send email
@@ -3,13 +3,21 @@ package mailer
 import "embed"
 
 const (
-	maxRetries   = 3
-	TemplatePath = "user_invitation.html"
+	maxRetries                = 3
+	UserInvitationTemplate    = "user_invitation.html"
+	UserWarningTemplate       = "user_warning.html"
+	PasswordResetTemplate     = "password_reset.html"
+	AccountLockedTemplate     = "account_locked.html"
+	EmailChangeTemplate       = "email_change.html"
+	EmailChangeNoticeTemplate = "email_change_notice.html"
+	AccountDeletionTemplate   = "account_deletion.html"
 )
 
 //go:embed "templates"
 var FS embed.FS
 
+// Mailer renders one of the embedded templates with data and sends it. Every
+// template defines a "subject" and a "body" block.
 type Mailer interface {
-	Send(templatePath, username, email, activationURL string) error
+	Send(templateFile, username, email string, data any) error
 }
@@ -0,0 +1,254 @@
+// Package media prepares uploaded images for serving. It strips metadata
+// such as EXIF location data, applies the orientation the camera recorded,
+// and renders thumbnails, a WebP copy and a blurhash placeholder, using only
+// pure Go codecs.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/buckket/go-blurhash"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image dimensions are too large")
+)
+
+const (
+	jpegQuality      = 90
+	thumbnailQuality = 80
+
+	// blurhashSize is the side of the copy the placeholder is computed from;
+	// blurhash only keeps a few components, so more pixels buy nothing.
+	blurhashSize = 32
+)
+
+type Options struct {
+	// ThumbnailSizes lists the sides of the square, center-cropped JPEG
+	// thumbnails to render.
+	ThumbnailSizes []int
+	// WebPMaxSize bounds the longest side of the WebP copy.
+	WebPMaxSize int
+	// MaxPixels rejects images whose decoded size would exceed it, before
+	// decoding them.
+	MaxPixels int
+}
+
+// Variant is a rendition of an image derived from the original.
+type Variant struct {
+	Name        string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+type Result struct {
+	// Original is the uploaded image without its metadata.
+	Original    []byte
+	ContentType string
+	Width       int
+	Height      int
+	Blurhash    string
+	Variants    []Variant
+}
+
+// Process decodes a JPEG, PNG, GIF or WebP image and derives everything the
+// API serves for it.
+func Process(data []byte, opts Options) (*Result, error) {
+	config, format, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxPixels > 0 && config.Width*config.Height > opts.MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, err := decode(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{ContentType: "image/" + format}
+
+	// Re-encoding drops every metadata segment. GIF carries no EXIF, and is
+	// kept as is so that animations survive; WebP chunks are filtered so
+	// that the original compression is kept.
+	switch format {
+	case "jpeg":
+		img = orient(img, exifOrientation(data))
+		res.Original, err = encodeJPEG(img, jpegQuality)
+	case "png":
+		var buf bytes.Buffer
+		err = png.Encode(&buf, img)
+		res.Original = buf.Bytes()
+	case "gif":
+		res.Original = data
+	case "webp":
+		res.Original, err = stripWebPMetadata(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	res.Width, res.Height = bounds.Dx(), bounds.Dy()
+
+	for _, size := range opts.ThumbnailSizes {
+		thumb, err := encodeJPEG(cover(img, size), thumbnailQuality)
+		if err != nil {
+			return nil, err
+		}
+		res.Variants = append(res.Variants, Variant{
+			Name:        fmt.Sprintf("thumb_%d", size),
+			ContentType: "image/jpeg",
+			Width:       size,
+			Height:      size,
+			Data:        thumb,
+		})
+	}
+
+	scaled := fit(img, opts.WebPMaxSize)
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, scaled, nil); err != nil {
+		return nil, err
+	}
+	res.Variants = append(res.Variants, Variant{
+		Name:        "webp",
+		ContentType: "image/webp",
+		Width:       scaled.Bounds().Dx(),
+		Height:      scaled.Bounds().Dy(),
+		Data:        buf.Bytes(),
+	})
+
+	res.Blurhash, err = blurhash.Encode(4, 3, fit(img, blurhashSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func decodeConfig(data []byte) (image.Config, string, error) {
+	r := bytes.NewReader(data)
+
+	var config image.Config
+	var err error
+	format := sniffFormat(data)
+	switch format {
+	case "jpeg":
+		config, err = jpeg.DecodeConfig(r)
+	case "png":
+		config, err = png.DecodeConfig(r)
+	case "gif":
+		config, err = gif.DecodeConfig(r)
+	case "webp":
+		config, err = webp.DecodeConfig(r)
+	default:
+		return image.Config{}, "", ErrUnsupported
+	}
+	return config, format, err
+}
+
+func decode(data []byte, format string) (image.Image, error) {
+	r := bytes.NewReader(data)
+
+	switch format {
+	case "jpeg":
+		return jpeg.Decode(r)
+	case "png":
+		return png.Decode(r)
+	case "gif":
+		return gif.Decode(r)
+	case "webp":
+		return webp.Decode(r)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// sniffFormat recognises the image formats Process supports by their magic
+// numbers.
+func sniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	default:
+		return ""
+	}
+}
+
+// encodeJPEG flattens transparency onto white, which JPEG cannot store.
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cover scales img to fill a size×size square, cropping the longer side
+// evenly.
+func cover(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, image.Rect(x, y, x+side, y+side), draw.Src, nil)
+	return dst
+}
+
+// fit scales img down so that its longest side is at most size, keeping its
+// aspect ratio. Smaller images, and a size of 0, leave it untouched.
+func fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if size <= 0 || max(w, h) <= size {
+		return img
+	}
+
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// Supported reports whether Process can handle images of the given media
+// type.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	default:
+		return false
+	}
+}
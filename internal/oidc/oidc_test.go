@@ -0,0 +1,107 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/biboyqg/social/internal/oidc"
+	"github.com/biboyqg/social/internal/oidc/oidctest"
+)
+
+// login goes through a sign-in with the provider, from the authorization URL
+// to the code exchange.
+func login(t *testing.T, srv *oidctest.Server, provider *oidc.Provider, grant oidctest.Grant) (*oidc.Claims, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	code, state, err := srv.Authorize(authURL, grant)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if state != "state" {
+		t.Fatalf("state = %q, want %q", state, "state")
+	}
+
+	return provider.Exchange(ctx, code, verifier, "nonce")
+}
+
+var alice = oidctest.Identity{
+	Subject:           "alice-subject",
+	Email:             "alice@example.com",
+	EmailVerified:     true,
+	PreferredUsername: "alice",
+}
+
+func TestExchange(t *testing.T) {
+	srv := oidctest.NewServer(t)
+	provider := oidc.NewProvider("test", srv.Config())
+
+	claims, err := login(t, srv, provider, oidctest.Grant{Identity: alice})
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := oidc.Claims{
+		Subject:           alice.Subject,
+		Email:             alice.Email,
+		EmailVerified:     true,
+		PreferredUsername: alice.PreferredUsername,
+	}
+	if *claims != want {
+		t.Errorf("claims = %+v, want %+v", *claims, want)
+	}
+}
+
+func TestExchangeRejectsBadNonce(t *testing.T) {
+	srv := oidctest.NewServer(t)
+	provider := oidc.NewProvider("test", srv.Config())
+
+	_, err := login(t, srv, provider, oidctest.Grant{Identity: alice, Nonce: "replayed"})
+	if !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("err = %v, want %v", err, oidc.ErrInvalidIDToken)
+	}
+}
+
+func TestExchangeRejectsBadSignature(t *testing.T) {
+	srv := oidctest.NewServer(t)
+	provider := oidc.NewProvider("test", srv.Config())
+
+	_, err := login(t, srv, provider, oidctest.Grant{Identity: alice, SignWith: oidctest.NewKey(t)})
+	if !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("err = %v, want %v", err, oidc.ErrInvalidIDToken)
+	}
+}
+
+func TestDiscoveryRejectsOtherIssuer(t *testing.T) {
+	srv := oidctest.NewServer(t)
+	srv.Issuer = "https://attacker.example.com"
+	provider := oidc.NewProvider("test", srv.Config())
+
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("err = %v, want an issuer mismatch", err)
+	}
+}
+
+func TestDiscoveryAcceptsTrailingSlash(t *testing.T) {
+	srv := oidctest.NewServer(t)
+	srv.Issuer = srv.URL + "/"
+	provider := oidc.NewProvider("test", srv.Config())
+
+	if _, err := login(t, srv, provider, oidctest.Grant{Identity: alice}); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+}
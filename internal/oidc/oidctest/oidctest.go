@@ -0,0 +1,234 @@
+// Package oidctest runs an OpenID Connect provider for tests. It serves
+// discovery, JWKS and token endpoints, and hands out authorization codes
+// directly instead of showing a sign-in page.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/biboyqg/social/internal/oidc"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ClientID     = "test-client"
+	ClientSecret = "test-secret"
+	RedirectURL  = "http://localhost/callback"
+
+	keyID = "test-key"
+)
+
+// Identity is the user signing in with the provider.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Grant is what a code is exchanged for.
+type Grant struct {
+	Identity
+	// Nonce replaces the one from the authorization request when set.
+	Nonce string
+	// SignWith signs the ID token with another key than the published one.
+	SignWith *rsa.PrivateKey
+}
+
+type pendingGrant struct {
+	Grant
+	nonce         string
+	codeChallenge string
+}
+
+type Server struct {
+	*httptest.Server
+
+	// Issuer is the issuer put in the discovery document and ID tokens.
+	// It defaults to the server's URL.
+	Issuer string
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]pendingGrant
+}
+
+// NewServer starts a provider that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{
+		key:   NewKey(t),
+		codes: make(map[string]pendingGrant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discoveryHandler)
+	mux.HandleFunc("GET /jwks", s.jwksHandler)
+	mux.HandleFunc("POST /token", s.tokenHandler)
+
+	s.Server = httptest.NewServer(mux)
+	s.Issuer = s.URL
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// NewKey generates an RSA key, such as one to sign a forged ID token with.
+func NewKey(t testing.TB) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Config configures a relying party for the provider.
+func (s *Server) Config() oidc.Config {
+	return oidc.Config{
+		IssuerURL:    s.URL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		RedirectURL:  RedirectURL,
+	}
+}
+
+// Authorize stands in for the user signing in at the authorization URL. It
+// returns the code and state the provider would redirect back with.
+func (s *Server) Authorize(authURL string, grant Grant) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := u.Query()
+	if q.Get("client_id") != ClientID || q.Get("redirect_uri") != RedirectURL {
+		return "", "", errors.New("authorization request for another client")
+	}
+	if q.Get("code_challenge_method") != "S256" {
+		return "", "", errors.New("authorization request without PKCE")
+	}
+
+	code, err = oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	s.codes[code] = pendingGrant{
+		Grant:         grant,
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+	}
+	s.mu.Unlock()
+
+	return code, q.Get("state"), nil
+}
+
+func (s *Server) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.Issuer,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	if r.PostForm.Get("client_id") != ClientID || r.PostForm.Get("client_secret") != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	s.mu.Lock()
+	grant, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if !ok ||
+		r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != RedirectURL ||
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != grant.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := grant.nonce
+	if grant.Nonce != "" {
+		nonce = grant.Nonce
+	}
+
+	key := s.key
+	if grant.SignWith != nil {
+		key = grant.SignWith
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                s.Issuer,
+		"sub":                grant.Subject,
+		"aud":                ClientID,
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"email":              grant.Email,
+		"email_verified":     grant.EmailVerified,
+		"preferred_username": grant.PreferredUsername,
+	})
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access-" + code,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,122 @@
+package policy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Permissions known to the API. They are seeded by migrations and can
+// be granted to or revoked from roles at runtime.
+const (
+	PostUpdateAny    = "post:update:any"
+	PostDeleteAny    = "post:delete:any"
+	PostHideAny      = "post:hide:any"
+	PostRestoreAny   = "post:restore:any"
+	PostViewHidden   = "post:view:hidden"
+	CommentDeleteAny = "comment:delete:any"
+	ReportModerate   = "report:moderate"
+	UserBan          = "user:ban"
+	UserBanPermanent = "user:ban:permanent"
+	UserDeleteAny    = "user:delete:any"
+	UserManage       = "user:manage"
+	RoleManage       = "role:manage"
+	AuditRead        = "audit:read"
+)
+
+// Source loads the names of the permissions granted to a role.
+type Source interface {
+	GetNamesByRoleID(ctx context.Context, roleID int64) ([]string, error)
+}
+
+type entry struct {
+	permissions map[string]struct{}
+	expiresAt   time.Time
+}
+
+// Engine answers permission checks for roles. Role grants are cached for ttl
+// so that most checks do not hit the database.
+type Engine struct {
+	source Source
+	ttl    time.Duration
+
+	mu    sync.RWMutex
+	cache map[int64]entry
+}
+
+func New(source Source, ttl time.Duration) *Engine {
+	return &Engine{
+		source: source,
+		ttl:    ttl,
+		cache:  make(map[int64]entry),
+	}
+}
+
+// Can reports whether the role has been granted the permission.
+func (e *Engine) Can(ctx context.Context, roleID int64, permission string) (bool, error) {
+	permissions, err := e.load(ctx, roleID)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := permissions[permission]
+	return ok, nil
+}
+
+// Outranks reports whether the actor's role was granted every permission of
+// the target's role and at least one more, which is what it takes to act
+// against a holder of the target role.
+func (e *Engine) Outranks(ctx context.Context, actorRoleID, targetRoleID int64) (bool, error) {
+	actor, err := e.load(ctx, actorRoleID)
+	if err != nil {
+		return false, err
+	}
+	target, err := e.load(ctx, targetRoleID)
+	if err != nil {
+		return false, err
+	}
+
+	if len(actor) <= len(target) {
+		return false, nil
+	}
+	for permission := range target {
+		if _, ok := actor[permission]; !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Invalidate drops the cached grants of a role, for use after they change.
+func (e *Engine) Invalidate(roleID int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.cache, roleID)
+}
+
+func (e *Engine) load(ctx context.Context, roleID int64) (map[string]struct{}, error) {
+	e.mu.RLock()
+	cached, ok := e.cache[roleID]
+	e.mu.RUnlock()
+
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.permissions, nil
+	}
+
+	names, err := e.source.GetNamesByRoleID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		permissions[name] = struct{}{}
+	}
+
+	e.mu.Lock()
+	e.cache[roleID] = entry{permissions: permissions, expiresAt: time.Now().Add(e.ttl)}
+	e.mu.Unlock()
+
+	return permissions, nil
+}
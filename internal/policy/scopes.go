@@ -0,0 +1,44 @@
+package policy
+
+// Scopes limit what a personal access token can do on behalf of its owner.
+// They only narrow access: the owner's permissions still apply.
+const (
+	ScopePostsRead  = "posts:read"
+	ScopePostsWrite = "posts:write"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeReports    = "reports:write"
+	ScopeModeration = "moderation"
+	ScopeAdmin      = "admin"
+
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+
+	ScopeCommunitiesRead  = "communities:read"
+	ScopeCommunitiesWrite = "communities:write"
+)
+
+// Scopes lists every scope a token can be given.
+var Scopes = []string{
+	ScopePostsRead,
+	ScopePostsWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeReports,
+	ScopeModeration,
+	ScopeAdmin,
+	ScopeMessagesRead,
+	ScopeMessagesWrite,
+	ScopeCommunitiesRead,
+	ScopeCommunitiesWrite,
+}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const memorySweepInterval = time.Minute
+
+type memoryEntry struct {
+	count     int64
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+// MemoryBackend keeps limiter state in process. Limits are per instance, so
+// it only suits single-instance deployments and development.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		entries: make(map[string]*memoryEntry),
+		now:     time.Now,
+	}
+}
+
+func (b *MemoryBackend) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+
+	e, ok := b.entries[key]
+	if !ok || !now.Before(e.expiresAt) {
+		e = &memoryEntry{expiresAt: now.Add(window)}
+		b.entries[key] = e
+	}
+	e.count++
+
+	return e.count, e.expiresAt.Sub(now), nil
+}
+
+func (b *MemoryBackend) Take(ctx context.Context, key string, capacity int, rate float64) (bool, float64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+
+	e, ok := b.entries[key]
+	if !ok {
+		e = &memoryEntry{tokens: float64(capacity), updatedAt: now}
+		b.entries[key] = e
+	}
+
+	elapsed := now.Sub(e.updatedAt).Seconds()
+	e.tokens = math.Min(float64(capacity), e.tokens+elapsed*rate)
+	e.updatedAt = now
+
+	allowed := e.tokens >= 1
+	if allowed {
+		e.tokens--
+	}
+
+	// A bucket that has refilled completely is the same as no bucket.
+	e.expiresAt = now.Add(secondsToDuration((float64(capacity) - e.tokens) / rate))
+
+	return allowed, e.tokens, nil
+}
+
+// sweep drops expired entries so that keys of clients that went away do not
+// pile up. Callers hold b.mu.
+func (b *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < memorySweepInterval {
+		return
+	}
+	b.lastSweep = now
+
+	for key, e := range b.entries {
+		if !now.Before(e.expiresAt) {
+			delete(b.entries, key)
+		}
+	}
+}
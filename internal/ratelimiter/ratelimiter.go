@@ -0,0 +1,146 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math"
+	"time"
+)
+
+// Algorithms a Policy can use.
+const (
+	FixedWindow = "fixed_window"
+	TokenBucket = "token_bucket"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown rate limiting algorithm")
+
+// Metrics counts, per limiter name, the requests let through ("allowed"),
+// rejected ("limited") and let through because the backend failed ("errors").
+// It is published through expvar as "ratelimit".
+var Metrics = expvar.NewMap("ratelimit")
+
+// Policy allows Limit requests per Window. With FixedWindow the count resets
+// at the end of each window; with TokenBucket the allowance refills steadily
+// and at most Limit requests can burst at once.
+type Policy struct {
+	Algorithm string
+	Limit     int
+	Window    time.Duration
+}
+
+// Result describes the state of a key after a request was counted.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the key is back to its full allowance.
+	Reset time.Duration
+	// RetryAfter is how long a rejected client should wait. It is zero when
+	// the request was allowed.
+	RetryAfter time.Duration
+}
+
+// Backend holds limiter state. Both operations must be atomic per key so
+// that several API instances can share a backend.
+type Backend interface {
+	// Incr counts a hit in the current window of key and returns the count
+	// so far and the time left in the window.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	// Take removes one token from the bucket of key, refilled at rate tokens
+	// per second up to capacity. It reports whether a token was available
+	// and how many are left.
+	Take(ctx context.Context, key string, capacity int, rate float64) (bool, float64, error)
+}
+
+type Limiter struct {
+	name    string
+	policy  Policy
+	backend Backend
+}
+
+func New(name string, policy Policy, backend Backend) (*Limiter, error) {
+	if policy.Algorithm != FixedWindow && policy.Algorithm != TokenBucket {
+		return nil, ErrUnknownAlgorithm
+	}
+	if policy.Limit <= 0 || policy.Window <= 0 {
+		return nil, errors.New("rate limit policy needs a positive limit and window")
+	}
+
+	return &Limiter{name: name, policy: policy, backend: backend}, nil
+}
+
+func (l *Limiter) Name() string {
+	return l.name
+}
+
+// Allow counts a request for key. Keys are scoped to the limiter, so the same
+// key can be used with several limiters.
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	key = l.name + ":" + key
+
+	var (
+		res Result
+		err error
+	)
+	switch l.policy.Algorithm {
+	case FixedWindow:
+		res, err = l.fixedWindow(ctx, key)
+	default:
+		res, err = l.tokenBucket(ctx, key)
+	}
+	if err != nil {
+		Metrics.Add(l.name+".errors", 1)
+		return Result{}, err
+	}
+
+	if res.Allowed {
+		Metrics.Add(l.name+".allowed", 1)
+	} else {
+		Metrics.Add(l.name+".limited", 1)
+	}
+	return res, nil
+}
+
+func (l *Limiter) fixedWindow(ctx context.Context, key string) (Result, error) {
+	count, ttl, err := l.backend.Incr(ctx, key, l.policy.Window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Allowed:   count <= int64(l.policy.Limit),
+		Limit:     l.policy.Limit,
+		Remaining: max(l.policy.Limit-int(count), 0),
+		Reset:     ttl,
+	}
+	if !res.Allowed {
+		res.RetryAfter = ttl
+	}
+	return res, nil
+}
+
+func (l *Limiter) tokenBucket(ctx context.Context, key string) (Result, error) {
+	rate := float64(l.policy.Limit) / l.policy.Window.Seconds()
+
+	allowed, tokens, err := l.backend.Take(ctx, key, l.policy.Limit, rate)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     l.policy.Limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(l.policy.Limit) - tokens) / rate),
+	}
+	if !allowed {
+		res.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return res, nil
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
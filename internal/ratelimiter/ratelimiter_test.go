@@ -0,0 +1,119 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// step is a request made after advancing the clock, and what the limiter
+// should answer.
+type step struct {
+	advance    time.Duration
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func TestMemoryBackend(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		steps  []step
+	}{
+		{
+			name:   "fixed window",
+			policy: Policy{Algorithm: FixedWindow, Limit: 3, Window: time.Minute},
+			steps: []step{
+				{allowed: true, remaining: 2, reset: time.Minute},
+				{advance: 10 * time.Second, allowed: true, remaining: 1, reset: 50 * time.Second},
+				{allowed: true, remaining: 0, reset: 50 * time.Second},
+				{advance: 20 * time.Second, allowed: false, remaining: 0, reset: 30 * time.Second, retryAfter: 30 * time.Second},
+				{advance: 30 * time.Second, allowed: true, remaining: 2, reset: time.Minute},
+			},
+		},
+		{
+			name:   "token bucket",
+			policy: Policy{Algorithm: TokenBucket, Limit: 2, Window: 2 * time.Second},
+			steps: []step{
+				{allowed: true, remaining: 1, reset: time.Second},
+				{allowed: true, remaining: 0, reset: 2 * time.Second},
+				{allowed: false, remaining: 0, reset: 2 * time.Second, retryAfter: time.Second},
+				{advance: 500 * time.Millisecond, allowed: false, remaining: 0, reset: 1500 * time.Millisecond, retryAfter: 500 * time.Millisecond},
+				{advance: 500 * time.Millisecond, allowed: true, remaining: 0, reset: 2 * time.Second},
+				{advance: 10 * time.Second, allowed: true, remaining: 1, reset: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			backend := NewMemoryBackend()
+			backend.now = func() time.Time { return now }
+
+			limiter, err := New("test", tt.policy, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+
+				res, err := limiter.Allow(context.Background(), "key")
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+
+				want := Result{
+					Allowed:    s.allowed,
+					Limit:      tt.policy.Limit,
+					Remaining:  s.remaining,
+					Reset:      s.reset,
+					RetryAfter: s.retryAfter,
+				}
+				if res != want {
+					t.Errorf("step %d: got %+v, want %+v", i, res, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryBackendKeysAreSeparate(t *testing.T) {
+	limiter, err := New("test", Policy{Algorithm: FixedWindow, Limit: 1, Window: time.Minute}, NewMemoryBackend())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		res, err := limiter.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Errorf("first request for %q was limited", key)
+		}
+	}
+}
+
+func TestNewRejectsBadPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"unknown algorithm", Policy{Algorithm: "leaky_bucket", Limit: 1, Window: time.Second}},
+		{"no limit", Policy{Algorithm: FixedWindow, Window: time.Second}},
+		{"no window", Policy{Algorithm: TokenBucket, Limit: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New("test", tt.policy, NewMemoryBackend()); err == nil {
+				t.Error("New accepted the policy")
+			}
+		})
+	}
+}
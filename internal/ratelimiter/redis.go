@@ -0,0 +1,102 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var fixedWindowScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local ttl = redis.call('PTTL', KEYS[1])
+return {count, ttl}
+`)
+
+// The bucket is read, refilled and written in one script so that concurrent
+// requests cannot spend the same token. Time comes from the Redis server so
+// that API instances with skewed clocks agree.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1)
+
+return {allowed, tostring(tokens)}
+`)
+
+// RedisBackend shares limiter state between API instances. It only needs a
+// redis.Scripter, so a client pointed at any Redis-compatible server works.
+type RedisBackend struct {
+	client redis.Scripter
+	prefix string
+}
+
+func NewRedisBackend(client redis.Scripter, prefix string) *RedisBackend {
+	return &RedisBackend{client: client, prefix: prefix}
+}
+
+func (b *RedisBackend) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	res, err := fixedWindowScript.Run(ctx, b.client, []string{b.prefix + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) != 2 {
+		return 0, 0, fmt.Errorf("unexpected fixed window reply %v", res)
+	}
+
+	return res[0], time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func (b *RedisBackend) Take(ctx context.Context, key string, capacity int, rate float64) (bool, float64, error) {
+	res, err := tokenBucketScript.Run(
+		ctx,
+		b.client,
+		[]string{b.prefix + key},
+		capacity,
+		strconv.FormatFloat(rate, 'f', -1, 64),
+	).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected token bucket reply %v", res)
+	}
+
+	allowed, ok := res[0].(int64)
+	if !ok {
+		return false, 0, fmt.Errorf("unexpected token bucket reply %v", res)
+	}
+	s, ok := res[1].(string)
+	if !ok {
+		return false, 0, fmt.Errorf("unexpected token bucket reply %v", res)
+	}
+	tokens, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return allowed == 1, math.Max(tokens, 0), nil
+}
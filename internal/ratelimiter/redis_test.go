@@ -0,0 +1,87 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *RedisBackend) {
+	t.Helper()
+
+	srv := miniredis.RunT(t)
+	srv.SetTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return srv, NewRedisBackend(client, "ratelimit:")
+}
+
+func TestRedisBackendFixedWindow(t *testing.T) {
+	srv, backend := newTestRedis(t)
+	ctx := context.Background()
+
+	for want := int64(1); want <= 3; want++ {
+		count, ttl, err := backend.Incr(ctx, "key", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want || ttl != time.Minute {
+			t.Errorf("Incr = %d, %v, want %d, %v", count, ttl, want, time.Minute)
+		}
+	}
+
+	if !srv.Exists("ratelimit:key") {
+		t.Error("key was not stored under the prefix")
+	}
+
+	srv.FastForward(time.Minute)
+
+	count, _, err := backend.Incr(ctx, "key", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("count after the window = %d, want 1", count)
+	}
+}
+
+func TestRedisBackendTokenBucket(t *testing.T) {
+	srv, backend := newTestRedis(t)
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		advance time.Duration
+		allowed bool
+		tokens  float64
+	}{
+		{allowed: true, tokens: 1},
+		{allowed: true, tokens: 0},
+		{allowed: false, tokens: 0},
+		{advance: 500 * time.Millisecond, allowed: false, tokens: 0.5},
+		{advance: 500 * time.Millisecond, allowed: true, tokens: 0},
+	}
+
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		srv.SetTime(now)
+
+		allowed, tokens, err := backend.Take(ctx, "key", 2, 1)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if allowed != s.allowed || tokens != s.tokens {
+			t.Errorf("step %d: Take = %v, %v, want %v, %v", i, allowed, tokens, s.allowed, s.tokens)
+		}
+	}
+
+	// The bucket is empty, so it expires once it would have refilled.
+	if ttl := srv.TTL("ratelimit:key"); ttl <= 0 || ttl > 2*time.Second+time.Millisecond {
+		t.Errorf("TTL = %v, want at most the time to refill", ttl)
+	}
+}
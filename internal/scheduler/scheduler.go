@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Task is a unit of background work. It is expected to be idempotent: the
+// scheduler runs it on every tick and whatever it left unfinished is picked
+// up again on the next run, including after a restart.
+type Task func(ctx context.Context) error
+
+type task struct {
+	name     string
+	interval time.Duration
+	fn       Task
+}
+
+type Scheduler struct {
+	logger *zap.SugaredLogger
+	tasks  []task
+}
+
+func New(logger *zap.SugaredLogger) *Scheduler {
+	return &Scheduler{logger: logger}
+}
+
+// Every registers fn to run once at start and then every interval.
+func (s *Scheduler) Every(name string, interval time.Duration, fn Task) {
+	s.tasks = append(s.tasks, task{name: name, interval: interval, fn: fn})
+}
+
+// Start launches one goroutine per registered task. They stop when ctx is
+// cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, t := range s.tasks {
+		go s.loop(ctx, t)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, t task) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		s.run(ctx, t)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, t task) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			s.logger.Errorw("scheduled task panicked", "task", t.name, "panic", rec)
+		}
+	}()
+
+	if err := t.fn(ctx); err != nil {
+		s.logger.Errorw("scheduled task failed", "task", t.name, "error", err.Error())
+	}
+}
@@ -0,0 +1,101 @@
+package secretbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func newBox(t *testing.T, passphrase string) *Box {
+	t.Helper()
+
+	box, err := New(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return box
+}
+
+func TestRoundTrip(t *testing.T) {
+	box := newBox(t, "passphrase")
+
+	for _, plaintext := range [][]byte{{}, []byte("JBSWY3DPEHPK3PXP")} {
+		sealed, err := box.Seal(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opened, err := box.Open(sealed)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Errorf("Open = %q, want %q", opened, plaintext)
+		}
+	}
+}
+
+func TestSealUsesFreshNonces(t *testing.T) {
+	box := newBox(t, "passphrase")
+
+	a, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("sealing the same plaintext twice gave the same ciphertext")
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	box := newBox(t, "passphrase")
+
+	sealed, err := box.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range data {
+		tampered := bytes.Clone(data)
+		tampered[i] ^= 0x01
+
+		if _, err := box.Open(base64.StdEncoding.EncodeToString(tampered)); err == nil {
+			t.Fatalf("Open accepted a ciphertext with byte %d flipped", i)
+		}
+	}
+
+	truncated := base64.StdEncoding.EncodeToString(data[:len(data)-1])
+	if _, err := box.Open(truncated); err == nil {
+		t.Error("Open accepted a truncated ciphertext")
+	}
+}
+
+func TestOpenRejectsOtherKey(t *testing.T) {
+	sealed, err := newBox(t, "passphrase").Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newBox(t, "other passphrase").Open(sealed); err == nil {
+		t.Error("Open accepted a ciphertext sealed with another key")
+	}
+}
+
+func TestOpenRejectsMalformed(t *testing.T) {
+	box := newBox(t, "passphrase")
+
+	for _, sealed := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := box.Open(sealed); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Open(%q) = %v, want %v", sealed, err, ErrMalformed)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrAttachmentUnavailable = errors.New("attachment not found or attached to another post")
+
+// Processing states of an attachment. Images stay pending until their
+// metadata is stripped and their variants are rendered.
+const (
+	AttachmentPending    = "pending"
+	AttachmentProcessing = "processing"
+	AttachmentReady      = "ready"
+	AttachmentFailed     = "failed"
+)
+
+// What an upload is for.
+const (
+	AttachmentForPost   = "post"
+	AttachmentForAvatar = "avatar"
+)
+
+// Attachment is a file uploaded for a post, or a user's avatar. The file
+// itself lives in blob storage under StorageKey and is served by ID.
+type Attachment struct {
+	ID          int64               `json:"id"`
+	UserID      int64               `json:"user_id"`
+	PostID      *int64              `json:"post_id"`
+	Purpose     string              `json:"-"`
+	StorageKey  string              `json:"-"`
+	Filename    string              `json:"filename"`
+	ContentType string              `json:"content_type"`
+	Size        int64               `json:"size"`
+	Status      string              `json:"status"`
+	Width       *int                `json:"width,omitempty"`
+	Height      *int                `json:"height,omitempty"`
+	Blurhash    string              `json:"blurhash,omitempty"`
+	Variants    []AttachmentVariant `json:"variants"`
+	CreatedAt   string              `json:"created_at"`
+}
+
+// AttachmentVariant is a rendition of an image attachment, such as a
+// thumbnail, served by name alongside the original.
+type AttachmentVariant struct {
+	Name        string `json:"name"`
+	StorageKey  string `json:"-"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int64  `json:"size"`
+}
+
+type AttachmentStore struct {
+	db *sql.DB
+}
+
+// Create records an upload that is not attached to any post yet. Pending
+// uploads are queued for processing.
+func (s *AttachmentStore) Create(ctx context.Context, attachment *Attachment) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return createAttachment(ctx, tx, attachment)
+	})
+}
+
+// ReplaceAvatar makes the upload the user's avatar, deleting the previous
+// one.
+func (s *AttachmentStore) ReplaceAvatar(ctx context.Context, attachment *Attachment) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		attachment.Purpose = AttachmentForAvatar
+		if err := createAttachment(ctx, tx, attachment); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var previous *int64
+		query := `SELECT avatar_id FROM users WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, query, attachment.UserID).Scan(&previous); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNoRecord
+			default:
+				return err
+			}
+		}
+
+		query = `UPDATE users SET avatar_id = $1 WHERE id = $2`
+		if _, err := tx.ExecContext(ctx, query, attachment.ID, attachment.UserID); err != nil {
+			return err
+		}
+
+		if previous != nil {
+			query = `DELETE FROM attachments WHERE id = $1`
+			if _, err := tx.ExecContext(ctx, query, *previous); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteAvatar removes the user's avatar. It returns ErrNoRecord if they have
+// none.
+func (s *AttachmentStore) DeleteAvatar(ctx context.Context, userID int64) error {
+	query := `DELETE FROM attachments WHERE id = (SELECT avatar_id FROM users WHERE id = $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+func (s *AttachmentStore) GetAvatar(ctx context.Context, userID int64) (*Attachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachments a
+		JOIN users u ON u.avatar_id = a.id AND u.deleted_at IS NULL
+		WHERE u.id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	attachment, err := scanAttachment(s.db.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	if err := loadVariants(ctx, s.db, []*Attachment{attachment}); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentStore) GetByID(ctx context.Context, id int64) (*Attachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachments a
+		WHERE a.id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	attachment, err := scanAttachment(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	if err := loadVariants(ctx, s.db, []*Attachment{attachment}); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *AttachmentStore) GetVariant(ctx context.Context, attachmentID int64, name string) (*AttachmentVariant, error) {
+	query := `
+		SELECT name, storage_key, content_type, width, height, size
+		FROM attachment_variants
+		WHERE attachment_id = $1 AND name = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var v AttachmentVariant
+	err := s.db.QueryRowContext(ctx, query, attachmentID, name).Scan(
+		&v.Name,
+		&v.StorageKey,
+		&v.ContentType,
+		&v.Width,
+		&v.Height,
+		&v.Size,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return &v, nil
+}
+
+// GetByPostIDs returns the attachments of each of the given posts, in upload
+// order. Posts without attachments get an empty list.
+func (s *AttachmentStore) GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]Attachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachments a
+		WHERE a.post_id = ANY($1)
+		ORDER BY a.id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadVariants(ctx, s.db, all); err != nil {
+		return nil, err
+	}
+
+	attachments := make(map[int64][]Attachment, len(postIDs))
+	for _, id := range postIDs {
+		attachments[id] = []Attachment{}
+	}
+	for _, a := range all {
+		attachments[*a.PostID] = append(attachments[*a.PostID], *a)
+	}
+	return attachments, nil
+}
+
+// StartProcessing marks an attachment as being processed and returns it. It
+// returns ErrNoRecord if the attachment was deleted in the meantime.
+func (s *AttachmentStore) StartProcessing(ctx context.Context, id int64) (*Attachment, error) {
+	query := `
+		UPDATE attachments a
+		SET status = 'processing'
+		WHERE a.id = $1
+		RETURNING ` + attachmentColumns
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	attachment, err := scanAttachment(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return attachment, nil
+}
+
+// CompleteProcessing records what processing found out about an image, and
+// the variants it rendered, and marks it ready.
+func (s *AttachmentStore) CompleteProcessing(ctx context.Context, attachment *Attachment) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			UPDATE attachments
+			SET status = 'ready', size = $2, width = $3, height = $4, blurhash = $5
+			WHERE id = $1
+		`
+		res, err := tx.ExecContext(
+			ctx,
+			query,
+			attachment.ID,
+			attachment.Size,
+			attachment.Width,
+			attachment.Height,
+			attachment.Blurhash,
+		)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNoRecord
+		}
+
+		// Variants keep their key when an image is processed again, so
+		// they are updated in place rather than replaced.
+		query = `
+			INSERT INTO attachment_variants (attachment_id, name, storage_key, content_type, width, height, size)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (attachment_id, name) DO UPDATE
+			SET storage_key = EXCLUDED.storage_key, content_type = EXCLUDED.content_type,
+				width = EXCLUDED.width, height = EXCLUDED.height, size = EXCLUDED.size
+		`
+		for _, v := range attachment.Variants {
+			_, err := tx.ExecContext(ctx, query, attachment.ID, v.Name, v.StorageKey, v.ContentType, v.Width, v.Height, v.Size)
+			if err != nil {
+				return err
+			}
+		}
+		attachment.Status = AttachmentReady
+		return nil
+	})
+}
+
+// SetStatus moves an attachment to another processing state.
+func (s *AttachmentStore) SetStatus(ctx context.Context, id int64, status string) error {
+	query := `UPDATE attachments SET status = $2 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, status)
+	return err
+}
+
+// PurgeUnattached deletes uploads that were never attached to a post. Their
+// blobs are queued for deletion.
+func (s *AttachmentStore) PurgeUnattached(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM attachments WHERE post_id IS NULL AND purpose = 'post' AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PendingBlobDeletions lists up to limit storage keys whose attachment is
+// gone but whose blob may still exist.
+func (s *AttachmentStore) PendingBlobDeletions(ctx context.Context, limit int) ([]string, error) {
+	query := `
+		SELECT storage_key
+		FROM blob_deletions
+		ORDER BY created_at
+		LIMIT $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// ClearBlobDeletion records that the blob stored under key is gone.
+func (s *AttachmentStore) ClearBlobDeletion(ctx context.Context, key string) error {
+	query := `DELETE FROM blob_deletions WHERE storage_key = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
+
+// setPostAttachments makes ids the attachments of the post. They must be
+// uploads of ownerID that are unattached or already on this post; those the
+// post had but are no longer listed are deleted.
+func setPostAttachments(ctx context.Context, tx *sql.Tx, postID, ownerID int64, ids []int64) ([]Attachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		UPDATE attachments
+		SET post_id = $1
+		WHERE id = ANY($2) AND user_id = $3 AND purpose = 'post' AND (post_id IS NULL OR post_id = $1)
+	`
+	res, err := tx.ExecContext(ctx, query, postID, pq.Array(ids), ownerID)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected != int64(len(ids)) {
+		return nil, ErrAttachmentUnavailable
+	}
+
+	query = `DELETE FROM attachments WHERE post_id = $1 AND NOT (id = ANY($2))`
+	if _, err := tx.ExecContext(ctx, query, postID, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT ` + attachmentColumns + `
+		FROM attachments a
+		WHERE a.post_id = $1
+		ORDER BY a.id
+	`
+	rows, err := tx.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadVariants(ctx, tx, all); err != nil {
+		return nil, err
+	}
+
+	attachments := make([]Attachment, len(all))
+	for i, a := range all {
+		attachments[i] = *a
+	}
+	return attachments, nil
+}
+
+func createAttachment(ctx context.Context, tx *sql.Tx, attachment *Attachment) error {
+	if attachment.Purpose == "" {
+		attachment.Purpose = AttachmentForPost
+	}
+	if attachment.Status == "" {
+		attachment.Status = AttachmentReady
+	}
+
+	query := `
+		INSERT INTO attachments (user_id, purpose, storage_key, filename, content_type, size, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		attachment.UserID,
+		attachment.Purpose,
+		attachment.StorageKey,
+		attachment.Filename,
+		attachment.ContentType,
+		attachment.Size,
+		attachment.Status,
+	).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		return err
+	}
+	attachment.Variants = []AttachmentVariant{}
+
+	if attachment.Status == AttachmentPending {
+		return enqueueJob(ctx, tx, JobProcessMedia, MediaJob{AttachmentID: attachment.ID})
+	}
+	return nil
+}
+
+// loadVariants fills in the variants of the given attachments.
+func loadVariants(ctx context.Context, db interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}, attachments []*Attachment) error {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Attachment, len(attachments))
+	ids := make([]int64, len(attachments))
+	for i, a := range attachments {
+		a.Variants = []AttachmentVariant{}
+		byID[a.ID] = a
+		ids[i] = a.ID
+	}
+
+	query := `
+		SELECT attachment_id, name, storage_key, content_type, width, height, size
+		FROM attachment_variants
+		WHERE attachment_id = ANY($1)
+		ORDER BY attachment_id, name
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attachmentID int64
+		var v AttachmentVariant
+		if err := rows.Scan(&attachmentID, &v.Name, &v.StorageKey, &v.ContentType, &v.Width, &v.Height, &v.Size); err != nil {
+			return err
+		}
+		a := byID[attachmentID]
+		a.Variants = append(a.Variants, v)
+	}
+	return rows.Err()
+}
+
+const attachmentColumns = `a.id, a.user_id, a.post_id, a.purpose, a.storage_key, a.filename, a.content_type, a.size, a.status, a.width, a.height, a.blurhash, a.created_at`
+
+func scanAttachment(row interface{ Scan(...any) error }) (*Attachment, error) {
+	var a Attachment
+	err := row.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.PostID,
+		&a.Purpose,
+		&a.StorageKey,
+		&a.Filename,
+		&a.ContentType,
+		&a.Size,
+		&a.Status,
+		&a.Width,
+		&a.Height,
+		&a.Blurhash,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// AuditChange is the value of one field before and after an audited action.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditEvent records who did what to which object. Events are append-only:
+// the database rejects updates and deletes on audit_events.
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	ActorID    *int64                 `json:"actor_id"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type"`
+	TargetID   *int64                 `json:"target_id"`
+	RequestID  string                 `json:"request_id"`
+	IP         string                 `json:"ip"`
+	Changes    map[string]AuditChange `json:"changes,omitempty"`
+	CreatedAt  string                 `json:"created_at"`
+}
+
+// AuditChanges keeps the fields whose value differs between before and after.
+func AuditChanges(before, after map[string]any) map[string]AuditChange {
+	changes := make(map[string]AuditChange)
+
+	for field, b := range before {
+		a, ok := after[field]
+		if !ok || !reflect.DeepEqual(a, b) {
+			changes[field] = AuditChange{Before: b, After: a}
+		}
+	}
+	for field, a := range after {
+		if _, ok := before[field]; !ok {
+			changes[field] = AuditChange{Before: nil, After: a}
+		}
+	}
+
+	return changes
+}
+
+type AuditQuery struct {
+	ActorID    int64     `json:"actor_id" validate:"gte=0"`
+	Action     string    `json:"action" validate:"max=100"`
+	TargetType string    `json:"target_type" validate:"max=50"`
+	TargetID   int64     `json:"target_id" validate:"gte=0"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+	Limit      int       `json:"limit" validate:"gte=1,lte=10000"`
+	Offset     int       `json:"offset" validate:"gte=0"`
+}
+
+func (q *AuditQuery) Parse(r *http.Request) error {
+	qs := r.URL.Query()
+
+	if actor := qs.Get("actor_id"); actor != "" {
+		actorID, err := strconv.ParseInt(actor, 10, 64)
+		if err != nil {
+			return err
+		}
+		q.ActorID = actorID
+	}
+
+	if action := qs.Get("action"); action != "" {
+		q.Action = action
+	}
+
+	if targetType := qs.Get("target_type"); targetType != "" {
+		q.TargetType = targetType
+	}
+
+	if target := qs.Get("target_id"); target != "" {
+		targetID, err := strconv.ParseInt(target, 10, 64)
+		if err != nil {
+			return err
+		}
+		q.TargetID = targetID
+	}
+
+	if since := qs.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return err
+		}
+		q.Since = t
+	}
+
+	if until := qs.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return err
+		}
+		q.Until = t
+	}
+
+	if limit := qs.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		q.Limit = limitInt
+	}
+
+	if offset := qs.Get("offset"); offset != "" {
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+		q.Offset = offsetInt
+	}
+
+	return nil
+}
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func (s *AuditStore) Create(ctx context.Context, event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, request_id, ip, changes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+	var changes []byte
+	if len(event.Changes) > 0 {
+		var err error
+		changes, err = json.Marshal(event.Changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		event.ActorID,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		event.RequestID,
+		event.IP,
+		changes,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+// Query lists audit events, newest first.
+func (s *AuditStore) Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	query := `
+		SELECT id, actor_id, action, target_type, target_id, request_id, ip, changes, created_at
+		FROM audit_events
+		WHERE
+			(actor_id = $1 OR $1 = 0) AND
+			(action = $2 OR $2 = '') AND
+			(target_type = $3 OR $3 = '') AND
+			(target_id = $4 OR $4 = 0) AND
+			($5::timestamptz IS NULL OR created_at >= $5) AND
+			($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $7 OFFSET $8
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		query,
+		q.ActorID,
+		q.Action,
+		q.TargetType,
+		q.TargetID,
+		nullTime(q.Since),
+		nullTime(q.Until),
+		q.Limit,
+		q.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var changes []byte
+		err := rows.Scan(
+			&event.ID,
+			&event.ActorID,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&event.RequestID,
+			&event.IP,
+			&changes,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if changes != nil {
+			if err := json.Unmarshal(changes, &event.Changes); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
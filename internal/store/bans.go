@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Ban is either a suspension, which may expire on its own, or a permanent
+// ban. Only one ban per user is active at a time.
+type Ban struct {
+	ID        int64   `json:"id"`
+	UserID    int64   `json:"user_id"`
+	BannedBy  *int64  `json:"banned_by"`
+	Reason    string  `json:"reason"`
+	Permanent bool    `json:"permanent"`
+	ExpiresAt *string `json:"expires_at"`
+	CreatedAt string  `json:"created_at"`
+	LiftedAt  *string `json:"lifted_at,omitempty"`
+	LiftedBy  *int64  `json:"lifted_by,omitempty"`
+}
+
+type BanStore struct {
+	db *sql.DB
+}
+
+// Create applies a ban, replacing whatever ban the user currently has.
+func (s *BanStore) Create(ctx context.Context, ban *Ban) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return createBan(ctx, tx, ban)
+	})
+}
+
+func createBan(ctx context.Context, tx *sql.Tx, ban *Ban) error {
+	if err := liftBans(ctx, tx, ban.UserID, ban.BannedBy); err != nil && !errors.Is(err, ErrNoRecord) {
+		return err
+	}
+
+	query := `
+		INSERT INTO user_bans (user_id, banned_by, reason, permanent, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		ban.UserID,
+		ban.BannedBy,
+		ban.Reason,
+		ban.Permanent,
+		ban.ExpiresAt,
+	).Scan(&ban.ID, &ban.CreatedAt)
+}
+
+// GetActive returns the ban currently in force for the user, if any.
+func (s *BanStore) GetActive(ctx context.Context, userID int64) (*Ban, error) {
+	query := `
+		SELECT id, user_id, banned_by, reason, permanent, expires_at, created_at, lifted_at, lifted_by
+		FROM user_bans
+		WHERE user_id = $1 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	ban := &Ban{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&ban.ID,
+		&ban.UserID,
+		&ban.BannedBy,
+		&ban.Reason,
+		&ban.Permanent,
+		&ban.ExpiresAt,
+		&ban.CreatedAt,
+		&ban.LiftedAt,
+		&ban.LiftedBy,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return ban, nil
+}
+
+func (s *BanStore) GetByUserID(ctx context.Context, userID int64) ([]Ban, error) {
+	query := `
+		SELECT id, user_id, banned_by, reason, permanent, expires_at, created_at, lifted_at, lifted_by
+		FROM user_bans
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []Ban{}
+	for rows.Next() {
+		var ban Ban
+		err := rows.Scan(
+			&ban.ID,
+			&ban.UserID,
+			&ban.BannedBy,
+			&ban.Reason,
+			&ban.Permanent,
+			&ban.ExpiresAt,
+			&ban.CreatedAt,
+			&ban.LiftedAt,
+			&ban.LiftedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// Lift ends the active ban of a user.
+func (s *BanStore) Lift(ctx context.Context, userID, liftedBy int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return liftBans(ctx, tx, userID, &liftedBy)
+	})
+}
+
+func liftBans(ctx context.Context, tx *sql.Tx, userID int64, liftedBy *int64) error {
+	query := `
+		UPDATE user_bans
+		SET lifted_at = NOW(), lifted_by = $2
+		WHERE user_id = $1 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, userID, liftedBy)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
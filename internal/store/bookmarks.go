@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+var ErrUnknownCollection = errors.New("bookmark collection not found")
+
+type Bookmark struct {
+	CollectionID *int64 `json:"collection_id"`
+	CreatedAt    string `json:"created_at"`
+	Post         Post   `json:"post"`
+}
+
+type BookmarkCollection struct {
+	ID             int64  `json:"id"`
+	UserID         int64  `json:"user_id"`
+	Name           string `json:"name"`
+	BookmarksCount int    `json:"bookmarks_count"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type BookmarkQuery struct {
+	Limit  int `json:"limit" validate:"gte=1,lte=100"`
+	Offset int `json:"offset" validate:"gte=0"`
+	// CollectionID, when set, lists only the bookmarks of that collection.
+	CollectionID *int64 `json:"collection_id" validate:"omitempty,min=1"`
+}
+
+func (q *BookmarkQuery) Parse(r *http.Request) error {
+	qs := r.URL.Query()
+
+	if limit := qs.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		q.Limit = limitInt
+	}
+
+	if offset := qs.Get("offset"); offset != "" {
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+		q.Offset = offsetInt
+	}
+
+	if collection := qs.Get("collection_id"); collection != "" {
+		collectionID, err := strconv.ParseInt(collection, 10, 64)
+		if err != nil {
+			return err
+		}
+		q.CollectionID = &collectionID
+	}
+
+	return nil
+}
+
+type BookmarkStore struct {
+	db *sql.DB
+}
+
+// Save bookmarks the post for the user, in the given collection if any.
+// Saving a post bookmarked already moves it to that collection. It returns
+// ErrUnknownCollection when the collection is not one of the user's.
+func (s *BookmarkStore) Save(ctx context.Context, userID, postID int64, collectionID *int64) error {
+	query := `
+		INSERT INTO bookmarks (user_id, post_id, collection_id)
+		SELECT $1::bigint, $2::bigint, $3::bigint
+		WHERE $3::bigint IS NULL OR EXISTS (
+			SELECT 1 FROM bookmark_collections WHERE id = $3 AND user_id = $1
+		)
+		ON CONFLICT (user_id, post_id) DO UPDATE SET collection_id = EXCLUDED.collection_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, postID, collectionID)
+	if err != nil {
+		// The collection was deleted in the meantime.
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return ErrUnknownCollection
+		}
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUnknownCollection
+	}
+	return nil
+}
+
+func (s *BookmarkStore) Delete(ctx context.Context, userID, postID int64) error {
+	query := `DELETE FROM bookmarks WHERE user_id = $1 AND post_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, postID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// GetBookmarked returns which of the given posts the user bookmarked.
+func (s *BookmarkStore) GetBookmarked(ctx context.Context, userID int64, postIDs []int64) (map[int64]bool, error) {
+	query := `SELECT post_id FROM bookmarks WHERE user_id = $1 AND post_id = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarked := make(map[int64]bool)
+	for rows.Next() {
+		var postID int64
+		if err := rows.Scan(&postID); err != nil {
+			return nil, err
+		}
+		bookmarked[postID] = true
+	}
+	return bookmarked, rows.Err()
+}
+
+// List returns the bookmarks of the user, most recent first. Bookmarks of
+// posts the user can no longer see are left out: posts in the trash, hidden
+// by moderation, or whose author deleted their account. They come back if
+// the post does.
+func (s *BookmarkStore) List(ctx context.Context, userID int64, q BookmarkQuery) ([]Bookmark, error) {
+	query := `
+		SELECT b.collection_id, b.created_at,
+			p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, p.published_at, p.version, p.quote_of_id, u.username
+		FROM bookmarks b
+		JOIN posts p ON p.id = b.post_id
+		JOIN users u ON u.id = p.user_id
+		WHERE
+			b.user_id = $1 AND
+			($4::bigint IS NULL OR b.collection_id = $4) AND
+			p.deleted_at IS NULL AND
+			u.deleted_at IS NULL AND
+			((p.published_at IS NOT NULL AND p.hidden_at IS NULL) OR p.user_id = $1)
+		ORDER BY b.created_at DESC, p.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, q.Limit, q.Offset, q.CollectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []Bookmark{}
+	for rows.Next() {
+		var b Bookmark
+		err := rows.Scan(
+			&b.CollectionID,
+			&b.CreatedAt,
+			&b.Post.ID,
+			&b.Post.Content,
+			&b.Post.Title,
+			&b.Post.UserID,
+			pq.Array(&b.Post.Tags),
+			&b.Post.CreatedAt,
+			&b.Post.UpdatedAt,
+			&b.Post.PublishedAt,
+			&b.Post.Version,
+			&b.Post.QuoteOfID,
+			&b.Post.User.Username,
+		)
+		if err != nil {
+			return nil, err
+		}
+		b.Post.Edited = b.Post.Version > 0
+		b.Post.Bookmarked = true
+		bookmarks = append(bookmarks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bookmarks, nil
+}
+
+func (s *BookmarkStore) CreateCollection(ctx context.Context, collection *BookmarkCollection) error {
+	query := `
+		INSERT INTO bookmark_collections (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, collection.UserID, collection.Name).Scan(&collection.ID, &collection.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *BookmarkStore) ListCollections(ctx context.Context, userID int64) ([]BookmarkCollection, error) {
+	query := `
+		SELECT c.id, c.user_id, c.name, c.created_at,
+			(SELECT COUNT(*) FROM bookmarks b WHERE b.collection_id = c.id) AS bookmarks_count
+		FROM bookmark_collections c
+		WHERE c.user_id = $1
+		ORDER BY c.name
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []BookmarkCollection{}
+	for rows.Next() {
+		var c BookmarkCollection
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.CreatedAt, &c.BookmarksCount); err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// DeleteCollection deletes one of the user's collections. Its bookmarks are
+// kept, outside of any collection.
+func (s *BookmarkStore) DeleteCollection(ctx context.Context, userID, id int64) error {
+	query := `DELETE FROM bookmark_collections WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
@@ -3,8 +3,11 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 )
 
+// Comment is a reply to a post. Comments whose author has been purged keep
+// their content with a UserID of 0.
 type Comment struct {
 	ID        int64    `json:"id"`
 	PostID    int64    `json:"post_id"`
@@ -12,6 +15,7 @@ type Comment struct {
 	Content   string   `json:"content"`
 	CreatedAt string   `json:"created_at"`
 	UpdatedAt string   `json:"updated_at"`
+	HiddenAt  *string  `json:"hidden_at,omitempty"`
 	User      User     `json:"user"`
 }
 
@@ -39,10 +43,11 @@ func (s *CommentStore) Create(ctx context.Context, comment *Comment) error {
 
 func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment, error) {
 	query := `
-		SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, c.updated_at, u.id, u.username, u.email, u.created_at
+		SELECT c.id, c.post_id, COALESCE(c.user_id, 0), c.content, c.created_at, c.updated_at,
+			COALESCE(u.id, 0), COALESCE(u.username, '[deleted]'), COALESCE(u.email, ''), u.created_at
 		FROM comments c
 		LEFT JOIN users u ON c.user_id = u.id
-		WHERE c.post_id = $1
+		WHERE c.post_id = $1 AND c.hidden_at IS NULL AND u.deleted_at IS NULL
 		ORDER BY c.created_at DESC
 	`
 
@@ -59,10 +64,12 @@ func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment
 
 	for rows.Next() {
 		var comment Comment
+		var userCreatedAt sql.NullString
 		comment.User = User{}
-		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt, &comment.User.ID, &comment.User.Username, &comment.User.Email, &comment.User.CreatedAt); err != nil {
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt, &comment.User.ID, &comment.User.Username, &comment.User.Email, &userCreatedAt); err != nil {
 			return nil, err
 		}
+		comment.User.CreatedAt = userCreatedAt.String
 		comments = append(comments, comment)
 	}
 
@@ -73,7 +80,26 @@ func (s *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment
 	return comments, nil
 }
 
+func (s *CommentStore) GetByID(ctx context.Context, id int64) (*Comment, error) {
+	query := `
+		SELECT id, post_id, COALESCE(user_id, 0), content, created_at, updated_at, hidden_at
+		FROM comments
+		WHERE id = $1
+	`
 
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
 
+	var comment Comment
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Content, &comment.CreatedAt, &comment.UpdatedAt, &comment.HiddenAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
 
-
+	return &comment, nil
+}
@@ -0,0 +1,353 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+const (
+	CommunityRoleOwner     = "owner"
+	CommunityRoleModerator = "moderator"
+	CommunityRoleMember    = "member"
+)
+
+var ErrCommunityOwner = errors.New("the owner cannot leave the community or give up the role; transfer ownership first")
+
+type Community struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	CreatedBy    *int64 `json:"created_by"`
+	CreatedAt    string `json:"created_at"`
+	MembersCount int    `json:"members_count"`
+	// Role is the role in the community of the user who loaded it, empty if
+	// they are not a member.
+	Role string `json:"role,omitempty"`
+}
+
+type CommunityMember struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joined_at"`
+}
+
+// communityRank orders the roles in a community, with non-members lowest.
+func communityRank(role string) int {
+	switch role {
+	case CommunityRoleOwner:
+		return 3
+	case CommunityRoleModerator:
+		return 2
+	case CommunityRoleMember:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// CanModerate reports whether a community role lets its holder remove the
+// posts of someone with authorRole in the community: moderators and the
+// owner can, for authors with a strictly lower role.
+func CanModerate(role, authorRole string) bool {
+	return communityRank(role) >= communityRank(CommunityRoleModerator) && communityRank(role) > communityRank(authorRole)
+}
+
+type CommunityQuery struct {
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+	Offset int    `json:"offset" validate:"gte=0"`
+	Search string `json:"search" validate:"max=100"`
+}
+
+func (q *CommunityQuery) Parse(r *http.Request) error {
+	qs := r.URL.Query()
+
+	if limit := qs.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		q.Limit = limitInt
+	}
+
+	if offset := qs.Get("offset"); offset != "" {
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+		q.Offset = offsetInt
+	}
+
+	if search := qs.Get("search"); search != "" {
+		q.Search = search
+	}
+
+	return nil
+}
+
+type CommunityStore struct {
+	db *sql.DB
+}
+
+// Create adds the community, with its creator as the owner. It returns
+// ErrAlreadyExists when the name is taken.
+func (s *CommunityStore) Create(ctx context.Context, community *Community) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO communities (name, description, created_by)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at
+		`
+		err := tx.QueryRowContext(ctx, query, community.Name, community.Description, community.CreatedBy).Scan(
+			&community.ID,
+			&community.CreatedAt,
+		)
+		if err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrAlreadyExists
+			}
+			return err
+		}
+
+		query = `INSERT INTO community_members (community_id, user_id, role) VALUES ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, query, community.ID, community.CreatedBy, CommunityRoleOwner); err != nil {
+			return err
+		}
+
+		community.MembersCount = 1
+		community.Role = CommunityRoleOwner
+		return nil
+	})
+}
+
+const communityColumns = `
+	c.id, c.name, c.description, c.created_by, c.created_at,
+	(SELECT COUNT(*) FROM community_members m JOIN users u ON u.id = m.user_id AND u.deleted_at IS NULL WHERE m.community_id = c.id) AS members_count,
+	COALESCE((SELECT m.role FROM community_members m WHERE m.community_id = c.id AND m.user_id = $1), '') AS role
+`
+
+func scanCommunity(row interface{ Scan(...any) error }, community *Community) error {
+	return row.Scan(
+		&community.ID,
+		&community.Name,
+		&community.Description,
+		&community.CreatedBy,
+		&community.CreatedAt,
+		&community.MembersCount,
+		&community.Role,
+	)
+}
+
+// GetByID returns the community as seen by userID.
+func (s *CommunityStore) GetByID(ctx context.Context, id, userID int64) (*Community, error) {
+	query := `SELECT ` + communityColumns + ` FROM communities c WHERE c.id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var community Community
+	if err := scanCommunity(s.db.QueryRowContext(ctx, query, userID, id), &community); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return &community, nil
+}
+
+// List pages through the communities whose name matches the search, the
+// largest first.
+func (s *CommunityStore) List(ctx context.Context, userID int64, q CommunityQuery) ([]Community, error) {
+	query := `
+		SELECT * FROM (
+			SELECT ` + communityColumns + `
+			FROM communities c
+			WHERE c.name ILIKE '%' || $2 || '%'
+		) c
+		ORDER BY c.members_count DESC, c.id
+		LIMIT $3 OFFSET $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, q.Search, q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	communities := []Community{}
+	for rows.Next() {
+		var community Community
+		if err := scanCommunity(rows, &community); err != nil {
+			return nil, err
+		}
+		communities = append(communities, community)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return communities, nil
+}
+
+// Join makes the user a member of the community. It returns ErrAlreadyExists
+// when they are one already, and ErrNoRecord when there is no such community.
+func (s *CommunityStore) Join(ctx context.Context, communityID, userID int64) error {
+	query := `INSERT INTO community_members (community_id, user_id, role) VALUES ($1, $2, $3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, communityID, userID, CommunityRoleMember)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				return ErrAlreadyExists
+			case "23503":
+				return ErrNoRecord
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Leave removes the user from the community. Their posts stay in it. The
+// owner cannot leave, which keeps every community with an owner.
+func (s *CommunityStore) Leave(ctx context.Context, communityID, userID int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		role, err := getCommunityRole(ctx, tx, communityID, userID, true)
+		if err != nil {
+			return err
+		}
+		if role == CommunityRoleOwner {
+			return ErrCommunityOwner
+		}
+
+		query := `DELETE FROM community_members WHERE community_id = $1 AND user_id = $2`
+		_, err = tx.ExecContext(ctx, query, communityID, userID)
+		return err
+	})
+}
+
+// GetRole returns the role of the user in the community, or ErrNoRecord if
+// they are not a member.
+func (s *CommunityStore) GetRole(ctx context.Context, communityID, userID int64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return getCommunityRole(ctx, s.db, communityID, userID, false)
+}
+
+func getCommunityRole(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, communityID, userID int64, lock bool) (string, error) {
+	query := `SELECT role FROM community_members WHERE community_id = $1 AND user_id = $2`
+	if lock {
+		query += ` FOR UPDATE`
+	}
+
+	var role string
+	if err := db.QueryRowContext(ctx, query, communityID, userID).Scan(&role); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNoRecord
+		default:
+			return "", err
+		}
+	}
+	return role, nil
+}
+
+// GetMembers pages through the members of the community, the owner and
+// moderators first.
+func (s *CommunityStore) GetMembers(ctx context.Context, communityID int64, q CommunityQuery) ([]CommunityMember, error) {
+	query := `
+		SELECT m.user_id, u.username, m.role, m.joined_at
+		FROM community_members m
+		JOIN users u ON u.id = m.user_id AND u.deleted_at IS NULL
+		WHERE m.community_id = $1 AND u.username ILIKE '%' || $2 || '%'
+		ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'moderator' THEN 1 ELSE 2 END, m.joined_at, m.user_id
+		LIMIT $3 OFFSET $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, communityID, q.Search, q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []CommunityMember{}
+	for rows.Next() {
+		var member CommunityMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SetRole gives a member of the community a new role. Making someone the
+// owner hands the role over: the current owner becomes a moderator. The
+// owner's own role only changes that way, so it returns ErrCommunityOwner
+// when asked to demote them, and ErrNoRecord when the user is not a member.
+func (s *CommunityStore) SetRole(ctx context.Context, communityID, userID int64, role string) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// Lock the community so that two hand-overs cannot both go through.
+		query := `SELECT id FROM communities WHERE id = $1 FOR UPDATE`
+		var id int64
+		if err := tx.QueryRowContext(ctx, query, communityID).Scan(&id); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNoRecord
+			default:
+				return err
+			}
+		}
+
+		current, err := getCommunityRole(ctx, tx, communityID, userID, true)
+		if err != nil {
+			return err
+		}
+		if current == role {
+			return nil
+		}
+		if current == CommunityRoleOwner {
+			return ErrCommunityOwner
+		}
+
+		if role == CommunityRoleOwner {
+			query := `UPDATE community_members SET role = $2 WHERE community_id = $1 AND role = $3`
+			if _, err := tx.ExecContext(ctx, query, communityID, CommunityRoleModerator, CommunityRoleOwner); err != nil {
+				return err
+			}
+		}
+
+		query = `UPDATE community_members SET role = $3 WHERE community_id = $1 AND user_id = $2`
+		_, err = tx.ExecContext(ctx, query, communityID, userID, role)
+		return err
+	})
+}
@@ -0,0 +1,577 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrMessagingNotAllowed = errors.New("user does not accept messages from you")
+	ErrDirectConversation  = errors.New("direct conversations cannot be left")
+)
+
+// Conversation is a private exchange of messages, either direct between two
+// users or within a small group. UnreadCount and LastMessage are as seen by
+// the member who loaded it.
+type Conversation struct {
+	ID            int64                `json:"id"`
+	Direct        bool                 `json:"direct"`
+	CreatedBy     *int64               `json:"created_by"`
+	CreatedAt     string               `json:"created_at"`
+	LastMessageAt string               `json:"last_message_at"`
+	UnreadCount   int                  `json:"unread_count"`
+	LastMessage   *Message             `json:"last_message"`
+	Members       []ConversationMember `json:"members"`
+}
+
+// ConversationMember tells, through LastReadMessageID, how far a member has
+// read: every message up to that ID.
+type ConversationMember struct {
+	UserID            int64   `json:"user_id"`
+	Username          string  `json:"username"`
+	LastReadMessageID int64   `json:"last_read_message_id"`
+	LastReadAt        *string `json:"last_read_at"`
+}
+
+type Message struct {
+	ID             int64  `json:"id"`
+	ConversationID int64  `json:"conversation_id"`
+	SenderID       *int64 `json:"sender_id"`
+	Content        string `json:"content"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type ConversationQuery struct {
+	Limit  int `json:"limit" validate:"gte=1,lte=50"`
+	Offset int `json:"offset" validate:"gte=0"`
+}
+
+func (q *ConversationQuery) Parse(r *http.Request) error {
+	qs := r.URL.Query()
+
+	if limit := qs.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		q.Limit = limitInt
+	}
+
+	if offset := qs.Get("offset"); offset != "" {
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+		q.Offset = offsetInt
+	}
+
+	return nil
+}
+
+// MessageQuery pages through a conversation from the newest message back.
+// Before is the ID of the oldest message already seen.
+type MessageQuery struct {
+	Limit  int    `json:"limit" validate:"gte=1,lte=100"`
+	Before *int64 `json:"before" validate:"omitempty,min=1"`
+}
+
+func (q *MessageQuery) Parse(r *http.Request) error {
+	qs := r.URL.Query()
+
+	if limit := qs.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		q.Limit = limitInt
+	}
+
+	if before := qs.Get("before"); before != "" {
+		beforeID, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			return err
+		}
+		q.Before = &beforeID
+	}
+
+	return nil
+}
+
+// Messages from users the reader blocked are left out of what they see.
+// The reader is always $1.
+const (
+	conversationColumns = `
+		c.id, c.direct_key IS NOT NULL, c.created_by, c.created_at, c.last_message_at,
+		(
+			SELECT COUNT(*) FROM messages m
+			WHERE m.conversation_id = c.id AND m.id > cm.last_read_message_id AND m.sender_id IS DISTINCT FROM $1
+				AND NOT EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = $1 AND b.blocked_id = m.sender_id)
+		) AS unread_count,
+		lm.id, lm.sender_id, lm.content, lm.created_at
+	`
+	conversationSource = `
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.sender_id, m.content, m.created_at
+			FROM messages m
+			WHERE m.conversation_id = c.id
+				AND NOT EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = $1 AND b.blocked_id = m.sender_id)
+			ORDER BY m.id DESC
+			LIMIT 1
+		) lm ON TRUE
+	`
+)
+
+type ConversationStore struct {
+	db *sql.DB
+}
+
+// Start opens a conversation between the creator and the given users: a
+// direct one for a single user, a group otherwise. Everyone must accept
+// messages from the creator, as checkMessagingAllowed describes. A direct
+// conversation that exists already is returned as is, with created false.
+func (s *ConversationStore) Start(ctx context.Context, creatorID int64, memberIDs []int64) (*Conversation, bool, error) {
+	var id int64
+	created := false
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var key *string
+		if len(memberIDs) == 1 {
+			k := directKey(creatorID, memberIDs[0])
+			key = &k
+
+			// An existing conversation carries on whoever follows whom
+			// now; blocks are checked when sending.
+			query := `SELECT id FROM conversations WHERE direct_key = $1`
+			err := tx.QueryRowContext(ctx, query, k).Scan(&id)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+
+		if err := checkMessagingAllowed(ctx, tx, creatorID, memberIDs); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO conversations (direct_key, created_by) VALUES ($1, $2) RETURNING id`
+		if err := tx.QueryRowContext(ctx, query, key, creatorID).Scan(&id); err != nil {
+			// The other user started the same direct conversation at the
+			// same time.
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrEditConflict
+			}
+			return err
+		}
+
+		query = `
+			INSERT INTO conversation_members (conversation_id, user_id)
+			SELECT $1, UNNEST($2::bigint[])
+		`
+		members := append([]int64{creatorID}, memberIDs...)
+		if _, err := tx.ExecContext(ctx, query, id, pq.Array(members)); err != nil {
+			return err
+		}
+
+		created = true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	conversation, err := s.GetForMember(ctx, id, creatorID)
+	if err != nil {
+		return nil, false, err
+	}
+	return conversation, created, nil
+}
+
+// checkMessagingAllowed makes sure each recipient is an active user who
+// accepts messages from the sender: neither of them blocked the other, and
+// the sender follows the recipient unless the recipient opted in to
+// messages from anyone.
+func checkMessagingAllowed(ctx context.Context, tx *sql.Tx, senderID int64, recipientIDs []int64) error {
+	query := `
+		SELECT
+			EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE (b.blocker_id = u.id AND b.blocked_id = $1) OR (b.blocker_id = $1 AND b.blocked_id = u.id)
+			) AS blocked,
+			u.open_messages OR EXISTS (
+				SELECT 1 FROM followers f WHERE f.user_id = u.id AND f.follower_id = $1
+			) AS reachable
+		FROM users u
+		WHERE u.id = ANY($2) AND u.is_active AND u.deleted_at IS NULL
+	`
+	rows, err := tx.QueryContext(ctx, query, senderID, pq.Array(recipientIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := 0
+	for rows.Next() {
+		var blocked, reachable bool
+		if err := rows.Scan(&blocked, &reachable); err != nil {
+			return err
+		}
+		if blocked || !reachable {
+			return ErrMessagingNotAllowed
+		}
+		found++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found != len(recipientIDs) {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+func directKey(a, b int64) string {
+	return fmt.Sprintf("%d:%d", min(a, b), max(a, b))
+}
+
+// GetForMember returns the conversation as seen by one of its members. It
+// returns ErrNoRecord when userID is not a member.
+func (s *ConversationStore) GetForMember(ctx context.Context, id, userID int64) (*Conversation, error) {
+	query := `SELECT ` + conversationColumns + conversationSource + `
+		WHERE cm.user_id = $1 AND c.id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	conversation, err := scanConversation(s.db.QueryRowContext(ctx, query, userID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	if err := loadConversationMembers(ctx, s.db, []*Conversation{conversation}); err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// ListForUser returns the conversations of the user, those with the most
+// recent messages first.
+func (s *ConversationStore) ListForUser(ctx context.Context, userID int64, q ConversationQuery) ([]Conversation, error) {
+	query := `SELECT ` + conversationColumns + conversationSource + `
+		WHERE cm.user_id = $1
+		ORDER BY c.last_message_at DESC, c.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		conversation, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, *conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	refs := make([]*Conversation, len(conversations))
+	for i := range conversations {
+		refs[i] = &conversations[i]
+	}
+	if err := loadConversationMembers(ctx, s.db, refs); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+func scanConversation(row interface{ Scan(...any) error }) (*Conversation, error) {
+	var c Conversation
+	var lastID, lastSenderID *int64
+	var lastContent, lastCreatedAt *string
+
+	err := row.Scan(
+		&c.ID,
+		&c.Direct,
+		&c.CreatedBy,
+		&c.CreatedAt,
+		&c.LastMessageAt,
+		&c.UnreadCount,
+		&lastID,
+		&lastSenderID,
+		&lastContent,
+		&lastCreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastID != nil {
+		c.LastMessage = &Message{
+			ID:             *lastID,
+			ConversationID: c.ID,
+			SenderID:       lastSenderID,
+			Content:        *lastContent,
+			CreatedAt:      *lastCreatedAt,
+		}
+	}
+	return &c, nil
+}
+
+func loadConversationMembers(ctx context.Context, db *sql.DB, conversations []*Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(conversations))
+	byID := make(map[int64]*Conversation, len(conversations))
+	for i, c := range conversations {
+		ids[i] = c.ID
+		byID[c.ID] = c
+		c.Members = []ConversationMember{}
+	}
+
+	query := `
+		SELECT cm.conversation_id, cm.user_id, u.username, cm.last_read_message_id, cm.last_read_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id AND u.deleted_at IS NULL
+		WHERE cm.conversation_id = ANY($1)
+		ORDER BY cm.conversation_id, cm.joined_at, cm.user_id
+	`
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID int64
+		var m ConversationMember
+		if err := rows.Scan(&conversationID, &m.UserID, &m.Username, &m.LastReadMessageID, &m.LastReadAt); err != nil {
+			return err
+		}
+		c := byID[conversationID]
+		c.Members = append(c.Members, m)
+	}
+	return rows.Err()
+}
+
+// Send adds a message to a conversation the sender is a member of, and
+// marks the conversation read up to it for the sender. In a direct
+// conversation, a block between the two users stops all messages.
+func (s *ConversationStore) Send(ctx context.Context, message *Message) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// Locking the conversation makes messages commit in ID order, which
+		// read positions and keyset pages rely on.
+		query := `
+			SELECT c.direct_key IS NOT NULL,
+				EXISTS (
+					SELECT 1 FROM conversation_members o
+					JOIN user_blocks b ON (b.blocker_id = o.user_id AND b.blocked_id = $2) OR (b.blocker_id = $2 AND b.blocked_id = o.user_id)
+					WHERE o.conversation_id = c.id AND o.user_id <> $2
+				)
+			FROM conversations c
+			JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = $2
+			WHERE c.id = $1
+			FOR UPDATE OF c
+		`
+		var direct, blocked bool
+		err := tx.QueryRowContext(ctx, query, message.ConversationID, *message.SenderID).Scan(&direct, &blocked)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNoRecord
+			default:
+				return err
+			}
+		}
+		if direct && blocked {
+			return ErrMessagingNotAllowed
+		}
+
+		query = `
+			INSERT INTO messages (conversation_id, sender_id, content)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at
+		`
+		err = tx.QueryRowContext(ctx, query, message.ConversationID, message.SenderID, message.Content).Scan(&message.ID, &message.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		query = `UPDATE conversations SET last_message_at = NOW() WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, query, message.ConversationID); err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE conversation_members
+			SET last_read_message_id = $3, last_read_at = NOW()
+			WHERE conversation_id = $1 AND user_id = $2
+		`
+		_, err = tx.ExecContext(ctx, query, message.ConversationID, message.SenderID, message.ID)
+		return err
+	})
+}
+
+// GetMessages returns a page of the messages of a conversation as seen by
+// one of its members, newest first.
+func (s *ConversationStore) GetMessages(ctx context.Context, conversationID, userID int64, q MessageQuery) ([]Message, error) {
+	query := `
+		SELECT m.id, m.conversation_id, m.sender_id, m.content, m.created_at
+		FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $2
+		WHERE m.conversation_id = $1 AND ($4::bigint IS NULL OR m.id < $4)
+			AND NOT EXISTS (SELECT 1 FROM user_blocks b WHERE b.blocker_id = $2 AND b.blocked_id = m.sender_id)
+		ORDER BY m.id DESC
+		LIMIT $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, conversationID, userID, q.Limit, q.Before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.Content, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkRead records that the member read the conversation up to messageID,
+// or up to its latest message when messageID is nil. Read positions never
+// move back.
+func (s *ConversationStore) MarkRead(ctx context.Context, conversationID, userID int64, messageID *int64) error {
+	query := `
+		UPDATE conversation_members cm
+		SET last_read_message_id = GREATEST(cm.last_read_message_id, LEAST(COALESCE($3::bigint, latest.id), latest.id)),
+			last_read_at = NOW()
+		FROM (SELECT COALESCE(MAX(id), 0) AS id FROM messages WHERE conversation_id = $1) latest
+		WHERE cm.conversation_id = $1 AND cm.user_id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, conversationID, userID, messageID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Leave takes the user out of a group conversation, which is deleted once
+// its last member leaves. Direct conversations cannot be left.
+func (s *ConversationStore) Leave(ctx context.Context, conversationID, userID int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			SELECT c.direct_key IS NOT NULL
+			FROM conversations c
+			JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = $2
+			WHERE c.id = $1
+			FOR UPDATE OF c
+		`
+		var direct bool
+		if err := tx.QueryRowContext(ctx, query, conversationID, userID).Scan(&direct); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNoRecord
+			default:
+				return err
+			}
+		}
+		if direct {
+			return ErrDirectConversation
+		}
+
+		query = `DELETE FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`
+		if _, err := tx.ExecContext(ctx, query, conversationID, userID); err != nil {
+			return err
+		}
+
+		query = `
+			DELETE FROM conversations c
+			WHERE c.id = $1 AND NOT EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id = $1)
+		`
+		_, err := tx.ExecContext(ctx, query, conversationID)
+		return err
+	})
+}
+
+// GetOpenMessages reports whether the user accepts messages from people who
+// do not follow them.
+func (s *ConversationStore) GetOpenMessages(ctx context.Context, userID int64) (bool, error) {
+	query := `SELECT open_messages FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var open bool
+	if err := s.db.QueryRowContext(ctx, query, userID).Scan(&open); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrNoRecord
+		default:
+			return false, err
+		}
+	}
+	return open, nil
+}
+
+func (s *ConversationStore) SetOpenMessages(ctx context.Context, userID int64, open bool) error {
+	query := `UPDATE users SET open_messages = $2 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, open)
+	return err
+}
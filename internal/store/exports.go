@@ -0,0 +1,353 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport is a request for a copy of everything a user has stored with
+// us. Exports are built in the background and can be downloaded until they
+// expire.
+type DataExport struct {
+	ID          int64   `json:"id"`
+	UserID      int64   `json:"user_id"`
+	Status      string  `json:"status"`
+	Size        int64   `json:"size"`
+	Error       string  `json:"error,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	CompletedAt *string `json:"completed_at"`
+	ExpiresAt   *string `json:"expires_at"`
+}
+
+// UserData is the content of a data export.
+type UserData struct {
+	Profile   ExportedProfile   `json:"profile"`
+	Posts     []ExportedPost    `json:"posts"`
+	Comments  []ExportedComment `json:"comments"`
+	Following []ExportedFollow  `json:"following"`
+	Followers []ExportedFollow  `json:"followers"`
+}
+
+type ExportedProfile struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportedPost struct {
+	ID          int64    `json:"id"`
+	Title       string   `json:"title"`
+	Content     string   `json:"content"`
+	Tags        []string `json:"tags"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+	PublishedAt *string  `json:"published_at"`
+	DeletedAt   *string  `json:"deleted_at,omitempty"`
+}
+
+type ExportedComment struct {
+	ID        int64  `json:"id"`
+	PostID    int64  `json:"post_id"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportedFollow struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportStore struct {
+	db *sql.DB
+}
+
+// Create queues an export. It fails with ErrAlreadyExists while another one
+// of the user's exports is in progress.
+func (s *ExportStore) Create(ctx context.Context, export *DataExport) error {
+	query := `
+		INSERT INTO data_exports (user_id)
+		VALUES ($1)
+		RETURNING id, status, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, export.UserID).Scan(&export.ID, &export.Status, &export.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ExportStore) GetByUserID(ctx context.Context, userID int64) ([]DataExport, error) {
+	query := `
+		SELECT id, user_id, status, size, error, created_at, completed_at, expires_at
+		FROM data_exports
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []DataExport{}
+	for rows.Next() {
+		var e DataExport
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Status,
+			&e.Size,
+			&e.Error,
+			&e.CreatedAt,
+			&e.CompletedAt,
+			&e.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// GetArchive returns the archive of one of the user's ready, unexpired
+// exports.
+func (s *ExportStore) GetArchive(ctx context.Context, userID, id int64) ([]byte, error) {
+	query := `
+		SELECT archive
+		FROM data_exports
+		WHERE id = $1 AND user_id = $2 AND status = 'ready' AND expires_at > NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var archive []byte
+	if err := s.db.QueryRowContext(ctx, query, id, userID).Scan(&archive); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return archive, nil
+}
+
+// ClaimNext marks the oldest pending export as running and returns it.
+// Exports left running by a worker that died are picked up again after
+// staleAfter. It returns ErrNoRecord when there is nothing to do.
+func (s *ExportStore) ClaimNext(ctx context.Context, staleAfter time.Duration) (*DataExport, error) {
+	query := `
+		UPDATE data_exports
+		SET status = 'running', started_at = NOW()
+		WHERE id = (
+			SELECT id
+			FROM data_exports
+			WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, status, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	e := &DataExport{}
+	err := s.db.QueryRowContext(ctx, query, time.Now().Add(-staleAfter)).Scan(&e.ID, &e.UserID, &e.Status, &e.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (s *ExportStore) Complete(ctx context.Context, id int64, archive []byte, expiresAt time.Time) error {
+	query := `
+		UPDATE data_exports
+		SET status = 'ready', archive = $2, size = $3, completed_at = NOW(), expires_at = $4
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, archive, len(archive), expiresAt)
+	return err
+}
+
+func (s *ExportStore) Fail(ctx context.Context, id int64, reason string) error {
+	query := `
+		UPDATE data_exports
+		SET status = 'failed', error = $2, completed_at = NOW()
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if len(reason) > 1000 {
+		reason = reason[:1000]
+	}
+	_, err := s.db.ExecContext(ctx, query, id, reason)
+	return err
+}
+
+// PurgeExpired drops exports whose download window has passed.
+func (s *ExportStore) PurgeExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM data_exports WHERE expires_at < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Collect gathers the user's profile, posts (including those in the trash),
+// comments and follows for an export.
+func (s *ExportStore) Collect(ctx context.Context, userID int64) (*UserData, error) {
+	data := &UserData{
+		Posts:     []ExportedPost{},
+		Comments:  []ExportedComment{},
+		Following: []ExportedFollow{},
+		Followers: []ExportedFollow{},
+	}
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			SELECT u.id, u.username, u.email, r.name, u.created_at
+			FROM users u
+			JOIN roles r ON r.id = u.role_id
+			WHERE u.id = $1
+		`
+		p := &data.Profile
+		if err := tx.QueryRowContext(ctx, query, userID).Scan(&p.ID, &p.Username, &p.Email, &p.Role, &p.CreatedAt); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		query = `
+			SELECT id, title, content, tags, created_at, updated_at, published_at, deleted_at
+			FROM posts
+			WHERE user_id = $1
+			ORDER BY created_at
+		`
+		rows, err := tx.QueryContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var post ExportedPost
+			err := rows.Scan(&post.ID, &post.Title, &post.Content, pq.Array(&post.Tags), &post.CreatedAt, &post.UpdatedAt, &post.PublishedAt, &post.DeletedAt)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			data.Posts = append(data.Posts, post)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		query = `
+			SELECT id, post_id, content, created_at
+			FROM comments
+			WHERE user_id = $1
+			ORDER BY created_at
+		`
+		rows, err = tx.QueryContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var comment ExportedComment
+			if err := rows.Scan(&comment.ID, &comment.PostID, &comment.Content, &comment.CreatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			data.Comments = append(data.Comments, comment)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		following := `
+			SELECT u.id, u.username, f.created_at
+			FROM followers f
+			JOIN users u ON u.id = f.user_id
+			WHERE f.follower_id = $1
+			ORDER BY f.created_at
+		`
+		if data.Following, err = collectFollows(ctx, tx, following, userID); err != nil {
+			return err
+		}
+
+		followers := `
+			SELECT u.id, u.username, f.created_at
+			FROM followers f
+			JOIN users u ON u.id = f.follower_id
+			WHERE f.user_id = $1
+			ORDER BY f.created_at
+		`
+		data.Followers, err = collectFollows(ctx, tx, followers, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func collectFollows(ctx context.Context, tx *sql.Tx, query string, userID int64) ([]ExportedFollow, error) {
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []ExportedFollow{}
+	for rows.Next() {
+		var f ExportedFollow
+		if err := rows.Scan(&f.UserID, &f.Username, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		follows = append(follows, f)
+	}
+	return follows, rows.Err()
+}
@@ -57,7 +57,7 @@ func (s *FollowerStore) GetFollowers(ctx context.Context, userID int64) ([]User,
 		SELECT u.id, u.username, u.email, u.created_at
 		FROM users u
 		JOIN followers f ON f.follower_id = u.id
-		WHERE f.user_id = $1
+		WHERE f.user_id = $1 AND u.deleted_at IS NULL
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Identity links a user to the account they have with an external OIDC
+// provider.
+type Identity struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"-"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+// OIDCState is an authorization request waiting for the provider to redirect
+// the user back.
+type OIDCState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+// GetUserID returns the user linked to the provider's subject.
+func (s *IdentityStore) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	query := `
+		SELECT user_id
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNoRecord
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// Link attaches an identity to an existing user. It fails with
+// ErrAlreadyExists if the user is already linked to another account with the
+// same provider.
+func (s *IdentityStore) Link(ctx context.Context, identity *Identity) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
+func createIdentity(ctx context.Context, tx *sql.Tx, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *IdentityStore) CreateState(ctx context.Context, state *OIDCState) error {
+	query := `
+		INSERT INTO oidc_states (state, provider, nonce, code_verifier, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		state.State,
+		state.Provider,
+		state.Nonce,
+		state.CodeVerifier,
+		state.ExpiresAt,
+	)
+	return err
+}
+
+// ConsumeState removes and returns a pending authorization request, so that
+// each one can only complete once.
+func (s *IdentityStore) ConsumeState(ctx context.Context, provider, state string) (*OIDCState, error) {
+	query := `
+		DELETE FROM oidc_states
+		WHERE state = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING state, provider, nonce, code_verifier, expires_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	pending := &OIDCState{}
+	err := s.db.QueryRowContext(ctx, query, state, provider).Scan(
+		&pending.State,
+		&pending.Provider,
+		&pending.Nonce,
+		&pending.CodeVerifier,
+		&pending.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// PurgeExpiredStates drops authorization requests that were never completed.
+func (s *IdentityStore) PurgeExpiredStates(ctx context.Context) (int64, error) {
+	query := `DELETE FROM oidc_states WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
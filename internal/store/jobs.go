@@ -0,0 +1,222 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Kinds of background jobs.
+const (
+	JobProcessMedia = "media.process"
+	JobUnfurlLink   = "link.unfurl"
+)
+
+// Job is a unit of background work. Jobs that fail are retried later, until
+// they run out of attempts.
+type Job struct {
+	ID          int64
+	Kind        string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	LastError   string
+	RunAt       string
+	CreatedAt   string
+}
+
+// MediaJob asks for an uploaded image to be processed.
+type MediaJob struct {
+	AttachmentID int64 `json:"attachment_id"`
+}
+
+// LinkJob asks for the preview of a linked page to be fetched.
+type LinkJob struct {
+	URL string `json:"url"`
+}
+
+type JobStore struct {
+	db *sql.DB
+}
+
+func (s *JobStore) Enqueue(ctx context.Context, kind string, payload any) error {
+	return enqueueJob(ctx, s.db, kind, payload)
+}
+
+// ClaimNext marks the next due job of the given kind as running and returns
+// it. Jobs left running by a worker that died are picked up again after
+// staleAfter. It returns ErrNoRecord when there is nothing to do.
+func (s *JobStore) ClaimNext(ctx context.Context, kind string, staleAfter time.Duration) (*Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'running', started_at = NOW(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE kind = $1 AND (
+				(status = 'queued' AND run_at <= NOW()) OR
+				(status = 'running' AND started_at < $2 AND attempts < max_attempts)
+			)
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns + `
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var job Job
+	err := scanJob(s.db.QueryRowContext(ctx, query, kind, time.Now().Add(-staleAfter)), &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return &job, nil
+}
+
+// FailStale gives up on the jobs of the given kind left running for longer
+// than staleAfter on their last attempt, whose worker died before it could
+// retry or fail them, and returns them.
+func (s *JobStore) FailStale(ctx context.Context, kind string, staleAfter time.Duration) ([]Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'failed', completed_at = NOW(), last_error = 'worker stopped during the last attempt'
+		WHERE kind = $1 AND status = 'running' AND started_at < $2 AND attempts >= max_attempts
+		RETURNING ` + jobColumns + `
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, kind, time.Now().Add(-staleAfter))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var job Job
+		if err := scanJob(rows, &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, last_error, run_at, created_at`
+
+func scanJob(row interface{ Scan(...any) error }, job *Job) error {
+	return row.Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.CreatedAt,
+	)
+}
+
+func (s *JobStore) Complete(ctx context.Context, id int64) error {
+	query := `UPDATE jobs SET status = 'done', completed_at = NOW() WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// Retry puts a failed job back in the queue to run after delay, or gives up
+// on it once it has used all its attempts. It returns the job's new status.
+func (s *JobStore) Retry(ctx context.Context, id int64, reason string, delay time.Duration) (string, error) {
+	query := `
+		UPDATE jobs
+		SET
+			status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'queued' END,
+			completed_at = CASE WHEN attempts >= max_attempts THEN NOW() END,
+			run_at = $3,
+			last_error = $2
+		WHERE id = $1
+		RETURNING status
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var status string
+	err := s.db.QueryRowContext(ctx, query, id, truncateError(reason), time.Now().Add(delay)).Scan(&status)
+	return status, err
+}
+
+// Fail gives up on a job without retrying it.
+func (s *JobStore) Fail(ctx context.Context, id int64, reason string) error {
+	query := `UPDATE jobs SET status = 'failed', completed_at = NOW(), last_error = $2 WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, truncateError(reason))
+	return err
+}
+
+// PurgeFinished drops jobs that succeeded or gave up before the given time.
+func (s *JobStore) PurgeFinished(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM jobs WHERE status IN ('done', 'failed') AND completed_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// enqueueJob queues a job, inside a transaction when given one so that the
+// job only exists if the work it refers to was committed.
+func enqueueJob(ctx context.Context, db interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}, kind string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO jobs (kind, payload) VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err = db.ExecContext(ctx, query, kind, data)
+	return err
+}
+
+func truncateError(reason string) string {
+	if len(reason) > 1000 {
+		return strings.ToValidUTF8(reason[:1000], "")
+	}
+	return reason
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type LoginAttempt struct {
+	ID        int64  `json:"id"`
+	Email     string `json:"email"`
+	IP        string `json:"ip"`
+	Succeeded bool   `json:"succeeded"`
+	CreatedAt string `json:"created_at"`
+}
+
+// LoginFailures summarizes the failed attempts counted against an email or an
+// IP address.
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func (s *LoginAttemptStore) Create(ctx context.Context, attempt *LoginAttempt) error {
+	query := `
+		INSERT INTO login_attempts (email, ip, succeeded)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		attempt.Email,
+		attempt.IP,
+		attempt.Succeeded,
+	).Scan(&attempt.ID, &attempt.CreatedAt)
+}
+
+// Reserve records a login attempt as failed before its credentials are
+// checked, then counts the failures for its email and IP address since the
+// given time, leaving itself out. The insert is committed before counting, so
+// of several concurrent attempts the one inserted last sees all the others:
+// they cannot all get in under the limit. The attempt is then either marked
+// as succeeded or deleted if its credentials were never judged.
+func (s *LoginAttemptStore) Reserve(ctx context.Context, attempt *LoginAttempt, since time.Time) (byEmail, byIP LoginFailures, err error) {
+	attempt.Succeeded = false
+	if err := s.Create(ctx, attempt); err != nil {
+		return LoginFailures{}, LoginFailures{}, err
+	}
+
+	// Failures before the last successful login for the email are forgotten.
+	byEmail, err = s.failures(ctx, `
+		SELECT COUNT(*), MAX(created_at)
+		FROM login_attempts
+		WHERE email = $1 AND id <> $3 AND NOT succeeded AND created_at > GREATEST($2, (
+			SELECT COALESCE(MAX(created_at), '-infinity')
+			FROM login_attempts
+			WHERE email = $1 AND succeeded
+		))
+	`, attempt.Email, since, attempt.ID)
+	if err != nil {
+		return LoginFailures{}, LoginFailures{}, err
+	}
+
+	byIP, err = s.failures(ctx, `
+		SELECT COUNT(*), MAX(created_at)
+		FROM login_attempts
+		WHERE ip = $1 AND id <> $3 AND NOT succeeded AND created_at > $2
+	`, attempt.IP, since, attempt.ID)
+	if err != nil {
+		return LoginFailures{}, LoginFailures{}, err
+	}
+
+	return byEmail, byIP, nil
+}
+
+func (s *LoginAttemptStore) failures(ctx context.Context, query string, key string, since time.Time, excludeID int64) (LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var (
+		failures LoginFailures
+		last     sql.NullTime
+	)
+	if err := s.db.QueryRowContext(ctx, query, key, since, excludeID).Scan(&failures.Count, &last); err != nil {
+		return LoginFailures{}, err
+	}
+	failures.Last = last.Time
+
+	return failures, nil
+}
+
+// Succeed marks a reserved attempt as a successful login.
+func (s *LoginAttemptStore) Succeed(ctx context.Context, id int64) error {
+	query := `UPDATE login_attempts SET succeeded = TRUE WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// Delete drops a reserved attempt that neither failed nor succeeded.
+func (s *LoginAttemptStore) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM login_attempts WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// PurgeBefore drops attempts too old to count towards any lockout.
+func (s *LoginAttemptStore) PurgeBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM login_attempts WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
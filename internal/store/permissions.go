@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Permission struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type PermissionStore struct {
+	db *sql.DB
+}
+
+func (s *PermissionStore) List(ctx context.Context) ([]Permission, error) {
+	query := `SELECT id, name, description FROM permissions ORDER BY name ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.query(ctx, query)
+}
+
+func (s *PermissionStore) GetByRoleID(ctx context.Context, roleID int64) ([]Permission, error) {
+	query := `
+		SELECT p.id, p.name, p.description
+		FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.id
+		WHERE rp.role_id = $1
+		ORDER BY p.name ASC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.query(ctx, query, roleID)
+}
+
+// GetNamesByRoleID is the lookup the policy engine caches.
+func (s *PermissionStore) GetNamesByRoleID(ctx context.Context, roleID int64) ([]string, error) {
+	permissions, err := s.GetByRoleID(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(permissions))
+	for i, p := range permissions {
+		names[i] = p.Name
+	}
+	return names, nil
+}
+
+func (s *PermissionStore) query(ctx context.Context, query string, args ...any) ([]Permission, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// Grant gives a permission to a role. Granting a permission the role already
+// has is a no-op.
+func (s *PermissionStore) Grant(ctx context.Context, roleID int64, name string) error {
+	query := `
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT r.id, p.id
+		FROM roles r, permissions p
+		WHERE r.id = $1 AND p.name = $2
+		ON CONFLICT DO NOTHING
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, roleID, name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// Either the role or the permission does not exist, or the grant is
+		// already in place.
+		return s.checkGrant(ctx, roleID, name)
+	}
+	return nil
+}
+
+func (s *PermissionStore) checkGrant(ctx context.Context, roleID int64, name string) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM role_permissions rp
+			JOIN permissions p ON p.id = rp.permission_id
+			WHERE rp.role_id = $1 AND p.name = $2
+		)
+	`
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, query, roleID, name).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+func (s *PermissionStore) Revoke(ctx context.Context, roleID int64, name string) error {
+	query := `
+		DELETE FROM role_permissions rp
+		USING permissions p
+		WHERE rp.permission_id = p.id AND rp.role_id = $1 AND p.name = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, roleID, name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
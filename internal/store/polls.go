@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrPollClosed   = errors.New("poll is closed")
+	ErrInvalidVote  = errors.New("invalid choice of poll options")
+	ErrAlreadyVoted = errors.New("you already voted in this poll")
+)
+
+// Poll lets readers of a post vote on up to a few options. Results, the
+// vote counts, are only given to those who voted and once the poll closed.
+type Poll struct {
+	Multiple    bool         `json:"multiple"`
+	ClosesAt    string       `json:"closes_at"`
+	Closed      bool         `json:"closed"`
+	Options     []PollOption `json:"options"`
+	VotersCount *int         `json:"voters_count,omitempty"`
+	// Voted and Choices are about the user who loaded the poll.
+	Voted   bool    `json:"voted"`
+	Choices []int64 `json:"choices,omitempty"`
+}
+
+type PollOption struct {
+	ID    int64  `json:"id"`
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+type PollStore struct {
+	db *sql.DB
+}
+
+// createPoll adds the poll of a post being created. Option IDs are filled
+// in.
+func createPoll(ctx context.Context, tx *sql.Tx, postID int64, poll *Poll) error {
+	query := `INSERT INTO polls (post_id, multiple, closes_at) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, query, postID, poll.Multiple, poll.ClosesAt); err != nil {
+		return err
+	}
+
+	for i := range poll.Options {
+		query := `INSERT INTO poll_options (post_id, position, text) VALUES ($1, $2, $3) RETURNING id`
+		if err := tx.QueryRowContext(ctx, query, postID, i, poll.Options[i].Text).Scan(&poll.Options[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByPostIDs returns the polls of the given posts as seen by userID.
+func (s *PollStore) GetByPostIDs(ctx context.Context, userID int64, postIDs []int64) (map[int64]*Poll, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		SELECT p.post_id, p.multiple, p.closes_at, p.closes_at <= NOW(),
+			(SELECT COUNT(*) FROM poll_ballots b WHERE b.post_id = p.post_id) AS voters_count
+		FROM polls p
+		WHERE p.post_id = ANY($1)
+	`
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	polls := make(map[int64]*Poll)
+	voters := make(map[int64]int)
+	for rows.Next() {
+		var postID int64
+		var count int
+		poll := &Poll{Options: []PollOption{}}
+		if err := rows.Scan(&postID, &poll.Multiple, &poll.ClosesAt, &poll.Closed, &count); err != nil {
+			return nil, err
+		}
+		polls[postID] = poll
+		voters[postID] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(polls) == 0 {
+		return polls, nil
+	}
+
+	query = `SELECT post_id, option_id FROM poll_votes WHERE user_id = $1 AND post_id = ANY($2) ORDER BY option_id`
+	rows, err = s.db.QueryContext(ctx, query, userID, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID, optionID int64
+		if err := rows.Scan(&postID, &optionID); err != nil {
+			return nil, err
+		}
+		poll := polls[postID]
+		poll.Voted = true
+		poll.Choices = append(poll.Choices, optionID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT o.post_id, o.id, o.text, (SELECT COUNT(*) FROM poll_votes v WHERE v.option_id = o.id) AS votes
+		FROM poll_options o
+		WHERE o.post_id = ANY($1)
+		ORDER BY o.post_id, o.position
+	`
+	rows, err = s.db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID int64
+		var option PollOption
+		var votes int
+		if err := rows.Scan(&postID, &option.ID, &option.Text, &votes); err != nil {
+			return nil, err
+		}
+		poll := polls[postID]
+		if poll.Voted || poll.Closed {
+			option.Votes = &votes
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for postID, poll := range polls {
+		if poll.Voted || poll.Closed {
+			count := voters[postID]
+			poll.VotersCount = &count
+		}
+	}
+	return polls, nil
+}
+
+// Vote casts the user's vote for the given options. Voting again for the
+// same options changes nothing; voting for others returns ErrAlreadyVoted.
+func (s *PollStore) Vote(ctx context.Context, postID, userID int64, optionIDs []int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `SELECT multiple, closes_at > NOW() FROM polls WHERE post_id = $1`
+		var multiple, open bool
+		if err := tx.QueryRowContext(ctx, query, postID).Scan(&multiple, &open); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNoRecord
+			default:
+				return err
+			}
+		}
+		if !open {
+			return ErrPollClosed
+		}
+		if !multiple && len(optionIDs) != 1 {
+			return ErrInvalidVote
+		}
+
+		query = `SELECT COUNT(*) FROM poll_options WHERE post_id = $1 AND id = ANY($2)`
+		var valid int
+		if err := tx.QueryRowContext(ctx, query, postID, pq.Array(optionIDs)).Scan(&valid); err != nil {
+			return err
+		}
+		if valid != len(optionIDs) {
+			return ErrInvalidVote
+		}
+
+		// A concurrent vote of the same user waits here for this one to
+		// commit, then finds the ballot taken.
+		query = `INSERT INTO poll_ballots (post_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		res, err := tx.ExecContext(ctx, query, postID, userID)
+		if err != nil {
+			return err
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if inserted == 0 {
+			query := `SELECT option_id FROM poll_votes WHERE post_id = $1 AND user_id = $2 ORDER BY option_id`
+			rows, err := tx.QueryContext(ctx, query, postID, userID)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			var choices []int64
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					return err
+				}
+				choices = append(choices, id)
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			wanted := slices.Clone(optionIDs)
+			slices.Sort(wanted)
+			if !slices.Equal(choices, wanted) {
+				return ErrAlreadyVoted
+			}
+			return nil
+		}
+
+		query = `
+			INSERT INTO poll_votes (post_id, user_id, option_id)
+			SELECT $1::bigint, $2::bigint, UNNEST($3::bigint[])
+		`
+		_, err = tx.ExecContext(ctx, query, postID, userID, pq.Array(optionIDs))
+		return err
+	})
+}
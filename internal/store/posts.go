@@ -4,27 +4,53 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
+	"time"
 
 	"github.com/lib/pq"
 )
 
 type Post struct {
-	ID        int64     `json:"id"`
-	Content   string    `json:"content"`
-	Title     string    `json:"title"`
-	UserID    int64     `json:"user_id"`
-	Tags      []string  `json:"tags"`
-	CreatedAt string    `json:"created_at"`
-	UpdatedAt string    `json:"updated_at"`
-	Version   int       `json:"version"`
-	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	ID          int64     `json:"id"`
+	Content     string    `json:"content"`
+	Title       string    `json:"title"`
+	UserID      int64     `json:"user_id"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   string    `json:"created_at"`
+	UpdatedAt   string    `json:"updated_at"`
+	PublishAt   *string   `json:"publish_at,omitempty"`
+	PublishedAt *string   `json:"published_at"`
+	DeletedAt   *string   `json:"deleted_at,omitempty"`
+	HiddenAt    *string   `json:"hidden_at,omitempty"`
+	Version     int       `json:"version"`
+	Edited      bool      `json:"edited"`
+	Comments    []Comment `json:"comments"`
+	User        User      `json:"user"`
+	// Attachments, when set on a post being created or updated, lists by ID
+	// the uploads the post should have. It is nil when they were not loaded.
+	Attachments []Attachment `json:"attachments"`
+	// LinkPreviews describes the pages the content links to, once fetched.
+	LinkPreviews []LinkPreview `json:"link_previews"`
+	// QuoteOfID refers to the post this one quotes. Quoted is only loaded
+	// while that post is visible, so it can be missing even when QuoteOfID
+	// is set; once the original is purged, QuoteOfID is cleared too.
+	QuoteOfID    *int64 `json:"quote_of_id,omitempty"`
+	Quoted       *Post  `json:"quoted_post,omitempty"`
+	RepostsCount int    `json:"reposts_count"`
+	QuotesCount  int    `json:"quotes_count"`
+	// CommunityID is set on posts made in a community.
+	CommunityID *int64 `json:"community_id,omitempty"`
+	// Bookmarked tells whether the user reading the post bookmarked it.
+	Bookmarked bool `json:"bookmarked"`
+	// Poll, when set on a post being created, is created along with it.
+	Poll *Poll `json:"poll,omitempty"`
 }
 
 type PostWithMetadata struct {
 	Post
 	CommentsCount int `json:"comments_count"`
+	// RepostedBy lists the usernames of the people in the feed who
+	// reposted the post, most recent first.
+	RepostedBy []string `json:"reposted_by,omitempty"`
 }
 
 type PostStore struct {
@@ -32,25 +58,54 @@ type PostStore struct {
 }
 
 func (s *PostStore) Create(ctx context.Context, post *Post) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := s.create(ctx, tx, post); err != nil {
+			return err
+		}
+
+		if err := s.attach(ctx, tx, post); err != nil {
+			return err
+		}
+
+		if post.Poll != nil {
+			if err := createPoll(ctx, tx, post.ID, post.Poll); err != nil {
+				return err
+			}
+		}
+
+		return createPostRevision(ctx, tx, post, post.UserID)
+	})
+}
+
+func (s *PostStore) create(ctx context.Context, tx *sql.Tx, post *Post) error {
 	query := `
-		INSERT INTO posts (content, title, user_id, tags)
-		VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at
+		INSERT INTO posts (content, title, user_id, tags, publish_at, published_at, quote_of_id, community_id)
+		VALUES (
+			$1, $2, $3, $4, $5,
+			CASE WHEN $5::timestamptz IS NULL OR $5::timestamptz <= NOW() THEN NOW() END,
+			$6, $7
+		) RETURNING id, created_at, updated_at, published_at, version
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	row := s.db.QueryRowContext(
+	row := tx.QueryRowContext(
 		ctx,
 		query,
 		post.Content,
 		post.Title,
 		post.UserID,
 		pq.Array(post.Tags),
+		post.PublishAt,
+		post.QuoteOfID,
+		post.CommunityID,
 	)
 	err := row.Scan(
 		&post.ID,
 		&post.CreatedAt,
 		&post.UpdatedAt,
+		&post.PublishedAt,
+		&post.Version,
 	)
 	if err != nil {
 		return err
@@ -59,15 +114,28 @@ func (s *PostStore) Create(ctx context.Context, post *Post) error {
 }
 
 func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
+	return s.getByID(ctx, id, false)
+}
+
+// GetDeletedByID returns a post that is in the trash.
+func (s *PostStore) GetDeletedByID(ctx context.Context, id int64) (*Post, error) {
+	return s.getByID(ctx, id, true)
+}
+
+func (s *PostStore) getByID(ctx context.Context, id int64, deleted bool) (*Post, error) {
 	query := `
-		SELECT id, content, title, user_id, tags, created_at, updated_at, version
-		FROM posts
-		WHERE id = $1
+		SELECT p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, p.publish_at, p.published_at, p.deleted_at, p.hidden_at, p.version,
+			p.quote_of_id, p.community_id,
+			(SELECT COUNT(*) FROM reposts r WHERE r.post_id = p.id) AS reposts_count,
+			(SELECT COUNT(*) FROM posts q WHERE q.quote_of_id = p.id AND q.deleted_at IS NULL) AS quotes_count
+		FROM posts p
+		JOIN users u ON u.id = p.user_id AND u.deleted_at IS NULL
+		WHERE p.id = $1 AND (p.deleted_at IS NOT NULL) = $2
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	row := s.db.QueryRowContext(ctx, query, id)
+	row := s.db.QueryRowContext(ctx, query, id, deleted)
 	var post Post
 	err := row.Scan(
 		&post.ID,
@@ -77,7 +145,15 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 		pq.Array(&post.Tags),
 		&post.CreatedAt,
 		&post.UpdatedAt,
+		&post.PublishAt,
+		&post.PublishedAt,
+		&post.DeletedAt,
+		&post.HiddenAt,
 		&post.Version,
+		&post.QuoteOfID,
+		&post.CommunityID,
+		&post.RepostsCount,
+		&post.QuotesCount,
 	)
 	if err != nil {
 		switch {
@@ -87,17 +163,81 @@ func (s *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 			return nil, err
 		}
 	}
+	post.Edited = post.Version > 0
 	return &post, nil
 }
 
-func (s *PostStore) Update(ctx context.Context, post *Post) error {
+// GetDeletedByUserID lists the trash of a user, most recently deleted first.
+func (s *PostStore) GetDeletedByUserID(ctx context.Context, userID int64) ([]Post, error) {
+	query := `
+		SELECT id, content, title, user_id, tags, created_at, updated_at, published_at, deleted_at, version
+		FROM posts
+		WHERE user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(
+			&post.ID,
+			&post.Content,
+			&post.Title,
+			&post.UserID,
+			pq.Array(&post.Tags),
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.PublishedAt,
+			&post.DeletedAt,
+			&post.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Edited = post.Version > 0
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// Update writes the post and records the new state as a revision attributed
+// to editorID, both in the same transaction.
+func (s *PostStore) Update(ctx context.Context, post *Post, editorID int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := s.update(ctx, tx, post); err != nil {
+			return err
+		}
+
+		if err := s.attach(ctx, tx, post); err != nil {
+			return err
+		}
+
+		return createPostRevision(ctx, tx, post, editorID)
+	})
+}
+
+func (s *PostStore) update(ctx context.Context, tx *sql.Tx, post *Post) error {
 	query := `
 		UPDATE posts
 		SET title = $1, content = $2, tags = $3, updated_at = NOW(), version = version + 1
-		WHERE id = $4 AND version = $5
-		RETURNING version
+		WHERE id = $4 AND version = $5 AND deleted_at IS NULL
+		RETURNING version, updated_at
 	`
-	err := s.db.QueryRowContext(
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
 		ctx,
 		query,
 		post.Title,
@@ -105,22 +245,116 @@ func (s *PostStore) Update(ctx context.Context, post *Post) error {
 		pq.Array(post.Tags),
 		post.ID,
 		post.Version,
-	).Scan(&post.Version)
+	).Scan(&post.Version, &post.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrNoRecord
+			return s.missingOrConflict(ctx, tx, post.ID)
 		default:
 			return err
 		}
 	}
+	post.Edited = true
+	return nil
+}
+
+// attach gives the post the attachments listed by ID in post.Attachments,
+// and fills them in. Nothing changes when post.Attachments is nil.
+func (s *PostStore) attach(ctx context.Context, tx *sql.Tx, post *Post) error {
+	if post.Attachments == nil {
+		return nil
+	}
+
+	ids := make([]int64, len(post.Attachments))
+	for i, a := range post.Attachments {
+		ids[i] = a.ID
+	}
+
+	attachments, err := setPostAttachments(ctx, tx, post.ID, post.UserID, ids)
+	if err != nil {
+		return err
+	}
+	post.Attachments = attachments
 	return nil
 }
 
-func (s *PostStore) Delete(ctx context.Context, id int64) error {
+// missingOrConflict tells apart a post that no longer exists from one whose
+// version moved on, after a versioned write matched no rows.
+func (s *PostStore) missingOrConflict(ctx context.Context, tx *sql.Tx, id int64) error {
+	query := `SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return ErrEditConflict
+}
+
+// Delete moves the post to the trash. It stays restorable until the retention
+// job purges it.
+func (s *PostStore) Delete(ctx context.Context, id int64, version int) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `
+			UPDATE posts
+			SET deleted_at = NOW()
+			WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, id, version)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return s.missingOrConflict(ctx, tx, id)
+		}
+		return nil
+	})
+}
+
+func (s *PostStore) Restore(ctx context.Context, id int64) error {
 	query := `
-		DELETE FROM posts
-		WHERE id = $1
+		UPDATE posts
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Hide takes the post out of view as a moderation action. Unlike trashing it,
+// this is not something its author can undo.
+func (s *PostStore) Hide(ctx context.Context, id int64) error {
+	query := `
+		UPDATE posts
+		SET hidden_at = COALESCE(hidden_at, NOW())
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -140,25 +374,75 @@ func (s *PostStore) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
+// PurgeDeleted hard-deletes posts trashed before the given time. Comments and
+// revisions go with them through their foreign keys.
+func (s *PostStore) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM posts
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetUserFeed lists the posts of the user and of the people they follow,
+// along with the posts those people reposted. A post reposted by several of
+// them shows once, at the time of its latest repost.
 func (s *PostStore) GetUserFeed(ctx context.Context, userID int64, p PaginatedFeedQuery) ([]PostWithMetadata, error) {
 	query := `
-		SELECT p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, u.username, COUNT(c.id) AS comments_count
-		FROM posts p
-		JOIN followers f ON f.user_id = p.user_id OR p.user_id = $1
-		LEFT JOIN users u ON u.id = p.user_id
-		LEFT JOIN comments c ON c.post_id = p.id
-		WHERE 
+		WITH authors AS (
+			SELECT $1::bigint AS user_id
+			UNION
+			SELECT user_id FROM followers WHERE follower_id = $1
+		), entries AS (
+			SELECT p.id AS post_id, p.updated_at AS activity_at, NULL::bigint AS reposter_id
+			FROM posts p
+			JOIN authors a ON a.user_id = p.user_id
+			UNION ALL
+			SELECT r.post_id, r.created_at, r.user_id
+			FROM reposts r
+			JOIN authors a ON a.user_id = r.user_id
+		), items AS (
+			SELECT
+				post_id,
+				MAX(activity_at) AS activity_at,
+				ARRAY_AGG(reposter_id ORDER BY activity_at DESC) FILTER (WHERE reposter_id IS NOT NULL) AS reposter_ids
+			FROM entries
+			GROUP BY post_id
+		)
+		SELECT p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, p.version, p.quote_of_id, p.community_id, u.username,
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+			(SELECT COUNT(*) FROM reposts r WHERE r.post_id = p.id) AS reposts_count,
+			(SELECT COUNT(*) FROM posts q WHERE q.quote_of_id = p.id AND q.deleted_at IS NULL) AS quotes_count,
+			ARRAY(
+				SELECT ru.username
+				FROM UNNEST(i.reposter_ids) WITH ORDINALITY AS ri(id, n)
+				JOIN users ru ON ru.id = ri.id AND ru.deleted_at IS NULL
+				ORDER BY ri.n
+			) AS reposted_by
+		FROM items i
+		JOIN posts p ON p.id = i.post_id
+		JOIN users u ON u.id = p.user_id
+		WHERE
+			p.published_at IS NOT NULL AND
+			p.deleted_at IS NULL AND
+			p.hidden_at IS NULL AND
+			u.deleted_at IS NULL AND
 			(p.title ILIKE '%' || $4 || '%' OR p.content ILIKE '%' || $4 || '%') AND
 			(p.tags @> $5 OR $5 = '{}')
-		GROUP BY p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, u.username
-		ORDER BY p.updated_at ` + p.Sort + `
+		ORDER BY i.activity_at ` + p.Sort + `, p.id ` + p.Sort + `
 		LIMIT $2 OFFSET $3
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	fmt.Println(p)
-
 	rows, err := s.db.QueryContext(ctx, query, userID, p.Limit, p.Offset, p.Search, pq.Array(p.Tags))
 	if err != nil {
 		return nil, err
@@ -176,12 +460,81 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userID int64, p PaginatedFe
 			pq.Array(&post.Tags),
 			&post.CreatedAt,
 			&post.UpdatedAt,
+			&post.Version,
+			&post.QuoteOfID,
+			&post.CommunityID,
+			&post.User.Username,
+			&post.CommentsCount,
+			&post.RepostsCount,
+			&post.QuotesCount,
+			pq.Array(&post.RepostedBy),
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Edited = post.Version > 0
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetCommunityFeed lists the posts made in the community, most recently
+// published first unless p.Sort says otherwise.
+func (s *PostStore) GetCommunityFeed(ctx context.Context, communityID int64, p PaginatedFeedQuery) ([]PostWithMetadata, error) {
+	query := `
+		SELECT p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, p.published_at, p.version, p.quote_of_id, p.community_id, u.username,
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+			(SELECT COUNT(*) FROM reposts r WHERE r.post_id = p.id) AS reposts_count,
+			(SELECT COUNT(*) FROM posts q WHERE q.quote_of_id = p.id AND q.deleted_at IS NULL) AS quotes_count
+		FROM posts p
+		JOIN users u ON u.id = p.user_id
+		WHERE
+			p.community_id = $1 AND
+			p.published_at IS NOT NULL AND
+			p.deleted_at IS NULL AND
+			p.hidden_at IS NULL AND
+			u.deleted_at IS NULL AND
+			(p.title ILIKE '%' || $4 || '%' OR p.content ILIKE '%' || $4 || '%') AND
+			(p.tags @> $5 OR $5 = '{}')
+		ORDER BY p.published_at ` + p.Sort + `, p.id ` + p.Sort + `
+		LIMIT $2 OFFSET $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, communityID, p.Limit, p.Offset, p.Search, pq.Array(p.Tags))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []PostWithMetadata{}
+	for rows.Next() {
+		var post PostWithMetadata
+		err := rows.Scan(
+			&post.ID,
+			&post.Content,
+			&post.Title,
+			&post.UserID,
+			pq.Array(&post.Tags),
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.PublishedAt,
+			&post.Version,
+			&post.QuoteOfID,
+			&post.CommunityID,
 			&post.User.Username,
 			&post.CommentsCount,
+			&post.RepostsCount,
+			&post.QuotesCount,
 		)
 		if err != nil {
 			return nil, err
 		}
+		post.Edited = post.Version > 0
 		posts = append(posts, post)
 	}
 	if err := rows.Err(); err != nil {
@@ -189,3 +542,87 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userID int64, p PaginatedFe
 	}
 	return posts, nil
 }
+
+// GetPublishedByIDs returns, by ID, those of the given posts that anyone can
+// see: published, and neither deleted nor hidden.
+func (s *PostStore) GetPublishedByIDs(ctx context.Context, ids []int64) (map[int64]*Post, error) {
+	query := `
+		SELECT p.id, p.content, p.title, p.user_id, p.tags, p.created_at, p.updated_at, p.published_at, p.version, u.username
+		FROM posts p
+		JOIN users u ON u.id = p.user_id AND u.deleted_at IS NULL
+		WHERE p.id = ANY($1) AND p.published_at IS NOT NULL AND p.deleted_at IS NULL AND p.hidden_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make(map[int64]*Post, len(ids))
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(
+			&post.ID,
+			&post.Content,
+			&post.Title,
+			&post.UserID,
+			pq.Array(&post.Tags),
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.PublishedAt,
+			&post.Version,
+			&post.User.Username,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Edited = post.Version > 0
+		posts[post.ID] = &post
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// PublishDue makes up to limit scheduled posts whose publish_at has passed
+// visible and returns their IDs. Rows are claimed with SKIP LOCKED so that
+// several API replicas can run the publisher concurrently.
+func (s *PostStore) PublishDue(ctx context.Context, limit int) ([]int64, error) {
+	query := `
+		UPDATE posts
+		SET published_at = NOW(), updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM posts
+			WHERE published_at IS NULL AND publish_at <= NOW() AND deleted_at IS NULL
+			ORDER BY publish_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
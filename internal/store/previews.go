@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LinkPreview is the Open Graph or Twitter card metadata of a page linked
+// from a post.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name"`
+}
+
+type LinkPreviewStore struct {
+	db *sql.DB
+}
+
+// SetPostLinks records the URLs a post links to, replacing the previous
+// ones, and queues a fetch for each URL without a fresh cached preview.
+func (s *LinkPreviewStore) SetPostLinks(ctx context.Context, postID int64, urls []string) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `DELETE FROM post_links WHERE post_id = $1`
+		if _, err := tx.ExecContext(ctx, query, postID); err != nil {
+			return err
+		}
+
+		for i, url := range urls {
+			query := `INSERT INTO post_links (post_id, position, url) VALUES ($1, $2, $3)`
+			if _, err := tx.ExecContext(ctx, query, postID, i, url); err != nil {
+				return err
+			}
+
+			var fresh bool
+			query = `SELECT EXISTS (SELECT 1 FROM link_previews WHERE url = $1 AND expires_at > NOW())`
+			if err := tx.QueryRowContext(ctx, query, url).Scan(&fresh); err != nil {
+				return err
+			}
+			if !fresh {
+				if err := enqueueJob(ctx, tx, JobUnfurlLink, LinkJob{URL: url}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// GetByPostIDs returns the previews available for the links of each of the
+// given posts, in the order the links appear. Links whose preview failed or
+// is not fetched yet are left out.
+func (s *LinkPreviewStore) GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]LinkPreview, error) {
+	query := `
+		SELECT pl.post_id, lp.url, lp.title, lp.description, lp.image_url, lp.site_name
+		FROM post_links pl
+		JOIN link_previews lp ON lp.url = pl.url AND lp.status = 'ready'
+		WHERE pl.post_id = ANY($1)
+		ORDER BY pl.post_id, pl.position
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	previews := make(map[int64][]LinkPreview, len(postIDs))
+	for _, id := range postIDs {
+		previews[id] = []LinkPreview{}
+	}
+	for rows.Next() {
+		var postID int64
+		var p LinkPreview
+		if err := rows.Scan(&postID, &p.URL, &p.Title, &p.Description, &p.ImageURL, &p.SiteName); err != nil {
+			return nil, err
+		}
+		previews[postID] = append(previews[postID], p)
+	}
+	return previews, rows.Err()
+}
+
+// IsFresh reports whether the cached preview of url, successful or not, has
+// not expired yet.
+func (s *LinkPreviewStore) IsFresh(ctx context.Context, url string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM link_previews WHERE url = $1 AND expires_at > NOW())`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var fresh bool
+	err := s.db.QueryRowContext(ctx, query, url).Scan(&fresh)
+	return fresh, err
+}
+
+// Save caches a fetched preview until expiresAt.
+func (s *LinkPreviewStore) Save(ctx context.Context, preview *LinkPreview, expiresAt time.Time) error {
+	query := `
+		INSERT INTO link_previews (url, status, title, description, image_url, site_name, error, fetched_at, expires_at)
+		VALUES ($1, 'ready', $2, $3, $4, $5, '', NOW(), $6)
+		ON CONFLICT (url) DO UPDATE
+		SET status = 'ready', title = EXCLUDED.title, description = EXCLUDED.description,
+			image_url = EXCLUDED.image_url, site_name = EXCLUDED.site_name, error = '',
+			fetched_at = NOW(), expires_at = EXCLUDED.expires_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		preview.URL,
+		preview.Title,
+		preview.Description,
+		preview.ImageURL,
+		preview.SiteName,
+		expiresAt,
+	)
+	return err
+}
+
+// SaveFailure caches that url has no usable preview until expiresAt. A
+// preview fetched earlier is kept, so that a passing outage does not take it
+// away.
+func (s *LinkPreviewStore) SaveFailure(ctx context.Context, url, reason string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO link_previews (url, status, error, fetched_at, expires_at)
+		VALUES ($1, 'failed', $2, NOW(), $3)
+		ON CONFLICT (url) DO UPDATE
+		SET error = EXCLUDED.error, fetched_at = NOW(), expires_at = EXCLUDED.expires_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, url, truncateError(reason), expiresAt)
+	return err
+}
+
+// PurgeUnused drops cached previews that expired before the given time and
+// that no post links to anymore.
+func (s *LinkPreviewStore) PurgeUnused(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM link_previews lp
+		WHERE lp.expires_at < $1 AND NOT EXISTS (SELECT 1 FROM post_links pl WHERE pl.url = lp.url)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,441 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+var ErrReportClosed = errors.New("report is already closed")
+
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+	ReportTargetUser    = "user"
+
+	ReportStatusOpen      = "open"
+	ReportStatusInReview  = "in_review"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+
+	ModerationAssign  = "assign"
+	ModerationHide    = "hide"
+	ModerationDelete  = "delete"
+	ModerationWarn    = "warn"
+	ModerationBan     = "ban"
+	ModerationDismiss = "dismiss"
+)
+
+// ReportResolutions lists the resolution actions that make sense for each
+// target type.
+var ReportResolutions = map[string][]string{
+	ReportTargetPost:    {ModerationHide, ModerationDelete, ModerationWarn, ModerationBan, ModerationDismiss},
+	ReportTargetComment: {ModerationHide, ModerationDelete, ModerationWarn, ModerationBan, ModerationDismiss},
+	ReportTargetUser:    {ModerationDelete, ModerationWarn, ModerationBan, ModerationDismiss},
+}
+
+type Report struct {
+	ID            int64              `json:"id"`
+	ReporterID    *int64             `json:"reporter_id"`
+	TargetType    string             `json:"target_type"`
+	TargetID      int64              `json:"target_id"`
+	TargetOwnerID *int64             `json:"target_owner_id"`
+	Reason        string             `json:"reason"`
+	Status        string             `json:"status"`
+	AssigneeID    *int64             `json:"assignee_id"`
+	Resolution    *string            `json:"resolution"`
+	CreatedAt     string             `json:"created_at"`
+	UpdatedAt     string             `json:"updated_at"`
+	Actions       []ModerationAction `json:"actions,omitempty"`
+}
+
+// ModerationDecision is what a moderator chose to do about a report. The ban
+// fields only apply to the ban action.
+type ModerationDecision struct {
+	Action       string
+	Note         string
+	BanPermanent bool
+	BanExpiresAt *string
+}
+
+type ModerationAction struct {
+	ID         int64  `json:"id"`
+	ReportID   int64  `json:"report_id"`
+	ActorID    *int64 `json:"actor_id"`
+	Action     string `json:"action"`
+	Note       string `json:"note"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type ReportQueueQuery struct {
+	Status     string `json:"status" validate:"oneof=open in_review resolved dismissed"`
+	TargetType string `json:"target_type" validate:"omitempty,oneof=post comment user"`
+	AssigneeID int64  `json:"assignee_id" validate:"gte=0"`
+	Limit      int    `json:"limit" validate:"gte=1,lte=50"`
+	Offset     int    `json:"offset" validate:"gte=0"`
+}
+
+func (q *ReportQueueQuery) Parse(r *http.Request) error {
+	qs := r.URL.Query()
+
+	if status := qs.Get("status"); status != "" {
+		q.Status = status
+	}
+
+	if targetType := qs.Get("target_type"); targetType != "" {
+		q.TargetType = targetType
+	}
+
+	if assignee := qs.Get("assignee_id"); assignee != "" {
+		assigneeID, err := strconv.ParseInt(assignee, 10, 64)
+		if err != nil {
+			return err
+		}
+		q.AssigneeID = assigneeID
+	}
+
+	if limit := qs.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		q.Limit = limitInt
+	}
+
+	if offset := qs.Get("offset"); offset != "" {
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+		q.Offset = offsetInt
+	}
+
+	return nil
+}
+
+type ReportStore struct {
+	db *sql.DB
+}
+
+const reportColumns = `
+	r.id, r.reporter_id, r.target_type, r.target_id,
+	CASE r.target_type
+		WHEN 'post' THEN (SELECT user_id FROM posts WHERE id = r.target_id)
+		WHEN 'comment' THEN (SELECT user_id FROM comments WHERE id = r.target_id)
+		ELSE r.target_id
+	END,
+	r.reason, r.status, r.assignee_id, r.resolution, r.created_at, r.updated_at
+`
+
+func scanReport(row interface{ Scan(...any) error }, report *Report) error {
+	return row.Scan(
+		&report.ID,
+		&report.ReporterID,
+		&report.TargetType,
+		&report.TargetID,
+		&report.TargetOwnerID,
+		&report.Reason,
+		&report.Status,
+		&report.AssigneeID,
+		&report.Resolution,
+		&report.CreatedAt,
+		&report.UpdatedAt,
+	)
+}
+
+func (s *ReportStore) Create(ctx context.Context, report *Report) error {
+	query := `
+		INSERT INTO reports (reporter_id, target_type, target_id, reason)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, status, created_at, updated_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		report.ReporterID,
+		report.TargetType,
+		report.TargetID,
+		report.Reason,
+	).Scan(
+		&report.ID,
+		&report.Status,
+		&report.CreatedAt,
+		&report.UpdatedAt,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *ReportStore) GetByID(ctx context.Context, id int64) (*Report, error) {
+	query := `SELECT ` + reportColumns + ` FROM reports r WHERE r.id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	report := &Report{}
+	if err := scanReport(s.db.QueryRowContext(ctx, query, id), report); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// GetQueue lists reports for moderators, oldest first so nothing starves.
+func (s *ReportStore) GetQueue(ctx context.Context, q ReportQueueQuery) ([]Report, error) {
+	query := `SELECT ` + reportColumns + `
+		FROM reports r
+		WHERE
+			r.status = $1 AND
+			(r.target_type = $2 OR $2 = '') AND
+			(r.assignee_id = $3 OR $3 = 0)
+		ORDER BY r.created_at ASC
+		LIMIT $4 OFFSET $5
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, q.Status, q.TargetType, q.AssigneeID, q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var report Report
+		if err := scanReport(rows, &report); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+func (s *ReportStore) GetActions(ctx context.Context, reportID int64) ([]ModerationAction, error) {
+	query := `
+		SELECT id, report_id, actor_id, action, note, from_status, to_status, created_at
+		FROM moderation_actions
+		WHERE report_id = $1
+		ORDER BY id ASC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ModerationAction{}
+	for rows.Next() {
+		var action ModerationAction
+		err := rows.Scan(
+			&action.ID,
+			&action.ReportID,
+			&action.ActorID,
+			&action.Action,
+			&action.Note,
+			&action.FromStatus,
+			&action.ToStatus,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// Assign hands a pending report to a moderator and moves it into review.
+func (s *ReportStore) Assign(ctx context.Context, reportID, actorID, assigneeID int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		status, err := s.lockPending(ctx, tx, reportID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE reports
+			SET assignee_id = $2, status = $3, updated_at = NOW()
+			WHERE id = $1
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, reportID, assigneeID, ReportStatusInReview); err != nil {
+			return err
+		}
+
+		note := "assigned to user " + strconv.FormatInt(assigneeID, 10)
+		return s.createAction(ctx, tx, reportID, actorID, ModerationAssign, note, status, ReportStatusInReview)
+	})
+}
+
+// Resolve applies a moderation decision to the report target and closes the
+// report together with every other pending report on the same target. The
+// decision and its side effects commit or roll back as one.
+func (s *ReportStore) Resolve(ctx context.Context, report *Report, actorID int64, decision ModerationDecision) error {
+	action, note := decision.Action, decision.Note
+
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := s.lockPending(ctx, tx, report.ID); err != nil {
+			return err
+		}
+
+		if err := s.applyAction(ctx, tx, report, actorID, decision); err != nil {
+			return err
+		}
+
+		toStatus := ReportStatusResolved
+		if action == ModerationDismiss {
+			toStatus = ReportStatusDismissed
+		}
+
+		query := `
+			UPDATE reports r
+			SET status = $3, resolution = $4, updated_at = NOW()
+			FROM (
+				SELECT id, status FROM reports
+				WHERE target_type = $1 AND target_id = $2 AND status IN ('open', 'in_review')
+				FOR UPDATE
+			) prev
+			WHERE r.id = prev.id
+			RETURNING r.id, prev.status
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		rows, err := tx.QueryContext(ctx, query, report.TargetType, report.TargetID, toStatus, action)
+		if err != nil {
+			return err
+		}
+
+		type closed struct {
+			id         int64
+			fromStatus string
+		}
+		var closedReports []closed
+		for rows.Next() {
+			var c closed
+			if err := rows.Scan(&c.id, &c.fromStatus); err != nil {
+				rows.Close()
+				return err
+			}
+			closedReports = append(closedReports, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, c := range closedReports {
+			if err := s.createAction(ctx, tx, c.id, actorID, action, note, c.fromStatus, toStatus); err != nil {
+				return err
+			}
+		}
+
+		report.Status = toStatus
+		report.Resolution = &action
+		return nil
+	})
+}
+
+func (s *ReportStore) lockPending(ctx context.Context, tx *sql.Tx, reportID int64) (string, error) {
+	query := `SELECT status FROM reports WHERE id = $1 FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var status string
+	if err := tx.QueryRowContext(ctx, query, reportID).Scan(&status); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNoRecord
+		default:
+			return "", err
+		}
+	}
+
+	if status != ReportStatusOpen && status != ReportStatusInReview {
+		return "", ErrReportClosed
+	}
+	return status, nil
+}
+
+func (s *ReportStore) applyAction(ctx context.Context, tx *sql.Tx, report *Report, actorID int64, decision ModerationDecision) error {
+	var query string
+
+	switch {
+	case decision.Action == ModerationHide && report.TargetType == ReportTargetPost:
+		query = `UPDATE posts SET hidden_at = NOW() WHERE id = $1 AND hidden_at IS NULL`
+	case decision.Action == ModerationHide && report.TargetType == ReportTargetComment:
+		query = `UPDATE comments SET hidden_at = NOW() WHERE id = $1 AND hidden_at IS NULL`
+	case decision.Action == ModerationDelete && report.TargetType == ReportTargetPost:
+		query = `UPDATE posts SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+	case decision.Action == ModerationDelete && report.TargetType == ReportTargetComment:
+		// Comments have no trash to restore them from, so deleting one
+		// hides it like everything else moderation removes. It goes for
+		// good with its post or its author.
+		query = `UPDATE comments SET hidden_at = NOW() WHERE id = $1 AND hidden_at IS NULL`
+	case decision.Action == ModerationDelete && report.TargetType == ReportTargetUser:
+		query = `UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`
+	case decision.Action == ModerationBan:
+		if report.TargetOwnerID == nil {
+			return ErrNoRecord
+		}
+		return createBan(ctx, tx, &Ban{
+			UserID:    *report.TargetOwnerID,
+			BannedBy:  &actorID,
+			Reason:    decision.Note,
+			Permanent: decision.BanPermanent,
+			ExpiresAt: decision.BanExpiresAt,
+		})
+	default:
+		// warn and dismiss only leave a trace in the audit trail.
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, report.TargetID)
+	return err
+}
+
+func (s *ReportStore) createAction(ctx context.Context, tx *sql.Tx, reportID, actorID int64, action, note, fromStatus, toStatus string) error {
+	query := `
+		INSERT INTO moderation_actions (report_id, actor_id, action, note, from_status, to_status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, reportID, actorID, action, note, fromStatus, toStatus)
+	return err
+}
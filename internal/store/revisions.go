@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+type PostRevision struct {
+	ID        int64    `json:"id"`
+	PostID    int64    `json:"post_id"`
+	Version   int      `json:"version"`
+	Title     string   `json:"title"`
+	Content   string   `json:"content"`
+	Tags      []string `json:"tags"`
+	EditedBy  *int64   `json:"edited_by"`
+	CreatedAt string   `json:"created_at"`
+}
+
+type PostRevisionStore struct {
+	db *sql.DB
+}
+
+func (s *PostRevisionStore) GetByPostID(ctx context.Context, postID int64) ([]PostRevision, error) {
+	query := `
+		SELECT id, post_id, version, title, content, tags, edited_by, created_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY version DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []PostRevision{}
+	for rows.Next() {
+		var rev PostRevision
+		err := rows.Scan(
+			&rev.ID,
+			&rev.PostID,
+			&rev.Version,
+			&rev.Title,
+			&rev.Content,
+			pq.Array(&rev.Tags),
+			&rev.EditedBy,
+			&rev.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (s *PostRevisionStore) GetByVersion(ctx context.Context, postID int64, version int) (*PostRevision, error) {
+	query := `
+		SELECT id, post_id, version, title, content, tags, edited_by, created_at
+		FROM post_revisions
+		WHERE post_id = $1 AND version = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var rev PostRevision
+	err := s.db.QueryRowContext(ctx, query, postID, version).Scan(
+		&rev.ID,
+		&rev.PostID,
+		&rev.Version,
+		&rev.Title,
+		&rev.Content,
+		pq.Array(&rev.Tags),
+		&rev.EditedBy,
+		&rev.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return &rev, nil
+}
+
+// createPostRevision snapshots the given post state. It must run in the same
+// transaction as the write that produced that state.
+func createPostRevision(ctx context.Context, tx *sql.Tx, post *Post, editorID int64) error {
+	query := `
+		INSERT INTO post_revisions (post_id, version, title, content, tags, edited_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, post.ID, post.Version, post.Title, post.Content, pq.Array(post.Tags), editorID)
+	return err
+}
@@ -19,15 +19,64 @@ type RoleStore struct {
 
 func (s *RoleStore) GetByName(ctx context.Context, name string) (*Role, error) {
 	query := `SELECT id, name, description, level FROM roles WHERE name = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
 	row := s.db.QueryRowContext(ctx, query, name)
 
 	role := &Role{}
 	err := row.Scan(&role.ID, &role.Name, &role.Description, &role.Level)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("role not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return role, nil
+}
+
+func (s *RoleStore) GetByID(ctx context.Context, id int64) (*Role, error) {
+	query := `SELECT id, name, description, level FROM roles WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	row := s.db.QueryRowContext(ctx, query, id)
+
+	role := &Role{}
+	err := row.Scan(&role.ID, &role.Name, &role.Description, &role.Level)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
 		}
 		return nil, err
 	}
 	return role, nil
 }
+
+func (s *RoleStore) List(ctx context.Context) ([]Role, error) {
+	query := `SELECT id, name, description, level FROM roles ORDER BY level ASC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.Level); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Session is a login on one device. Every token issued at login carries the
+// ID of its session, and stops working once the session is revoked.
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  string    `json:"created_at"`
+	LastSeenAt string    `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func (s *SessionStore) Create(ctx context.Context, session *Session) error {
+	query := `
+		INSERT INTO sessions (user_id, device, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, last_seen_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		session.UserID,
+		session.Device,
+		session.UserAgent,
+		session.IP,
+		session.ExpiresAt,
+	).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+}
+
+// GetActive returns the user's session if it has been neither revoked nor
+// expired.
+func (s *SessionStore) GetActive(ctx context.Context, userID, id int64) (*Session, error) {
+	query := `
+		SELECT id, user_id, device, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	session := &Session{}
+	if err := scanSession(s.db.QueryRowContext(ctx, query, id, userID), session); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// GetActiveByUserID lists the sessions the user is still logged in with,
+// most recently used first.
+func (s *SessionStore) GetActiveByUserID(ctx context.Context, userID int64) ([]Session, error) {
+	query := `
+		SELECT id, user_id, device, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC, id DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func scanSession(row interface{ Scan(...any) error }, session *Session) error {
+	return row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Device,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+	)
+}
+
+// Touch records activity on the session, at most once a minute.
+func (s *SessionStore) Touch(ctx context.Context, id int64, ip string) error {
+	query := `
+		UPDATE sessions
+		SET last_seen_at = NOW(), ip = $2
+		WHERE id = $1 AND last_seen_at < NOW() - INTERVAL '1 minute'
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, ip)
+	return err
+}
+
+// Revoke ends one of the user's active sessions.
+func (s *SessionStore) Revoke(ctx context.Context, userID, id int64) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// RevokeOthers ends every active session of the user except keepID, and
+// returns how many were ended. A keepID of 0 ends them all.
+func (s *SessionStore) RevokeOthers(ctx context.Context, userID, keepID int64) (int64, error) {
+	var n int64
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		n, err = revokeSessions(ctx, tx, userID, keepID)
+		return err
+	})
+	return n, err
+}
+
+func revokeSessions(ctx context.Context, tx *sql.Tx, userID, keepID int64) (int64, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, userID, keepID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeBefore drops sessions that expired or were revoked before the given
+// time.
+func (s *SessionStore) PurgeBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM sessions
+		WHERE expires_at < $1 OR revoked_at < $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -10,6 +10,7 @@ import (
 var (
 	ErrNoRecord          = errors.New("resource not found")
 	ErrAlreadyExists     = errors.New("resource already exists")
+	ErrEditConflict      = errors.New("resource was modified concurrently")
 	QueryTimeoutDuration = 5 * time.Second
 )
 
@@ -17,21 +18,88 @@ type Storage struct {
 	Posts interface {
 		Create(ctx context.Context, post *Post) error
 		GetByID(ctx context.Context, id int64) (*Post, error)
-		Update(ctx context.Context, post *Post) error
-		Delete(ctx context.Context, id int64) error
+		Update(ctx context.Context, post *Post, editorID int64) error
+		Delete(ctx context.Context, id int64, version int) error
 		GetUserFeed(ctx context.Context, userID int64, p PaginatedFeedQuery) ([]PostWithMetadata, error)
+		GetCommunityFeed(ctx context.Context, communityID int64, p PaginatedFeedQuery) ([]PostWithMetadata, error)
+		PublishDue(ctx context.Context, limit int) ([]int64, error)
+		GetDeletedByID(ctx context.Context, id int64) (*Post, error)
+		GetDeletedByUserID(ctx context.Context, userID int64) ([]Post, error)
+		Restore(ctx context.Context, id int64) error
+		Hide(ctx context.Context, id int64) error
+		PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+		GetPublishedByIDs(ctx context.Context, ids []int64) (map[int64]*Post, error)
+	}
+	Reposts interface {
+		Create(ctx context.Context, userID, postID int64) error
+		Delete(ctx context.Context, userID, postID int64) error
+	}
+	Bookmarks interface {
+		Save(ctx context.Context, userID, postID int64, collectionID *int64) error
+		Delete(ctx context.Context, userID, postID int64) error
+		GetBookmarked(ctx context.Context, userID int64, postIDs []int64) (map[int64]bool, error)
+		List(ctx context.Context, userID int64, q BookmarkQuery) ([]Bookmark, error)
+		CreateCollection(ctx context.Context, collection *BookmarkCollection) error
+		ListCollections(ctx context.Context, userID int64) ([]BookmarkCollection, error)
+		DeleteCollection(ctx context.Context, userID, id int64) error
+	}
+	Polls interface {
+		GetByPostIDs(ctx context.Context, userID int64, postIDs []int64) (map[int64]*Poll, error)
+		Vote(ctx context.Context, postID, userID int64, optionIDs []int64) error
+	}
+	Communities interface {
+		Create(ctx context.Context, community *Community) error
+		GetByID(ctx context.Context, id, userID int64) (*Community, error)
+		List(ctx context.Context, userID int64, q CommunityQuery) ([]Community, error)
+		Join(ctx context.Context, communityID, userID int64) error
+		Leave(ctx context.Context, communityID, userID int64) error
+		GetRole(ctx context.Context, communityID, userID int64) (string, error)
+		GetMembers(ctx context.Context, communityID int64, q CommunityQuery) ([]CommunityMember, error)
+		SetRole(ctx context.Context, communityID, userID int64, role string) error
+	}
+	Blocks interface {
+		Block(ctx context.Context, blockerID, blockedID int64) error
+		Unblock(ctx context.Context, blockerID, blockedID int64) error
+		GetBlocked(ctx context.Context, blockerID int64) ([]User, error)
+	}
+	Conversations interface {
+		Start(ctx context.Context, creatorID int64, memberIDs []int64) (*Conversation, bool, error)
+		GetForMember(ctx context.Context, id, userID int64) (*Conversation, error)
+		ListForUser(ctx context.Context, userID int64, q ConversationQuery) ([]Conversation, error)
+		Send(ctx context.Context, message *Message) error
+		GetMessages(ctx context.Context, conversationID, userID int64, q MessageQuery) ([]Message, error)
+		MarkRead(ctx context.Context, conversationID, userID int64, messageID *int64) error
+		Leave(ctx context.Context, conversationID, userID int64) error
+		GetOpenMessages(ctx context.Context, userID int64) (bool, error)
+		SetOpenMessages(ctx context.Context, userID int64, open bool) error
 	}
 	Users interface {
 		Create(ctx context.Context, tx *sql.Tx, user *User) error
 		GetByID(ctx context.Context, id int64) (*User, error)
 		GetByEmail(ctx context.Context, email string) (*User, error)
 		CreateAndInvite(ctx context.Context, user *User, token string, invitationExp time.Duration) error
+		CreateWithIdentity(ctx context.Context, user *User, identity *Identity) error
 		Activate(ctx context.Context, token string) error
 		Delete(ctx context.Context, id int64) error
+		Purge(ctx context.Context, id int64) error
+		Restore(ctx context.Context, id int64) error
+		PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+		List(ctx context.Context, q UserListQuery) ([]User, error)
+		GetByIDIncludingInactive(ctx context.Context, id int64) (*User, error)
+		SetRole(ctx context.Context, userID, roleID int64) error
+		SetActive(ctx context.Context, userID int64, active bool) error
+		ForcePasswordReset(ctx context.Context, userID int64, token string, exp time.Duration) error
+		ResetPassword(ctx context.Context, token string, password *Password) (*User, error)
+		UpdatePassword(ctx context.Context, userID, keepSessionID int64, password *Password) error
+		RequestEmailChange(ctx context.Context, userID int64, email, token string, exp time.Duration) error
+		ConfirmEmailChange(ctx context.Context, token string) (*EmailChange, error)
+		ScheduleDeletion(ctx context.Context, userID int64, token string, grace time.Duration) (time.Time, error)
+		CancelDeletion(ctx context.Context, token string) (*User, error)
 	}
 	Comments interface {
 		Create(ctx context.Context, comment *Comment) error
 		GetByPostID(ctx context.Context, postID int64) ([]Comment, error)
+		GetByID(ctx context.Context, id int64) (*Comment, error)
 	}
 	Followers interface {
 		Follow(ctx context.Context, userID, followerID int64) error
@@ -40,16 +108,148 @@ type Storage struct {
 	}
 	Roles interface {
 		GetByName(ctx context.Context, name string) (*Role, error)
+		GetByID(ctx context.Context, id int64) (*Role, error)
+		List(ctx context.Context) ([]Role, error)
+	}
+	PostRevisions interface {
+		GetByPostID(ctx context.Context, postID int64) ([]PostRevision, error)
+		GetByVersion(ctx context.Context, postID int64, version int) (*PostRevision, error)
+	}
+	Reports interface {
+		Create(ctx context.Context, report *Report) error
+		GetByID(ctx context.Context, id int64) (*Report, error)
+		GetQueue(ctx context.Context, q ReportQueueQuery) ([]Report, error)
+		GetActions(ctx context.Context, reportID int64) ([]ModerationAction, error)
+		Assign(ctx context.Context, reportID, actorID, assigneeID int64) error
+		Resolve(ctx context.Context, report *Report, actorID int64, decision ModerationDecision) error
+	}
+	Bans interface {
+		Create(ctx context.Context, ban *Ban) error
+		GetActive(ctx context.Context, userID int64) (*Ban, error)
+		GetByUserID(ctx context.Context, userID int64) ([]Ban, error)
+		Lift(ctx context.Context, userID, liftedBy int64) error
+	}
+	Permissions interface {
+		List(ctx context.Context) ([]Permission, error)
+		GetByRoleID(ctx context.Context, roleID int64) ([]Permission, error)
+		GetNamesByRoleID(ctx context.Context, roleID int64) ([]string, error)
+		Grant(ctx context.Context, roleID int64, name string) error
+		Revoke(ctx context.Context, roleID int64, name string) error
+	}
+	Audit interface {
+		Create(ctx context.Context, event *AuditEvent) error
+		Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error)
+	}
+	LoginAttempts interface {
+		Create(ctx context.Context, attempt *LoginAttempt) error
+		Reserve(ctx context.Context, attempt *LoginAttempt, since time.Time) (LoginFailures, LoginFailures, error)
+		Succeed(ctx context.Context, id int64) error
+		Delete(ctx context.Context, id int64) error
+		PurgeBefore(ctx context.Context, before time.Time) (int64, error)
+	}
+	MFA interface {
+		Enroll(ctx context.Context, userID int64, secret string) error
+		Get(ctx context.Context, userID int64) (*MFA, error)
+		Confirm(ctx context.Context, userID, step int64, recoveryCodes [][]byte) error
+		UseStep(ctx context.Context, userID, step int64) error
+		UseRecoveryCode(ctx context.Context, userID int64, codeHash []byte) error
+		ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryCodes [][]byte) error
+		Disable(ctx context.Context, userID int64) error
+	}
+	Identities interface {
+		GetUserID(ctx context.Context, provider, subject string) (int64, error)
+		Link(ctx context.Context, identity *Identity) error
+		CreateState(ctx context.Context, state *OIDCState) error
+		ConsumeState(ctx context.Context, provider, state string) (*OIDCState, error)
+		PurgeExpiredStates(ctx context.Context) (int64, error)
+	}
+	AccessTokens interface {
+		Create(ctx context.Context, token *AccessToken, hash []byte) error
+		GetByUserID(ctx context.Context, userID int64) ([]AccessToken, error)
+		GetByHash(ctx context.Context, hash []byte) (*AccessToken, error)
+		Touch(ctx context.Context, id int64) error
+		Delete(ctx context.Context, userID, id int64) error
+	}
+	Sessions interface {
+		Create(ctx context.Context, session *Session) error
+		GetActive(ctx context.Context, userID, id int64) (*Session, error)
+		GetActiveByUserID(ctx context.Context, userID int64) ([]Session, error)
+		Touch(ctx context.Context, id int64, ip string) error
+		Revoke(ctx context.Context, userID, id int64) error
+		RevokeOthers(ctx context.Context, userID, keepID int64) (int64, error)
+		PurgeBefore(ctx context.Context, before time.Time) (int64, error)
+	}
+	Exports interface {
+		Create(ctx context.Context, export *DataExport) error
+		GetByUserID(ctx context.Context, userID int64) ([]DataExport, error)
+		GetArchive(ctx context.Context, userID, id int64) ([]byte, error)
+		ClaimNext(ctx context.Context, staleAfter time.Duration) (*DataExport, error)
+		Complete(ctx context.Context, id int64, archive []byte, expiresAt time.Time) error
+		Fail(ctx context.Context, id int64, reason string) error
+		PurgeExpired(ctx context.Context) (int64, error)
+		Collect(ctx context.Context, userID int64) (*UserData, error)
+	}
+	Attachments interface {
+		Create(ctx context.Context, attachment *Attachment) error
+		GetByID(ctx context.Context, id int64) (*Attachment, error)
+		GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]Attachment, error)
+		GetVariant(ctx context.Context, attachmentID int64, name string) (*AttachmentVariant, error)
+		ReplaceAvatar(ctx context.Context, attachment *Attachment) error
+		DeleteAvatar(ctx context.Context, userID int64) error
+		GetAvatar(ctx context.Context, userID int64) (*Attachment, error)
+		StartProcessing(ctx context.Context, id int64) (*Attachment, error)
+		CompleteProcessing(ctx context.Context, attachment *Attachment) error
+		SetStatus(ctx context.Context, id int64, status string) error
+		PurgeUnattached(ctx context.Context, before time.Time) (int64, error)
+		PendingBlobDeletions(ctx context.Context, limit int) ([]string, error)
+		ClearBlobDeletion(ctx context.Context, key string) error
+	}
+	LinkPreviews interface {
+		SetPostLinks(ctx context.Context, postID int64, urls []string) error
+		GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]LinkPreview, error)
+		IsFresh(ctx context.Context, url string) (bool, error)
+		Save(ctx context.Context, preview *LinkPreview, expiresAt time.Time) error
+		SaveFailure(ctx context.Context, url, reason string, expiresAt time.Time) error
+		PurgeUnused(ctx context.Context, before time.Time) (int64, error)
+	}
+	Jobs interface {
+		Enqueue(ctx context.Context, kind string, payload any) error
+		ClaimNext(ctx context.Context, kind string, staleAfter time.Duration) (*Job, error)
+		FailStale(ctx context.Context, kind string, staleAfter time.Duration) ([]Job, error)
+		Complete(ctx context.Context, id int64) error
+		Retry(ctx context.Context, id int64, reason string, delay time.Duration) (string, error)
+		Fail(ctx context.Context, id int64, reason string) error
+		PurgeFinished(ctx context.Context, before time.Time) (int64, error)
 	}
 }
 
 func NewStorage(db *sql.DB) Storage {
 	return Storage{
-		Posts:     &PostStore{db: db},
-		Users:     &UserStore{db: db},
-		Comments:  &CommentStore{db: db},
-		Followers: &FollowerStore{db: db},
-		Roles:     &RoleStore{db: db},
+		Posts:         &PostStore{db: db},
+		Users:         &UserStore{db: db},
+		Comments:      &CommentStore{db: db},
+		Followers:     &FollowerStore{db: db},
+		Roles:         &RoleStore{db: db},
+		PostRevisions: &PostRevisionStore{db: db},
+		Reports:       &ReportStore{db: db},
+		Bans:          &BanStore{db: db},
+		Permissions:   &PermissionStore{db: db},
+		Audit:         &AuditStore{db: db},
+		LoginAttempts: &LoginAttemptStore{db: db},
+		MFA:           &MFAStore{db: db},
+		Identities:    &IdentityStore{db: db},
+		AccessTokens:  &AccessTokenStore{db: db},
+		Sessions:      &SessionStore{db: db},
+		Exports:       &ExportStore{db: db},
+		Attachments:   &AttachmentStore{db: db},
+		Jobs:          &JobStore{db: db},
+		LinkPreviews:  &LinkPreviewStore{db: db},
+		Reposts:       &RepostStore{db: db},
+		Bookmarks:     &BookmarkStore{db: db},
+		Blocks:        &BlockStore{db: db},
+		Polls:         &PollStore{db: db},
+		Conversations: &ConversationStore{db: db},
+		Communities:   &CommunityStore{db: db},
 	}
 }
 
@@ -7,6 +7,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"log"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -26,6 +29,22 @@ type User struct {
 	IsActive  bool     `json:"is_active"`
 	RoleID    int64    `json:"role_id"`
 	Role      Role     `json:"role"`
+	DeletedAt *string  `json:"deleted_at,omitempty"`
+
+	PasswordResetRequired bool `json:"password_reset_required"`
+	Banned                bool `json:"banned,omitempty"`
+	MFAEnabled            bool `json:"mfa_enabled"`
+	// HasPassword is false for users created through an OIDC provider until
+	// they set a password of their own.
+	HasPassword bool `json:"has_password"`
+}
+
+// EmailChange is a confirmed change of a user's email address.
+type EmailChange struct {
+	UserID   int64
+	Username string
+	OldEmail string
+	NewEmail string
 }
 
 type Password struct {
@@ -48,6 +67,17 @@ func (p *Password) Compare(text string) error {
 	return bcrypt.CompareHashAndPassword(p.hash, []byte(text))
 }
 
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// CompareDummyPassword burns the time of a real password check. Logins for
+// unknown emails call it so that they take as long as those for real ones.
+func CompareDummyPassword(text string) {
+	_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(text))
+}
+
 type UserStore struct {
 	db *sql.DB
 }
@@ -87,10 +117,11 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 
 func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 	query := `
-		SELECT u.id, u.username, u.email, u.created_at, u.password, r.id, r.name, r.level, r.description
+		SELECT u.id, u.username, u.email, u.created_at, u.password, u.has_password, u.is_active, u.password_reset_required, u.role_id, r.id, r.name, r.level, r.description,
+			EXISTS (SELECT 1 FROM user_mfa m WHERE m.user_id = u.id AND m.confirmed_at IS NOT NULL)
 		FROM users u
 		JOIN roles r ON r.id = u.role_id
-		WHERE u.id = $1 AND u.is_active = true
+		WHERE u.id = $1 AND u.is_active = true AND u.deleted_at IS NULL
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -103,10 +134,15 @@ func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 		&user.Email,
 		&user.CreatedAt,
 		&user.Password.hash,
+		&user.HasPassword,
+		&user.IsActive,
+		&user.PasswordResetRequired,
+		&user.RoleID,
 		&user.Role.ID,
 		&user.Role.Name,
 		&user.Role.Level,
 		&user.Role.Description,
+		&user.MFAEnabled,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -119,9 +155,10 @@ func (s *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
 
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, username, email, created_at, password
+		SELECT id, username, email, created_at, password, has_password, is_active, password_reset_required,
+			EXISTS (SELECT 1 FROM user_mfa m WHERE m.user_id = users.id AND m.confirmed_at IS NOT NULL)
 		FROM users
-		WHERE email = $1 AND is_active = true
+		WHERE email = $1 AND is_active = true AND deleted_at IS NULL
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -134,6 +171,10 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 		&user.Email,
 		&user.CreatedAt,
 		&user.Password.hash,
+		&user.HasPassword,
+		&user.IsActive,
+		&user.PasswordResetRequired,
+		&user.MFAEnabled,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -157,6 +198,34 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 	})
 }
 
+// CreateWithIdentity creates an already activated user for someone signing
+// in through an OIDC provider, whose email the provider has verified. The
+// user has no password of their own.
+func (s *UserStore) CreateWithIdentity(ctx context.Context, user *User, identity *Identity) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := s.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		user.IsActive = true
+		if err := s.update(ctx, tx, user); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `UPDATE users SET has_password = false WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return err
+		}
+		user.HasPassword = false
+
+		identity.UserID = user.ID
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
 func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, userID int64, token string, invitationExp time.Duration) error {
 	query := `
 		INSERT INTO user_invitations (user_id, token, expires_at)
@@ -199,7 +268,7 @@ func (s *UserStore) getUserByToken(ctx context.Context, tx *sql.Tx, token string
 		SELECT u.id, u.username, u.email, u.created_at, u.is_active
 		FROM users u
 		JOIN user_invitations ui ON u.id = ui.user_id
-		WHERE ui.token = $1 AND ui.expires_at > now()
+		WHERE ui.token = $1 AND ui.expires_at > now() AND u.deleted_at IS NULL
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -256,9 +325,11 @@ func (s *UserStore) deleteUserInvitations(ctx context.Context, tx *sql.Tx, userI
 	return nil
 }
 
+// Delete moves the user to the trash. Their content disappears from every
+// read until the user is restored or the retention job purges them.
 func (s *UserStore) Delete(ctx context.Context, id int64) error {
 	return withTx(ctx, s.db, func(tx *sql.Tx) error {
-		if err := s.deleteUser(ctx, tx, id); err != nil {
+		if err := s.softDeleteUser(ctx, tx, id); err != nil {
 			return err
 		}
 
@@ -269,6 +340,37 @@ func (s *UserStore) Delete(ctx context.Context, id int64) error {
 	})
 }
 
+func (s *UserStore) softDeleteUser(ctx context.Context, tx *sql.Tx, id int64) error {
+	query := `
+		UPDATE users
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Purge hard-deletes a user right away, cascading to everything they own. It
+// is meant for rolling back registrations that never completed.
+func (s *UserStore) Purge(ctx context.Context, id int64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return s.deleteUser(ctx, tx, id)
+	})
+}
+
 func (s *UserStore) deleteUser(ctx context.Context, tx *sql.Tx, id int64) error {
 	query := `
 		DELETE FROM users
@@ -283,3 +385,541 @@ func (s *UserStore) deleteUser(ctx context.Context, tx *sql.Tx, id int64) error
 	}
 	return nil
 }
+
+func (s *UserStore) Restore(ctx context.Context, id int64) error {
+	query := `
+		UPDATE users
+		SET deleted_at = NULL, purge_after = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// PurgeDeleted hard-deletes users trashed before the given time, and those
+// who deleted their own account once its grace period is over. Posts, follows
+// and invitations are removed by their foreign keys; comments on other
+// people's posts are kept without an author.
+func (s *UserStore) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE deleted_at IS NOT NULL AND (
+			(purge_after IS NULL AND deleted_at < $1) OR purge_after < NOW()
+		)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type UserListQuery struct {
+	Limit  int    `json:"limit" validate:"gte=1,lte=100"`
+	Offset int    `json:"offset" validate:"gte=0"`
+	Role   string `json:"role" validate:"max=255"`
+	Active *bool  `json:"active"`
+	Banned *bool  `json:"banned"`
+	Search string `json:"search" validate:"max=100"`
+}
+
+func (q *UserListQuery) Parse(r *http.Request) error {
+	qs := r.URL.Query()
+
+	if limit := qs.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		q.Limit = limitInt
+	}
+
+	if offset := qs.Get("offset"); offset != "" {
+		offsetInt, err := strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+		q.Offset = offsetInt
+	}
+
+	if role := qs.Get("role"); role != "" {
+		q.Role = role
+	}
+
+	if active := qs.Get("active"); active != "" {
+		activeBool, err := strconv.ParseBool(active)
+		if err != nil {
+			return err
+		}
+		q.Active = &activeBool
+	}
+
+	if banned := qs.Get("banned"); banned != "" {
+		bannedBool, err := strconv.ParseBool(banned)
+		if err != nil {
+			return err
+		}
+		q.Banned = &bannedBool
+	}
+
+	if search := qs.Get("search"); search != "" {
+		q.Search = search
+	}
+
+	return nil
+}
+
+const adminUserColumns = `
+	u.id, u.username, u.email, u.created_at, u.is_active, u.password_reset_required,
+	u.role_id, r.id, r.name, r.level, r.description,
+	EXISTS (
+		SELECT 1 FROM user_bans b
+		WHERE b.user_id = u.id AND b.lifted_at IS NULL AND (b.expires_at IS NULL OR b.expires_at > NOW())
+	)
+`
+
+func scanAdminUser(row interface{ Scan(...any) error }, user *User) error {
+	return row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.CreatedAt,
+		&user.IsActive,
+		&user.PasswordResetRequired,
+		&user.RoleID,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
+		&user.Banned,
+	)
+}
+
+// List returns users for administration, including inactive and banned ones.
+func (s *UserStore) List(ctx context.Context, q UserListQuery) ([]User, error) {
+	query := `SELECT ` + adminUserColumns + `
+		FROM users u
+		JOIN roles r ON r.id = u.role_id
+		WHERE
+			u.deleted_at IS NULL AND
+			(r.name = $3 OR $3 = '') AND
+			($4::boolean IS NULL OR u.is_active = $4) AND
+			(u.username ILIKE '%' || $5 || '%' OR u.email ILIKE '%' || $5 || '%') AND
+			($6::boolean IS NULL OR EXISTS (
+				SELECT 1 FROM user_bans b
+				WHERE b.user_id = u.id AND b.lifted_at IS NULL AND (b.expires_at IS NULL OR b.expires_at > NOW())
+			) = $6)
+		ORDER BY u.id ASC
+		LIMIT $1 OFFSET $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, q.Limit, q.Offset, q.Role, q.Active, q.Search, q.Banned)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := scanAdminUser(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetByIDIncludingInactive is GetByID for administration: it also returns
+// accounts that are not activated.
+func (s *UserStore) GetByIDIncludingInactive(ctx context.Context, id int64) (*User, error) {
+	query := `SELECT ` + adminUserColumns + `
+		FROM users u
+		JOIN roles r ON r.id = u.role_id
+		WHERE u.id = $1 AND u.deleted_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	user := &User{}
+	if err := scanAdminUser(s.db.QueryRowContext(ctx, query, id), user); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserStore) SetRole(ctx context.Context, userID, roleID int64) error {
+	query := `
+		UPDATE users
+		SET role_id = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	return s.execOne(ctx, query, userID, roleID)
+}
+
+func (s *UserStore) SetActive(ctx context.Context, userID int64, active bool) error {
+	query := `
+		UPDATE users
+		SET is_active = $2
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	return s.execOne(ctx, query, userID, active)
+}
+
+func (s *UserStore) execOne(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// ForcePasswordReset logs the user out everywhere and locks them out until
+// they pick a new password with the given (hashed) token.
+func (s *UserStore) ForcePasswordReset(ctx context.Context, userID int64, token string, exp time.Duration) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users
+			SET password_reset_required = true
+			WHERE id = $1 AND deleted_at IS NULL
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNoRecord
+		}
+
+		if err := s.deletePasswordResets(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		if _, err := revokeSessions(ctx, tx, userID, 0); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO password_resets (user_id, token, expires_at)
+			VALUES ($1, $2, $3)
+		`
+		_, err = tx.ExecContext(ctx, query, userID, token, time.Now().Add(exp))
+		return err
+	})
+}
+
+// ResetPassword sets a new password using a reset token, ending every session
+// of the user, and returns the user it belonged to.
+func (s *UserStore) ResetPassword(ctx context.Context, token string, password *Password) (*User, error) {
+	user := &User{}
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `
+			SELECT u.id, u.username, u.email
+			FROM users u
+			JOIN password_resets pr ON pr.user_id = u.id
+			WHERE pr.token = $1 AND pr.expires_at > NOW() AND u.deleted_at IS NULL
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		hash := sha256.Sum256([]byte(token))
+		hashedToken := hex.EncodeToString(hash[:])
+
+		err := tx.QueryRowContext(ctx, query, hashedToken).Scan(&user.ID, &user.Username, &user.Email)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		query = `
+			UPDATE users
+			SET password = $2, has_password = true, password_reset_required = false
+			WHERE id = $1
+		`
+		if _, err := tx.ExecContext(ctx, query, user.ID, password.hash); err != nil {
+			return err
+		}
+
+		if _, err := revokeSessions(ctx, tx, user.ID, 0); err != nil {
+			return err
+		}
+
+		return s.deletePasswordResets(ctx, tx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserStore) deletePasswordResets(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `
+		DELETE FROM password_resets
+		WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
+// UpdatePassword sets a new password and ends every session of the user but
+// keepSessionID.
+func (s *UserStore) UpdatePassword(ctx context.Context, userID, keepSessionID int64, password *Password) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users
+			SET password = $2, has_password = true
+			WHERE id = $1 AND deleted_at IS NULL
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID, password.hash)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNoRecord
+		}
+
+		_, err = revokeSessions(ctx, tx, userID, keepSessionID)
+		return err
+	})
+}
+
+// RequestEmailChange stores a pending change to the given address, to be
+// confirmed with the (hashed) token sent there. It replaces any earlier
+// request and fails with ErrDuplicateEmail if the address is taken.
+func (s *UserStore) RequestEmailChange(ctx context.Context, userID int64, email, token string, exp time.Duration) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, email).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicateEmail
+		}
+
+		if err := s.deleteEmailChanges(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO email_changes (token, user_id, new_email, expires_at)
+			VALUES ($1, $2, $3, $4)
+		`
+		_, err := tx.ExecContext(ctx, query, token, userID, email, time.Now().Add(exp))
+		return err
+	})
+}
+
+// ConfirmEmailChange applies the pending change the token was issued for.
+func (s *UserStore) ConfirmEmailChange(ctx context.Context, token string) (*EmailChange, error) {
+	change := &EmailChange{}
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `
+			SELECT u.id, u.username, u.email, ec.new_email
+			FROM users u
+			JOIN email_changes ec ON ec.user_id = u.id
+			WHERE ec.token = $1 AND ec.expires_at > NOW() AND u.deleted_at IS NULL
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		hash := sha256.Sum256([]byte(token))
+		hashedToken := hex.EncodeToString(hash[:])
+
+		err := tx.QueryRowContext(ctx, query, hashedToken).Scan(
+			&change.UserID,
+			&change.Username,
+			&change.OldEmail,
+			&change.NewEmail,
+		)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		query = `
+			UPDATE users
+			SET email = $2
+			WHERE id = $1
+		`
+		if _, err := tx.ExecContext(ctx, query, change.UserID, change.NewEmail); err != nil {
+			if err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"` {
+				return ErrDuplicateEmail
+			}
+			return err
+		}
+
+		return s.deleteEmailChanges(ctx, tx, change.UserID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func (s *UserStore) deleteEmailChanges(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `
+		DELETE FROM email_changes
+		WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
+// ScheduleDeletion moves a user who asked to delete their account to the
+// trash, logs them out everywhere and schedules the purge after grace. The
+// deletion can be cancelled until then with the given (hashed) token.
+func (s *UserStore) ScheduleDeletion(ctx context.Context, userID int64, token string, grace time.Duration) (time.Time, error) {
+	purgeAfter := time.Now().Add(grace)
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `
+			UPDATE users
+			SET deleted_at = NOW(), purge_after = $2
+			WHERE id = $1 AND deleted_at IS NULL
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID, purgeAfter)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNoRecord
+		}
+
+		if err := s.deleteUserInvitations(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		if _, err := revokeSessions(ctx, tx, userID, 0); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM access_tokens WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO account_deletions (token, user_id, expires_at)
+			VALUES ($1, $2, $3)
+		`
+		_, err = tx.ExecContext(ctx, query, token, userID, purgeAfter)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return purgeAfter, nil
+}
+
+// CancelDeletion restores a user who deleted their own account, using the
+// token they were sent, and returns them.
+func (s *UserStore) CancelDeletion(ctx context.Context, token string) (*User, error) {
+	user := &User{}
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		query := `
+			SELECT u.id, u.username, u.email
+			FROM users u
+			JOIN account_deletions ad ON ad.user_id = u.id
+			WHERE ad.token = $1 AND ad.expires_at > NOW() AND u.deleted_at IS NOT NULL
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		hash := sha256.Sum256([]byte(token))
+		hashedToken := hex.EncodeToString(hash[:])
+
+		err := tx.QueryRowContext(ctx, query, hashedToken).Scan(&user.ID, &user.Username, &user.Email)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNoRecord
+			}
+			return err
+		}
+
+		query = `
+			UPDATE users
+			SET deleted_at = NULL, purge_after = NULL
+			WHERE id = $1
+		`
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM account_deletions WHERE user_id = $1`, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,251 @@
+// Package unfurl fetches the Open Graph and Twitter card metadata of web
+// pages, to show previews of the links people post. Fetches are guarded
+// against reaching internal services, and bounded in time and size.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	ErrBlocked    = errors.New("unfurl: address is not publicly routable")
+	ErrNotHTML    = errors.New("unfurl: not an html page")
+	ErrNoMetadata = errors.New("unfurl: page has no preview metadata")
+	// ErrUnavailable reports a client error status, which retrying later is
+	// unlikely to fix.
+	ErrUnavailable = errors.New("unfurl: page is unavailable")
+)
+
+const (
+	maxTitleLen       = 300
+	maxDescriptionLen = 1000
+	maxSiteNameLen    = 200
+)
+
+type Options struct {
+	// Timeout bounds a whole fetch, redirects included.
+	Timeout time.Duration
+	// MaxBytes bounds how much of a page is read. Metadata lives in the
+	// head, so pages are rarely read to the end anyway.
+	MaxBytes     int64
+	MaxRedirects int
+	UserAgent    string
+	// AllowPrivate lets the fetcher reach loopback and private addresses,
+	// such as an httptest server. It must never be set in production.
+	AllowPrivate bool
+}
+
+// Preview is what a page says about itself.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+type Fetcher struct {
+	client    *http.Client
+	maxBytes  int64
+	userAgent string
+}
+
+func New(opts Options) *Fetcher {
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = guardDial
+	}
+
+	transport := &http.Transport{
+		// No proxy: it would be the one dialling, past the guard.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return fmt.Errorf("unfurl: stopped after %d redirects", opts.MaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unfurl: refusing to follow redirect to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+
+	return &Fetcher{
+		client:    client,
+		maxBytes:  opts.MaxBytes,
+		userAgent: opts.UserAgent,
+	}
+}
+
+// Fetch downloads the page at rawURL and extracts its preview.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+	case res.StatusCode == http.StatusRequestTimeout, res.StatusCode == http.StatusTooManyRequests, res.StatusCode >= 500:
+		return nil, fmt.Errorf("unfurl: %s returned status %d", rawURL, res.StatusCode)
+	default:
+		return nil, fmt.Errorf("%w: status %d", ErrUnavailable, res.StatusCode)
+	}
+
+	contentType := res.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	body, err := charset.NewReader(io.LimitReader(res.Body, f.maxBytes), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	preview, err := parse(body, res.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// parse reads the metadata in the head of a page. Open Graph tags win over
+// Twitter card tags, which win over the plain title and description.
+func parse(r io.Reader, base *url.URL) (*Preview, error) {
+	meta := map[string]string{}
+	var title string
+	inTitle := false
+
+	z := html.NewTokenizer(r)
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				break loop
+			}
+			return nil, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				break loop
+			case "title":
+				inTitle = true
+			case "meta":
+				if !hasAttr {
+					continue
+				}
+				var key, content string
+				for {
+					k, v, more := z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = string(v)
+					}
+					if !more {
+						break
+					}
+				}
+				if _, ok := meta[key]; key != "" && !ok {
+					meta[key] = content
+				}
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				break loop
+			}
+		}
+	}
+
+	first := func(values ...string) string {
+		for _, v := range values {
+			if v = strings.Join(strings.Fields(v), " "); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	preview := &Preview{
+		Title:       truncate(first(meta["og:title"], meta["twitter:title"], title), maxTitleLen),
+		Description: truncate(first(meta["og:description"], meta["twitter:description"], meta["description"]), maxDescriptionLen),
+		ImageURL:    resolve(base, first(meta["og:image:secure_url"], meta["og:image"], meta["twitter:image"], meta["twitter:image:src"])),
+		SiteName:    truncate(first(meta["og:site_name"], meta["application-name"]), maxSiteNameLen),
+	}
+
+	if preview.Title == "" && preview.Description == "" && preview.ImageURL == "" {
+		return nil, ErrNoMetadata
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = strings.TrimPrefix(base.Hostname(), "www.")
+	}
+	return preview, nil
+}
+
+// resolve makes an image reference absolute, dropping anything that is not
+// an http or https URL.
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.String()) > 2048 {
+		return ""
+	}
+	return u.String()
+}
+
+// truncate cuts s to at most n characters.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,257 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"::ffff:8.8.8.8", true},
+
+		{"0.0.0.0", false},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+		{"2001:db8::1", false},
+
+		// IPv4-mapped addresses are judged by the IPv4 address.
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+
+		// NAT64 and 6to4 translate to IPv4 addresses, private ones included.
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b::808:808", false},
+		{"64:ff9b:1::a00:1", false},
+		{"2002:c0a8:101::1", false},
+		{"2002:808:808::1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := publicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+				t.Errorf("publicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+			}
+		})
+	}
+}
+
+func newTestFetcher(maxBytes int64, maxRedirects int) *Fetcher {
+	return New(Options{
+		Timeout:      5 * time.Second,
+		MaxBytes:     maxBytes,
+		MaxRedirects: maxRedirects,
+		UserAgent:    "unfurl-test",
+		AllowPrivate: true,
+	})
+}
+
+// newTestServer serves pages by path. /redirect/n redirects n times before
+// landing on /page.
+func newTestServer(t *testing.T, pages map[string]string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	for path, body := range pages {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, body)
+		})
+	}
+	mux.HandleFunc("/redirect/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.PathValue("n"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		next := fmt.Sprintf("/redirect/%d", n-1)
+		if n <= 1 {
+			next = "/page"
+		}
+		http.Redirect(w, r, next, http.StatusFound)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{}`)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	})
+	mux.HandleFunc("/busy", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+const page = `<!doctype html>
+<html>
+<head>
+	<title>Plain title</title>
+	<meta name="description" content="Plain description">
+	<meta name="twitter:title" content="Twitter title">
+	<meta name="twitter:description" content="Twitter description">
+	<meta name="twitter:image" content="/twitter.png">
+	<meta property="og:title" content="  Open   Graph title ">
+	<meta property="og:image" content="/og.png">
+	<meta property="og:site_name" content="Example">
+</head>
+<body><meta property="og:description" content="Not in the head"></body>
+</html>`
+
+func TestFetch(t *testing.T) {
+	srv := newTestServer(t, map[string]string{
+		"/page": page,
+		"/plain": `<html><head><title>Plain title</title>
+			<meta name="description" content="Plain description"></head></html>`,
+	})
+
+	tests := []struct {
+		name string
+		path string
+		want Preview
+	}{
+		{
+			name: "open graph over twitter card",
+			path: "/page",
+			want: Preview{
+				Title:       "Open Graph title",
+				Description: "Twitter description",
+				ImageURL:    srv.URL + "/og.png",
+				SiteName:    "Example",
+			},
+		},
+		{
+			name: "plain title and description",
+			path: "/plain",
+			want: Preview{
+				Title:       "Plain title",
+				Description: "Plain description",
+				SiteName:    "127.0.0.1",
+			},
+		},
+		{
+			name: "image resolved against the final URL",
+			path: "/redirect/1",
+			want: Preview{
+				Title:       "Open Graph title",
+				Description: "Twitter description",
+				ImageURL:    srv.URL + "/og.png",
+				SiteName:    "Example",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preview, err := newTestFetcher(1<<20, 3).Fetch(context.Background(), srv.URL+tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.want.URL = srv.URL + tt.path
+			if *preview != tt.want {
+				t.Errorf("preview = %+v, want %+v", *preview, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchRedirectLimit(t *testing.T) {
+	srv := newTestServer(t, map[string]string{"/page": page})
+	fetcher := newTestFetcher(1<<20, 2)
+
+	if _, err := fetcher.Fetch(context.Background(), srv.URL+"/redirect/2"); err != nil {
+		t.Errorf("two redirects: %v", err)
+	}
+
+	_, err := fetcher.Fetch(context.Background(), srv.URL+"/redirect/3")
+	if err == nil || !strings.Contains(err.Error(), "stopped after 2 redirects") {
+		t.Errorf("three redirects: err = %v, want the redirect limit", err)
+	}
+}
+
+func TestFetchMaxBytes(t *testing.T) {
+	padding := `<meta name="padding" content="` + strings.Repeat("x", 2000) + `">`
+	srv := newTestServer(t, map[string]string{
+		"/early": `<html><head><meta property="og:title" content="Early">` + padding + `</head></html>`,
+		"/late":  `<html><head>` + padding + `<meta property="og:title" content="Late"></head></html>`,
+	})
+	fetcher := newTestFetcher(1024, 0)
+
+	preview, err := fetcher.Fetch(context.Background(), srv.URL+"/early")
+	if err != nil {
+		t.Fatalf("early metadata: %v", err)
+	}
+	if preview.Title != "Early" {
+		t.Errorf("title = %q, want %q", preview.Title, "Early")
+	}
+
+	if _, err := fetcher.Fetch(context.Background(), srv.URL+"/late"); !errors.Is(err, ErrNoMetadata) {
+		t.Errorf("metadata past the limit: err = %v, want %v", err, ErrNoMetadata)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	srv := newTestServer(t, map[string]string{"/empty": `<html><head></head><body>Hi</body></html>`})
+	fetcher := newTestFetcher(1<<20, 0)
+
+	tests := []struct {
+		path string
+		want error
+	}{
+		{"/json", ErrNotHTML},
+		{"/gone", ErrUnavailable},
+		{"/empty", ErrNoMetadata},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if _, err := fetcher.Fetch(context.Background(), srv.URL+tt.path); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Server errors may go away, so they are not ErrUnavailable.
+	_, err := fetcher.Fetch(context.Background(), srv.URL+"/busy")
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		t.Errorf("503: err = %v, want a retryable error", err)
+	}
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	srv := newTestServer(t, map[string]string{"/page": page})
+	fetcher := New(Options{Timeout: 5 * time.Second, MaxBytes: 1 << 20})
+
+	if _, err := fetcher.Fetch(context.Background(), srv.URL+"/page"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("err = %v, want %v", err, ErrBlocked)
+	}
+}
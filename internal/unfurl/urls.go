@@ -0,0 +1,70 @@
+package unfurl
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// ExtractURLs finds up to max distinct http and https URLs in text, in order
+// of appearance and normalised so that they can serve as cache keys.
+func ExtractURLs(text string, max int) []string {
+	var urls []string
+	seen := map[string]bool{}
+
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		if len(urls) >= max {
+			break
+		}
+
+		normalized, ok := Normalize(trimTrailingPunctuation(match))
+		if !ok || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		urls = append(urls, normalized)
+	}
+	return urls
+}
+
+// Normalize lowercases the scheme and host of an http or https URL and drops
+// its fragment. It reports false for anything else.
+func Normalize(raw string) (string, bool) {
+	if len(raw) > 2048 {
+		return "", false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return "", false
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), true
+}
+
+// trimTrailingPunctuation drops the punctuation that usually ends the
+// sentence around a URL rather than the URL itself. A closing parenthesis is
+// kept when the URL opened one, as in Wikipedia links.
+func trimTrailingPunctuation(s string) string {
+	for len(s) > 0 {
+		last := s[len(s)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'\"]}", last) >= 0:
+			s = s[:len(s)-1]
+		case last == ')' && strings.Count(s, "(") < strings.Count(s, ")"):
+			s = s[:len(s)-1]
+		default:
+			return s
+		}
+	}
+	return s
+}